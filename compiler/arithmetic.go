@@ -1,13 +1,47 @@
 package compiler
 
 import (
+	"math/big"
+
 	"github.com/clr1107/lmc-llvm-target/compiler/errors"
 	"github.com/clr1107/lmc-llvm-target/compiler/instructions"
 	"github.com/clr1107/lmc-llvm-target/lmc"
+	"github.com/clr1107/lmc-llvm-target/lmc/optimisation"
 	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
 	"github.com/llir/llvm/ir/value"
 )
 
+// Values of the DIVZERO option.
+const (
+	DivZeroHalt        = iota // dividing by zero halts
+	DivZeroSentinel           // dividing by zero outputs DivZeroOutput, then halts
+	DivZeroUnspecified        // nothing is checked, so the result is unspecified
+)
+
+// Values of the MULDIV option.
+const (
+	MulDivAuto   = iota // repeated addition or subtraction if the operands bound its loop to RepeatLimit trips, otherwise doubling
+	MulDivRepeat        // always repeated addition or subtraction, which is smallest; the default
+	MulDivFast          // always doubling, whose time grows with the number of digits
+)
+
+// RepeatLimit is the most trips of a loop of repeated addition or subtraction
+// the MULDIV option MulDivAuto compiles; past about this many, the doubling
+// routines are faster, despite the larger loop.
+const RepeatLimit = 16
+
+// Values of the ARITH option.
+const (
+	ArithInline = iota // every multiplication, division and remainder has its own copy of its routine
+	ArithShared        // a routine used more than once is shared, see *instructions.Routine; the default
+)
+
+// DivZeroOutput is output, before halting, on dividing by zero if the DIVZERO
+// option is DivZeroSentinel. It is one more than StackOverflowOutput, so the
+// two can be told apart.
+const DivZeroOutput = StackOverflowOutput + 1
+
 func (compiler *Compiler) wrapArithmeticInst(instr ir.Instruction, x value.Value, y value.Value, addr lmc.Address) (*instructions.WArithmeticInst, error) {
 	var xBox *lmc.Mailbox
 	var yBox *lmc.Mailbox
@@ -59,8 +93,188 @@ func (compiler *Compiler) WrapLLInstSub(instr *ir.InstSub) *Compilation {
 	}
 }
 
+// nonNegative returns true if an LL value is known not to be negative, see
+// bound.
+func nonNegative(v value.Value) bool {
+	_, ok := bound(v, false)
+	return ok
+}
+
+// nonZeroConstant returns true if an LL value is an integer constant other
+// than 0.
+func nonZeroConstant(v value.Value) bool {
+	c, ok := v.(*constant.Int)
+	return ok && c.X.Sign() != 0
+}
+
+// bound gives the most the magnitude of an LL value can be, if that is known
+// from the value itself: a constant; a value of, or zero-extended from, fewer
+// bits than a word; or a remainder by a constant. Unsigned, only values known
+// not to be negative are bounded.
+func bound(v value.Value, signed bool) (int64, bool) {
+	switch x := v.(type) {
+	case *constant.Int:
+		if n := new(big.Int).Abs(x.X); n.IsInt64() && (signed || x.X.Sign() >= 0) {
+			return n.Int64(), true
+		}
+	case *ir.InstZExt:
+		if bits := bitSize(x.From.Type()); bits < WordBits {
+			return 1<<bits - 1, true
+		}
+	case *ir.InstURem:
+		if c, ok := x.Y.(*constant.Int); ok && c.X.Sign() > 0 && c.X.IsInt64() {
+			return c.X.Int64() - 1, true
+		}
+	case *ir.InstSRem:
+		if c, ok := x.Y.(*constant.Int); ok && signed && c.X.Sign() != 0 && c.X.IsInt64() {
+			return new(big.Int).Abs(c.X).Int64() - 1, true
+		}
+	}
+
+	if bits := bitSize(v.Type()); signed && bits == 1 {
+		return 1, true
+	} else if signed && bits < WordBits {
+		return 1 << (bits - 1), true
+	}
+
+	return 0, false
+}
+
+// repeated returns true if an operation is to be compiled as a loop of
+// repeated addition or subtraction, as the MULDIV option gives, its operands
+// bounding the loop to trips trips if known is true; and false if by doubling.
+func (compiler *Compiler) repeated(trips int64, known bool) bool {
+	switch compiler.Options.Get("MULDIV").Value.(int) {
+	case MulDivRepeat:
+		return true
+	case MulDivFast:
+		return false
+	default:
+		return known && trips <= RepeatLimit
+	}
+}
+
+// quotientBound gives the most the magnitude of X divided by Y can be, if that
+// is known from the operands, see bound.
+func quotientBound(x value.Value, y value.Value, signed bool) (int64, bool) {
+	n, ok := bound(x, signed)
+	if d, known := bound(y, signed); ok && known && d != 0 && isConstant(y) {
+		return n / d, true
+	}
+
+	return n, ok
+}
+
+// chained returns true if a multiplication counting an LL value will be
+// replaced with `ADD`s by the chaining optimisation, as the OPT and
+// CHAIN_LENGTH options give: if it is a constant not negative.
+func (compiler *Compiler) chained(v value.Value) bool {
+	c, ok := v.(*constant.Int)
+	opt := optimisation.OStrategy(compiler.Options.Get("OPT").Value.(int))
+
+	return ok && opt&optimisation.Chaining != 0 && c.X.Sign() >= 0 && c.X.IsInt64() && c.X.Int64() <= int64(compiler.Options.Get("CHAIN_LENGTH").Value.(int))
+}
+
+// mulRoutine gives the operands of a multiplication, that counted first, and
+// the routine it is compiled with; or false if the other operand is instead
+// added as many times as the first, known not to be negative, see
+// WrapLLInstMul.
+func (compiler *Compiler) mulRoutine(instr *ir.InstMul) (value.Value, value.Value, routineKind, bool) {
+	x, y := instr.X, instr.Y
+	if isConstant(y) {
+		x, y = y, x
+	}
+
+	xBound, xKnown := bound(x, true)
+	if yBound, yKnown := bound(y, true); yKnown && (!xKnown || yBound < xBound) {
+		x, y = y, x
+		xBound, xKnown = yBound, yKnown
+	}
+
+	if !compiler.repeated(xBound, xKnown) {
+		return x, y, routineFastMul, true
+	}
+
+	if !nonNegative(x) || (compiler.sharedArith() && !compiler.chained(x)) {
+		return x, y, routineMul, true
+	}
+
+	return x, y, 0, false
+}
+
+// divRoutine gives the routine a division, or a remainder if rem is true, is
+// compiled with; or false if it is unsigned and by repeated subtraction, see
+// WrapLLInstDiv.
+func (compiler *Compiler) divRoutine(x value.Value, y value.Value, signed bool, rem bool) (routineKind, bool) {
+	if nonNegative(x) && nonNegative(y) {
+		signed = false // the same either way, without the signs
+	}
+
+	if !compiler.repeated(quotientBound(x, y, signed)) {
+		switch {
+		case signed && rem:
+			return routineFastSRem, true
+		case signed:
+			return routineFastSDiv, true
+		case rem:
+			return routineFastURem, true
+		default:
+			return routineFastUDiv, true
+		}
+	}
+
+	switch {
+	case signed && rem:
+		return routineSRem, true
+	case signed:
+		return routineSDiv, true
+	default:
+		return 0, false
+	}
+}
+
+// arithRoutine gives the routine a multiplication, division or remainder is
+// compiled with, if any.
+func (compiler *Compiler) arithRoutine(instr ir.Instruction) (routineKind, bool) {
+	switch x := instr.(type) {
+	case *ir.InstMul:
+		_, _, kind, ok := compiler.mulRoutine(x)
+		return kind, ok
+	case *ir.InstSDiv:
+		return compiler.divRoutine(x.X, x.Y, true, false)
+	case *ir.InstUDiv:
+		return compiler.divRoutine(x.X, x.Y, false, false)
+	case *ir.InstSRem:
+		return compiler.divRoutine(x.X, x.Y, true, true)
+	case *ir.InstURem:
+		return compiler.divRoutine(x.X, x.Y, false, true)
+	default:
+		return 0, false
+	}
+}
+
+// sharedArith returns true if the ARITH option is ArithShared, so arithmetic
+// routines used more than once are shared, as bitwise ones always are.
+func (compiler *Compiler) sharedArith() bool {
+	return compiler.Options.Get("ARITH").Value.(int) == ArithShared
+}
+
+// WrapLLInstMul compiles a multiplication, counting the operand known to be
+// the smaller, see bound, or a constant one. Whether it is by repeated
+// addition or by doubling is as the MULDIV option gives. Repeated, by an
+// operand known not to be negative, the other is added that many times, see
+// *instructions.WInstMul, which the chaining optimisation replaces with `ADD`s
+// if it is a constant; otherwise, or if the ARITH option is ArithShared and it
+// would not be replaced, it is by its routine, see
+// *instructions.Arith#MulBody. By doubling, it is by that routine, see
+// *instructions.Arith#FastMulBody.
 func (compiler *Compiler) WrapLLInstMul(instr *ir.InstMul) *Compilation {
-	if wrapped, err := compiler.wrapArithmeticInst(instr, instr.X, instr.Y, lmc.Address(instr.ID())); err != nil {
+	x, y, kind, ok := compiler.mulRoutine(instr)
+	if ok {
+		return compiler.wrapRoutineCall(instr, kind, x, y, lmc.Address(instr.ID()), nil)
+	}
+
+	if wrapped, err := compiler.wrapArithmeticInst(instr, x, y, lmc.Address(instr.ID())); err != nil {
 		return &Compilation{Err: err}
 	} else {
 		tempOp := compiler.GetTempBox()
@@ -77,7 +291,23 @@ func (compiler *Compiler) WrapLLInstMul(instr *ir.InstMul) *Compilation {
 	}
 }
 
-func (compiler *Compiler) WrapLLInstDiv(instr ir.Instruction, X value.Value, Y value.Value, id int64) *Compilation {
+// WrapLLInstDiv compiles a division, truncating toward zero, by repeated
+// subtraction or by doubling as the MULDIV option gives, see quotientBound.
+// Repeated, unsigned, or signed of operands known not to be negative, each
+// word is taken as unsigned, see *instructions.WInstDiv; otherwise it is by
+// its routine, see *instructions.Arith#DivBody. By doubling, it is by that
+// routine, see *instructions.Arith#FastDivBody. Dividing by zero is as the
+// DIVZERO option gives, see *Compiler#byZero.
+func (compiler *Compiler) WrapLLInstDiv(instr ir.Instruction, X value.Value, Y value.Value, id int64, signed bool) *Compilation {
+	if kind, ok := compiler.divRoutine(X, Y, signed, false); ok {
+		return compiler.wrapRoutineCall(instr, kind, X, Y, lmc.Address(id), nil)
+	}
+
+	byZero, err := compiler.divisorCheck(Y)
+	if err != nil {
+		return &Compilation{Err: err}
+	}
+
 	if wrapped, err := compiler.wrapArithmeticInst(instr, X, Y, lmc.Address(id)); err != nil {
 		return &Compilation{Err: err}
 	} else {
@@ -85,26 +315,133 @@ func (compiler *Compiler) WrapLLInstDiv(instr ir.Instruction, X value.Value, Y v
 		oneOp := compiler.Prog.Memory.Constant(1)
 		labelOp := compiler.Prog.Memory.NewLabel("")
 
-		return &Compilation{Wrapped: instructions.NewWInstDiv(
+		w := instructions.NewWInstDiv(
 			wrapped,
 			tempOp.Boxes[0].Box,
 			oneOp.Boxes[0].Box,
 			labelOp.Labels[0].Label,
 			[]*lmc.MemoryOp{tempOp, oneOp, labelOp},
-		)}
+		)
+
+		w.ByZero = byZero
+		return &Compilation{Wrapped: w}
 	}
 }
 
-func (compiler *Compiler) WrapLLInstRem(instr ir.Instruction, X value.Value, Y value.Value, id int64) *Compilation {
+// WrapLLInstRem compiles a remainder, which has the sign of X, by repeated
+// subtraction or by doubling as for WrapLLInstDiv. Repeated, unsigned, or
+// signed of operands known not to be negative, each word is taken as
+// unsigned, see *instructions.WInstRem; otherwise it is by its routine, see
+// *instructions.Arith#RemBody. Dividing by zero is as for WrapLLInstDiv.
+func (compiler *Compiler) WrapLLInstRem(instr ir.Instruction, X value.Value, Y value.Value, id int64, signed bool) *Compilation {
+	if kind, ok := compiler.divRoutine(X, Y, signed, true); ok {
+		return compiler.wrapRoutineCall(instr, kind, X, Y, lmc.Address(id), nil)
+	}
+
+	byZero, err := compiler.divisorCheck(Y)
+	if err != nil {
+		return &Compilation{Err: err}
+	}
+
 	if wrapped, err := compiler.wrapArithmeticInst(instr, X, Y, lmc.Address(id)); err != nil {
 		return &Compilation{Err: err}
 	} else {
 		labelOp := compiler.Prog.Memory.NewLabel("")
 
-		return &Compilation{Wrapped: instructions.NewWInstRem(
+		w := instructions.NewWInstRem(
 			wrapped,
 			labelOp.Labels[0].Label,
 			[]*lmc.MemoryOp{labelOp},
-		)}
+		)
+
+		w.ByZero = byZero
+		return &Compilation{Wrapped: w}
 	}
 }
+
+// arithBoxes gives the mailboxes the arithmetic routines need, creating them
+// the first time, and those the doubling routines need too if fast is true.
+func (compiler *Compiler) arithBoxes(fast bool) (*instructions.Arith, error) {
+	a := compiler.arith
+	if a == nil {
+		var err error
+		if a, err = compiler.newArithBoxes(); err != nil {
+			return nil, err
+		}
+
+		compiler.arith = a
+	}
+
+	if fast && a.Scaled == nil {
+		for _, box := range []**lmc.Mailbox{&a.Scaled, &a.Count, &a.Diff, &a.Dividend} {
+			var err error
+			if *box, err = compiler.Prog.NewMailbox(compiler.newAddress(), ""); err != nil {
+				return nil, errors.E_LMC("creating arithmetic mailbox", err)
+			}
+		}
+	}
+
+	return a, nil
+}
+
+// newArithBoxes creates the mailboxes every arithmetic routine needs.
+func (compiler *Compiler) newArithBoxes() (*instructions.Arith, error) {
+	a := &instructions.Arith{}
+	var err error
+
+	if a.Zero, err = compiler.Prog.Constant(0); err != nil {
+		return nil, errors.E_LMC("creating arithmetic constant", err)
+	}
+
+	if a.One, err = compiler.Prog.Constant(1); err != nil {
+		return nil, errors.E_LMC("creating arithmetic constant", err)
+	}
+
+	if a.Sign, err = compiler.Prog.NewMailbox(compiler.newAddress(), ""); err != nil {
+		return nil, errors.E_LMC("creating arithmetic mailbox", err)
+	}
+
+	return a, nil
+}
+
+// divisorCheck gives the label branched to if the divisor Y is 0, see byZero,
+// or nil if it is a constant other than 0. It is got before the division's own
+// labels are, as they are only added to memory with it, so would otherwise be
+// given the same identifier.
+func (compiler *Compiler) divisorCheck(Y value.Value) (*lmc.Label, error) {
+	if nonZeroConstant(Y) {
+		return nil, nil
+	}
+
+	return compiler.byZero()
+}
+
+// byZero gives the label branched to on dividing by zero, creating the code
+// there the first time, see *instructions.DivisionByZero; or nil if the DIVZERO
+// option is DivZeroUnspecified, so nothing is checked.
+func (compiler *Compiler) byZero() (*lmc.Label, error) {
+	mode := compiler.Options.Get("DIVZERO").Value.(int)
+	if mode == DivZeroUnspecified {
+		return nil, nil
+	}
+
+	if compiler.divZero != nil {
+		return compiler.divZero.Label, nil
+	}
+
+	d := &instructions.DivisionByZero{}
+	var err error
+
+	if d.Label, err = compiler.Prog.NewLabel(""); err != nil {
+		return nil, errors.E_LMC("creating division by zero label", err)
+	}
+
+	if mode == DivZeroSentinel {
+		if d.Signal, err = compiler.Prog.Constant(DivZeroOutput); err != nil {
+			return nil, errors.E_LMC("creating division by zero output", err)
+		}
+	}
+
+	compiler.divZero = d
+	return d.Label, nil
+}
@@ -0,0 +1,461 @@
+package compiler
+
+import (
+	"github.com/clr1107/lmc-llvm-target/compiler/errors"
+	"github.com/clr1107/lmc-llvm-target/compiler/instructions"
+	"github.com/clr1107/lmc-llvm-target/lmc"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// routineKind is a runtime routine, see *instructions.Routine.
+type routineKind int
+
+const (
+	routineAnd      routineKind = iota // bitwise and, see *instructions.Bits#AndBody
+	routineShr                         // right shift, see *instructions.Bits#ShrBody
+	routineMul                         // multiplication, see *instructions.Arith#MulBody
+	routineSDiv                        // signed division, see *instructions.Arith#DivBody
+	routineSRem                        // signed remainder, see *instructions.Arith#RemBody
+	routineFastMul                     // multiplication by doubling, see *instructions.Arith#FastMulBody
+	routineFastSDiv                    // signed division by doubling, see *instructions.Arith#FastDivBody
+	routineFastSRem                    // signed remainder by doubling
+	routineFastUDiv                    // unsigned division by doubling
+	routineFastURem                    // unsigned remainder by doubling
+)
+
+// routineKinds is every routine, in the order shared ones are compiled.
+var routineKinds = []routineKind{
+	routineAnd, routineShr, routineMul, routineSDiv, routineSRem,
+	routineFastMul, routineFastSDiv, routineFastSRem, routineFastUDiv, routineFastURem,
+}
+
+// isBool returns true if an LL value is an i1.
+func isBool(v value.Value) bool {
+	t, ok := v.Type().(*types.IntType)
+	return ok && t.BitSize == 1
+}
+
+// isAllOnes returns true if an LL value is the constant with every bit set,
+// -1.
+func isAllOnes(v value.Value) bool {
+	c, ok := v.(*constant.Int)
+	return ok && c.X.IsInt64() && c.X.Int64() == -1
+}
+
+// isConstant returns true if an LL value is an integer constant.
+func isConstant(v value.Value) bool {
+	_, ok := v.(*constant.Int)
+	return ok
+}
+
+// routineOf gives the shareable routine an LL instruction is compiled with, if
+// any. Bitwise operations of booleans, complements, and shifts by constants are
+// simpler. Arithmetic routines are only shared if the ARITH option is
+// ArithShared, see *Compiler#arithRoutine.
+func (compiler *Compiler) routineOf(instr ir.Instruction) (routineKind, bool) {
+	switch x := instr.(type) {
+	case *ir.InstAnd:
+		return routineAnd, !isBool(x.X)
+	case *ir.InstOr:
+		return routineAnd, !isBool(x.X)
+	case *ir.InstXor:
+		return routineAnd, !isBool(x.X) && !isAllOnes(x.X) && !isAllOnes(x.Y)
+	case *ir.InstLShr:
+		return routineShr, !isConstant(x.Y)
+	case *ir.InstAShr:
+		return routineShr, !isConstant(x.Y)
+	default:
+		if !compiler.sharedArith() {
+			return 0, false
+		}
+
+		return compiler.arithRoutine(instr)
+	}
+}
+
+// countRoutines counts the uses of each routine by the functions to compile;
+// a routine used more than once is shared. Whether any divides is noted too,
+// see *Compiler#byZero.
+func (compiler *Compiler) countRoutines(funcs []*ir.Func) {
+	for _, f := range funcs {
+		for _, block := range f.Blocks {
+			for _, instr := range block.Insts {
+				if kind, ok := compiler.routineOf(instr); ok {
+					compiler.routineUses[kind]++
+				}
+
+				switch instr.(type) {
+				case *ir.InstSDiv, *ir.InstUDiv, *ir.InstSRem, *ir.InstURem:
+					compiler.divides = true
+				}
+			}
+		}
+	}
+}
+
+// wordBits gives the number of bits, less the sign, the bitwise routines work
+// on: the fewest that hold every value of a word, as wide as the program's
+// capacity makes it; e.g., 9 for words of 3 digits, -500 to 499.
+func (compiler *Compiler) wordBits() int {
+	half := lmc.WordModulus(compiler.Prog.Digits()) / 2
+
+	n := 0
+	for lmc.Value(1)<<n < half {
+		n++
+	}
+
+	return n
+}
+
+// bitBoxes gives the mailboxes the bitwise routines need, creating them the
+// first time.
+func (compiler *Compiler) bitBoxes() (*instructions.Bits, error) {
+	if compiler.bits != nil {
+		return compiler.bits, nil
+	}
+
+	b := &instructions.Bits{}
+	n := compiler.wordBits()
+
+	constants := []struct {
+		box   **lmc.Mailbox
+		value lmc.Value
+	}{
+		{&b.Zero, 0}, {&b.One, 1}, {&b.Two, 2}, {&b.Last, lmc.Value(n - 1)}, {&b.Top, 1 << (n - 1)}, {&b.Span, 1 << n}, {&b.Max, -1},
+	}
+
+	for _, c := range constants {
+		box, err := compiler.Prog.Constant(c.value)
+		if err != nil {
+			return nil, errors.E_LMC("creating bitwise constant", err)
+		}
+
+		*c.box = box
+	}
+
+	for _, box := range []**lmc.Mailbox{&b.Counter, &b.Sign} {
+		var err error
+		if *box, err = compiler.Prog.NewMailbox(compiler.newAddress(), ""); err != nil {
+			return nil, errors.E_LMC("creating bitwise mailbox", err)
+		}
+	}
+
+	compiler.bits = b
+	return b, nil
+}
+
+// routine gives a routine, creating it the first time: mailboxes for its
+// parameters and result, and, if it is shared, its entry and return slot.
+func (compiler *Compiler) routine(kind routineKind) (*instructions.Routine, error) {
+	if r, ok := compiler.routines[kind]; ok {
+		return r, nil
+	}
+
+	r := &instructions.Routine{Shared: compiler.routineUses[kind] > 1}
+
+	for i := 0; i < 3; i++ {
+		box, err := compiler.Prog.NewMailbox(compiler.newAddress(), "")
+		if err != nil {
+			return nil, errors.E_LMC("creating routine mailbox", err)
+		}
+
+		r.Params = append(r.Params, box)
+	}
+
+	r.Params, r.Result = r.Params[:2], r.Params[2]
+
+	if r.Shared {
+		var err error
+		if r.Entry, err = compiler.Prog.NewLabel(""); err != nil {
+			return nil, errors.E_LMC("creating routine label", err)
+		}
+
+		label, err := compiler.Prog.NewLabel("")
+		if err != nil {
+			return nil, errors.E_LMC("creating return slot label", err)
+		}
+
+		if r.Return, err = compiler.Prog.LabelMailbox(label); err != nil {
+			return nil, errors.E_LMC("creating return slot", err)
+		}
+
+		r.Slot = lmc.NewLabelled(label, lmc.NewSlotInstr(0, nil))
+	}
+
+	compiler.routines[kind] = r
+	return r, nil
+}
+
+// routineBody gives a copy of the body of a routine, with its own labels,
+// which falls through to the label end once done. Division routines check for
+// dividing by zero if checked is true, see *Compiler#byZero, and arithmetic
+// ones leave the signs of the parameters known not to be negative.
+func (compiler *Compiler) routineBody(kind routineKind, r *instructions.Routine, end *lmc.Label, checked bool, known instructions.Known) ([]lmc.Instruction, error) {
+	n := map[routineKind]int{
+		routineAnd:  instructions.AndLabels,
+		routineShr:  instructions.ShrLabels,
+		routineMul:  instructions.MulLabels,
+		routineSDiv: instructions.DivLabels,
+		routineSRem: instructions.RemLabels,
+
+		routineFastMul:  instructions.FastMulLabels,
+		routineFastSDiv: instructions.FastDivLabels,
+		routineFastSRem: instructions.FastDivLabels,
+		routineFastUDiv: instructions.FastDivLabels,
+		routineFastURem: instructions.FastDivLabels,
+	}[kind]
+
+	labels := make([]*lmc.Label, n)
+	for k := range labels {
+		var err error
+		if labels[k], err = compiler.Prog.NewLabel(""); err != nil {
+			return nil, errors.E_LMC("creating routine label", err)
+		}
+	}
+
+	x, y := r.Params[0], r.Params[1]
+
+	switch kind {
+	case routineAnd, routineShr:
+		b, err := compiler.bitBoxes()
+		if err != nil {
+			return nil, err
+		}
+
+		if kind == routineShr {
+			return b.ShrBody(x, y, r.Result, labels, end), nil
+		}
+
+		return b.AndBody(x, y, r.Result, labels, end), nil
+	default:
+		a, err := compiler.arithBoxes(kind >= routineFastMul)
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case routineMul:
+			return a.MulBody(x, y, r.Result, known, labels, end), nil
+		case routineFastMul:
+			return a.FastMulBody(x, y, r.Result, known, labels, end), nil
+		}
+
+		var byZero *lmc.Label
+		if checked {
+			if byZero, err = compiler.byZero(); err != nil {
+				return nil, err
+			}
+		}
+
+		switch kind {
+		case routineSDiv:
+			return a.DivBody(x, y, r.Result, byZero, known, labels, end), nil
+		case routineSRem:
+			return a.RemBody(x, y, r.Result, byZero, known, labels, end), nil
+		}
+
+		signed := kind == routineFastSDiv || kind == routineFastSRem
+		rem := kind == routineFastSRem || kind == routineFastURem
+
+		return a.FastDivBody(x, y, r.Result, byZero, signed, rem, known, labels, end), nil
+	}
+}
+
+// sharedRoutines gives the instructions of every shared routine, which are
+// compiled after the entry function.
+func (compiler *Compiler) sharedRoutines() ([]lmc.Instruction, error) {
+	var instrs []lmc.Instruction
+
+	for _, kind := range routineKinds {
+		if compiler.routineUses[kind] < 2 {
+			continue
+		}
+
+		r, err := compiler.routine(kind)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := compiler.routineBody(kind, r, r.Slot.Label(), true, instructions.Known{})
+		if err != nil {
+			return nil, err
+		}
+
+		instrs = append(instrs, r.Instructions(body)...)
+	}
+
+	return instrs, nil
+}
+
+// wrapRoutineCall compiles an instruction as a use of a routine, with the
+// operands X and Y as its arguments, see *instructions.WRoutineCall. Its result
+// is then combined into the instruction's mailbox by the instructions given by
+// after; the result itself by default.
+func (compiler *Compiler) wrapRoutineCall(instr ir.Instruction, kind routineKind, x value.Value, y value.Value, addr lmc.Address, after func(w *instructions.WArithmeticInst, result *lmc.Mailbox) []lmc.Instruction) *Compilation {
+	wrapped, err := compiler.wrapArithmeticInst(instr, x, y, addr)
+	if err != nil {
+		return &Compilation{Err: err}
+	}
+
+	r, err := compiler.routine(kind)
+	if err != nil {
+		return &Compilation{Err: err}
+	}
+
+	ret, err := compiler.Prog.NewLabel("")
+	if err != nil {
+		return &Compilation{Err: errors.E_LMC("creating return label", err)}
+	}
+
+	combine := []lmc.Instruction{lmc.NewLoadInstr(r.Result), lmc.NewStoreInstr(wrapped.Dst)}
+	if after != nil {
+		combine = after(wrapped, r.Result)
+	}
+
+	w := instructions.NewWRoutineCall(instr, r, []*lmc.Mailbox{wrapped.X, wrapped.Y}, ret, combine, wrapped.LMCOps())
+
+	if r.Shared {
+		if w.Word, err = compiler.Prog.Word(lmc.NewBranchInstr(lmc.BRAlways, ret)); err != nil {
+			return &Compilation{Err: errors.E_LMC("creating return word", err)}
+		}
+
+		slot := lmc.Unwrap(r.Slot).(*lmc.SlotInstr)
+		slot.Targets = append(slot.Targets, ret)
+	} else if w.Body, err = compiler.routineBody(kind, r, ret, !nonZeroConstant(y), instructions.Known{X: nonNegative(x), Y: nonNegative(y)}); err != nil {
+		return &Compilation{Err: err}
+	}
+
+	return &Compilation{Wrapped: w}
+}
+
+// WrapLLInstBitwise compiles an and, or, or xor. Of booleans, these are simple
+// branches, see *instructions.WInstLogic; and an xor with -1 is a complement.
+// Otherwise they are all computed from the and of the operands, by its routine:
+// X | Y is X + Y - (X & Y), and X ^ Y is X + Y - 2(X & Y).
+func (compiler *Compiler) WrapLLInstBitwise(instr ir.Instruction, op instructions.LogicOp, x value.Value, y value.Value, id int64) *Compilation {
+	addr := lmc.Address(id)
+
+	if isBool(x) {
+		wrapped, err := compiler.wrapArithmeticInst(instr, x, y, addr)
+		if err != nil {
+			return &Compilation{Err: err}
+		}
+
+		oneOp := compiler.Prog.Memory.Constant(1)
+		labelOp := compiler.Prog.Memory.NewLabel("")
+
+		return &Compilation{Wrapped: instructions.NewWInstLogic(wrapped, op, oneOp.Boxes[0].Box,
+			labelOp.Labels[0].Label, []*lmc.MemoryOp{oneOp, labelOp})}
+	}
+
+	if op == instructions.LogicXor && (isAllOnes(x) || isAllOnes(y)) {
+		if isAllOnes(x) {
+			x, y = y, x
+		}
+
+		wrapped, err := compiler.wrapArithmeticInst(instr, x, y, addr)
+		if err != nil {
+			return &Compilation{Err: err}
+		}
+
+		maxOp := compiler.Prog.Memory.Constant(-1)
+		return &Compilation{Wrapped: instructions.NewWInstNot(wrapped, maxOp.Boxes[0].Box, []*lmc.MemoryOp{maxOp})}
+	}
+
+	var after func(w *instructions.WArithmeticInst, result *lmc.Mailbox) []lmc.Instruction
+
+	if op != instructions.LogicAnd {
+		after = func(w *instructions.WArithmeticInst, result *lmc.Mailbox) []lmc.Instruction {
+			instrs := []lmc.Instruction{
+				lmc.NewLoadInstr(w.X),
+				lmc.NewAddInstr(w.Y),
+				lmc.NewSubInstr(result),
+			}
+
+			if op == instructions.LogicXor {
+				instrs = append(instrs, lmc.NewSubInstr(result))
+			}
+
+			return append(instrs, lmc.NewStoreInstr(w.Dst))
+		}
+	}
+
+	return compiler.wrapRoutineCall(instr, routineAnd, x, y, addr, after)
+}
+
+// WrapLLInstShl compiles a left shift by doubling, see *instructions.WInstShl.
+func (compiler *Compiler) WrapLLInstShl(instr *ir.InstShl) *Compilation {
+	wrapped, err := compiler.wrapArithmeticInst(instr, instr.X, instr.Y, lmc.Address(instr.ID()))
+	if err != nil {
+		return &Compilation{Err: err}
+	}
+
+	if c, ok := instr.Y.(*constant.Int); ok {
+		return &Compilation{Wrapped: instructions.NewWInstShl(wrapped, int(c.X.Int64()))}
+	}
+
+	w := instructions.NewWInstShl(wrapped, 0)
+
+	loop, err := compiler.Prog.NewLabel("")
+	if err != nil {
+		return &Compilation{Err: errors.E_LMC("creating shift label", err)}
+	}
+
+	test, err := compiler.Prog.NewLabel("")
+	if err != nil {
+		return &Compilation{Err: errors.E_LMC("creating shift label", err)}
+	}
+
+	tempOp := compiler.GetTempBox()
+	oneOp := compiler.Prog.Memory.Constant(1)
+
+	w.Variable(tempOp.Boxes[0].Box, oneOp.Boxes[0].Box, loop, test, []*lmc.MemoryOp{tempOp, oneOp})
+
+	return &Compilation{Wrapped: w}
+}
+
+// WrapLLInstShr compiles a right shift, logical or arithmetic. Both round down,
+// so a logical shift of a negative value is as if it were arithmetic, there
+// being no unsigned values wider than a word, see *Compiler#wordBits. A shift
+// by a constant is a division, see *instructions.WInstShr; otherwise it is by
+// its routine.
+func (compiler *Compiler) WrapLLInstShr(instr ir.Instruction, x value.Value, y value.Value, id int64) *Compilation {
+	c, ok := y.(*constant.Int)
+	if !ok {
+		return compiler.wrapRoutineCall(instr, routineShr, x, y, lmc.Address(id), nil)
+	}
+
+	wrapped, err := compiler.wrapArithmeticInst(instr, x, y, lmc.Address(id))
+	if err != nil {
+		return &Compilation{Err: err}
+	}
+
+	count := c.X.Int64()
+	if count == 0 {
+		return &Compilation{Wrapped: instructions.NewWInstShl(wrapped, 0)}
+	}
+
+	labels := make([]*lmc.Label, instructions.WInstShrLabels)
+	for k := range labels {
+		if labels[k], err = compiler.Prog.NewLabel(""); err != nil {
+			return &Compilation{Err: errors.E_LMC("creating shift label", err)}
+		}
+	}
+
+	if n := int64(compiler.wordBits()); count > n {
+		count = n // every value of a word is then 0, or -1
+	}
+
+	tempOp := compiler.GetTempBox()
+	divisorOp := compiler.Prog.Memory.Constant(lmc.Value(1) << count)
+	zeroOp := compiler.Prog.Memory.Constant(0)
+	oneOp := compiler.Prog.Memory.Constant(1)
+	maxOp := compiler.Prog.Memory.Constant(-1)
+
+	return &Compilation{Wrapped: instructions.NewWInstShr(wrapped, tempOp.Boxes[0].Box, divisorOp.Boxes[0].Box,
+		zeroOp.Boxes[0].Box, oneOp.Boxes[0].Box, maxOp.Boxes[0].Box, labels,
+		[]*lmc.MemoryOp{tempOp, divisorOp, zeroOp, oneOp, maxOp})}
+}
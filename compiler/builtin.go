@@ -29,6 +29,12 @@ func wrapBuiltinFunc(name string, instr *ir.InstCall, params []*lmc.Mailbox, ops
 	}
 }
 
+// writesParam returns true if a builtin stores to the mailbox it is given,
+// rather than loading from it.
+func writesParam(name string) bool {
+	return name == "input" || name == "sta"
+}
+
 func (compiler *Compiler) WrapLLInstCall(instr *ir.InstCall) *Compilation {
 	var f *ir.Func
 	var w *instructions.WBuiltinCall
@@ -51,19 +57,56 @@ func (compiler *Compiler) WrapLLInstCall(instr *ir.InstCall) *Compilation {
 		}
 	}
 
+	if len(f.Blocks) > 0 {
+		return compiler.wrapCall(instr, f)
+	}
+
+	var before, after []lmc.Instruction
+
 	for _, a := range instr.Args {
-		if op, err = compiler.GetMailboxFromLL(a); err != nil {
+		e, err := compiler.element(a)
+		if err != nil {
 			return &Compilation{Err: err}
 		}
 
+		if e == nil {
+			if op, err = compiler.GetMailboxFromLL(a); err != nil {
+				return &Compilation{Err: err}
+			}
+
+			ops = append(ops, op)
+			params = append(params, op.Boxes[0].Box)
+			continue
+		}
+
+		// an element is copied through the temp mailbox, unless it is the first
+		indexed, err := compiler.indexed(e, writesParam(f.Name()))
+		if err != nil {
+			return &Compilation{Err: err}
+		} else if indexed == nil {
+			params = append(params, e.array)
+			continue
+		}
+
+		op = compiler.GetTempBox()
+		temp := op.Boxes[0].Box
+
 		ops = append(ops, op)
-		params = append(params, op.Boxes[0].Box)
+		params = append(params, temp)
+
+		if writesParam(f.Name()) {
+			after = indexed.Instructions([]lmc.Instruction{lmc.NewLoadInstr(temp)}, nil)
+		} else {
+			before = indexed.Instructions(nil, []lmc.Instruction{lmc.NewStoreInstr(temp)})
+		}
 	}
 
 	if w = wrapBuiltinFunc(f.Name(), instr, params, ops); w == nil {
 		return &Compilation{Err: errors.E_UnknownBuiltin(f, nil)}
 	}
 
+	w.Before, w.After = before, after
+
 	if err = w.Invoke(); err != nil {
 		return &Compilation{Err: err}
 	}
@@ -0,0 +1,560 @@
+package compiler
+
+import (
+	"fmt"
+	"github.com/clr1107/lmc-llvm-target/compiler/errors"
+	"github.com/clr1107/lmc-llvm-target/compiler/instructions"
+	"github.com/clr1107/lmc-llvm-target/lmc"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Values of the CALLS option.
+const (
+	CallsInline     = iota // every call is inlined, see InlineCalls
+	CallsSubroutine        // every function called is compiled once
+)
+
+// StackOverflowOutput is output, before halting, if the software stack
+// overflows. It is the least value of classic LMC, as a signed number.
+const StackOverflowOutput = -500
+
+// subroutine is a function compiled once, and called by storing the word of a
+// branch back to the caller in its return slot, Slot, then branching to Entry.
+// Arguments are passed in the mailboxes of its parameters, Params, and its
+// result, if it has one, in Result. It returns by branching to the slot.
+type subroutine struct {
+	Entry  *lmc.Label
+	Params []*lmc.Mailbox
+	Result *lmc.Mailbox
+	Slot   *lmc.Labelled
+	Return *lmc.Mailbox // the slot's mailbox
+}
+
+// calledFuncs gives every function with a body called by a function, in the
+// order they are first called.
+func calledFuncs(f *ir.Func) []*ir.Func {
+	var funcs []*ir.Func
+	seen := make(map[*ir.Func]struct{})
+
+	for _, block := range f.Blocks {
+		for _, instr := range block.Insts {
+			call, ok := instr.(*ir.InstCall)
+			if !ok {
+				continue
+			}
+
+			callee, ok := call.Callee.(*ir.Func)
+			if !ok || len(callee.Blocks) == 0 {
+				continue
+			}
+
+			if _, ok := seen[callee]; !ok {
+				seen[callee] = struct{}{}
+				funcs = append(funcs, callee)
+			}
+		}
+	}
+
+	return funcs
+}
+
+// numberLocals assigns the local IDs of a function again, starting from base
+// rather than 0, so that they are distinct from those of other functions, as
+// mailboxes are given by ID. Gives the ID after the last.
+func numberLocals(f *ir.Func, base int64) (int64, error) {
+	type local interface {
+		IsUnnamed() bool
+		ID() int64
+		SetID(id int64)
+	}
+
+	var locals []local
+
+	add := func(x interface{}) {
+		if l, ok := x.(local); ok && l.IsUnnamed() {
+			l.SetID(0)
+			locals = append(locals, l)
+		}
+	}
+
+	for _, param := range f.Params {
+		add(param)
+	}
+
+	for _, block := range f.Blocks {
+		add(block)
+
+		for _, instr := range block.Insts {
+			add(instr)
+		}
+	}
+
+	if err := f.AssignIDs(); err != nil {
+		return base, err
+	}
+
+	next := base
+	for _, l := range locals {
+		l.SetID(l.ID() + base)
+
+		if l.ID() >= next {
+			next = l.ID() + 1
+		}
+	}
+
+	return next, nil
+}
+
+// Functions gives the functions to compile, the entry first, as chosen by the
+// CALLS option; so options must be read first, see *Compiler#ReadOptions.
+// Either every call is inlined into the entry, see InlineCalls, or every
+// function called is compiled once, as a subroutine. Recursion is an error,
+// unless functions are subroutines and the STACK option gives the size of a
+// software stack to save them on, see *instructions.Frame; the entry function
+// may never be called though. Each function must then be compiled in order,
+// see *Compiler#BeginFunc. The uses of runtime routines are counted, to decide
+// which are shared, see *instructions.Routine.
+func (compiler *Compiler) Functions(entry *ir.Func) ([]*ir.Func, error) {
+	compiler.entry = entry
+
+	if compiler.Options.Get("CALLS").Value.(int) == CallsInline {
+		if err := InlineCalls(entry); err != nil {
+			return nil, err
+		}
+
+		next, err := numberLocals(entry, 0)
+		compiler.freeAddr = lmc.Address(next)
+		compiler.countRoutines([]*ir.Func{entry})
+
+		return []*ir.Func{entry}, err
+	}
+
+	var funcs []*ir.Func
+	var recursive bool
+	seen := make(map[*ir.Func]struct{})
+	size := compiler.Options.Get("STACK").Value.(int)
+
+	var visit func(f *ir.Func, chain []string) error
+	visit = func(f *ir.Func, chain []string) error {
+		chain = append(append([]string{}, chain...), f.Name())
+		for _, name := range chain[:len(chain)-1] {
+			if name != f.Name() {
+				continue
+			} else if f == entry {
+				return errors.E_Recursion(chain, fmt.Errorf("the entry function cannot be called"))
+			} else if size == 0 {
+				return errors.E_Recursion(chain, fmt.Errorf("there is no software stack, see the STACK option"))
+			}
+
+			// already visited, as it is in the chain
+			recursive = true
+			return nil
+		}
+
+		if _, ok := seen[f]; ok {
+			return nil
+		}
+
+		seen[f] = struct{}{}
+		funcs = append(funcs, f)
+
+		for _, callee := range calledFuncs(f) {
+			if err := visit(callee, chain); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := visit(entry, nil); err != nil {
+		return nil, err
+	}
+
+	// before the locals are numbered again, so errors name parameters as they
+	// are in the source
+	for _, f := range funcs[1:] {
+		if err := checkSignature(f); err != nil {
+			return nil, err
+		}
+	}
+
+	var next int64
+
+	for _, f := range funcs {
+		var err error
+		if next, err = numberLocals(f, next); err != nil {
+			return nil, errors.E_Err(fmt.Sprintf("assigning local IDs of %s", f.Ident()), err)
+		}
+	}
+
+	compiler.freeAddr = lmc.Address(next)
+	compiler.countRoutines(funcs)
+
+	if recursive {
+		if err := compiler.newStack(size); err != nil {
+			return nil, err
+		}
+	}
+
+	return funcs, nil
+}
+
+// checkSignature returns an error if a function cannot be a subroutine, as a
+// parameter or its result is not an integer, which is all a mailbox holds.
+func checkSignature(f *ir.Func) error {
+	for _, param := range f.Params {
+		if !types.IsInt(param.Type()) {
+			return errors.E_Unsupported(fmt.Sprintf("parameter %s of subroutine %s is not an integer", param.Ident(), f.Ident()), nil)
+		}
+	}
+
+	if !types.Equal(f.Sig.RetType, types.Void) && !types.IsInt(f.Sig.RetType) {
+		return errors.E_Unsupported(fmt.Sprintf("result of subroutine %s is not an integer", f.Ident()), nil)
+	}
+
+	return nil
+}
+
+// newStack creates the software stack, of the given number of mailboxes, and
+// the code to branch to if it overflows; see *instructions.Stack.
+func (compiler *Compiler) newStack(size int) error {
+	s := &instructions.Stack{Size: size}
+	var err error
+
+	if s.Box, err = compiler.Prog.NewArray(-1, "s_STACK", make([]lmc.Value, size)); err != nil {
+		return errors.E_LMC("creating software stack", err)
+	}
+
+	if s.Pointer, err = compiler.Prog.NewMailbox(-1, "s_SP"); err != nil {
+		return errors.E_LMC("creating stack pointer", err)
+	}
+
+	if s.Store, err = compiler.Prog.Word(lmc.NewStoreInstr(s.Box)); err != nil {
+		return errors.E_LMC("creating stack store word", err)
+	}
+
+	if s.Load, err = compiler.Prog.Word(lmc.NewLoadInstr(s.Box)); err != nil {
+		return errors.E_LMC("creating stack load word", err)
+	}
+
+	if s.Overflow, err = compiler.Prog.NewLabel(""); err != nil {
+		return errors.E_LMC("creating stack overflow label", err)
+	}
+
+	if s.Signal, err = compiler.Prog.Constant(StackOverflowOutput); err != nil {
+		return errors.E_LMC("creating stack overflow output", err)
+	}
+
+	compiler.stack = s
+	return nil
+}
+
+// reaches returns true if a function may call another, directly or not.
+func reaches(from *ir.Func, to *ir.Func) bool {
+	seen := make(map[*ir.Func]struct{})
+	work := []*ir.Func{from}
+
+	for len(work) > 0 {
+		f := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		for _, callee := range calledFuncs(f) {
+			if callee == to {
+				return true
+			} else if _, ok := seen[callee]; !ok {
+				seen[callee] = struct{}{}
+				work = append(work, callee)
+			}
+		}
+	}
+
+	return false
+}
+
+// liveAcross gives the parameters and instructions of a function that may be
+// used after a call in one of its blocks, before they are defined again; i.e.,
+// whose mailboxes must be saved over the call if it is recursive. Values used
+// by a PHI are used by every edge to it. An icmp fused with a branch (see
+// fusableCmp) has no mailbox, so its operands are used by the branch instead.
+func liveAcross(f *ir.Func, block *ir.Block, call *ir.InstCall) []value.Value {
+	var candidates []value.Value
+	fused := make(map[*ir.Block]*ir.InstICmp)
+
+	for _, param := range f.Params {
+		candidates = append(candidates, param)
+	}
+
+	for _, b := range f.Blocks {
+		fused[b] = fusableCmp(b)
+
+		for _, instr := range b.Insts {
+			if v, ok := instr.(value.Value); ok && instr != call && instr != fused[b] && ValidLLType(v.Type()) {
+				candidates = append(candidates, v)
+			}
+		}
+	}
+
+	start := 0
+	for k, instr := range block.Insts {
+		if instr == call {
+			start = k + 1
+		}
+	}
+
+	used := func(v value.Value) bool {
+		type point struct {
+			block *ir.Block
+			start int
+		}
+
+		seen := make(map[*ir.Block]struct{})
+
+		for work := []point{{block, start}}; len(work) > 0; {
+			p := work[len(work)-1]
+			work = work[:len(work)-1]
+
+			defined := false
+			for _, instr := range p.block.Insts[p.start:] {
+				if x, ok := instr.(value.Value); ok && x == v {
+					defined = true
+					break
+				} else if ReflectUses(instr, v) {
+					return true
+				}
+			}
+
+			if defined {
+				continue
+			} else if ReflectUses(p.block.Term, v) || (fused[p.block] != nil && ReflectUses(fused[p.block], v)) {
+				return true
+			}
+
+			for _, s := range p.block.Term.Succs() {
+				if _, ok := seen[s]; !ok {
+					seen[s] = struct{}{}
+					work = append(work, point{s, 0})
+				}
+			}
+		}
+
+		return false
+	}
+
+	var live []value.Value
+	for _, v := range candidates {
+		if used(v) {
+			live = append(live, v)
+		}
+	}
+
+	return live
+}
+
+// newAddress gives an address that no local of any function compiled has, for
+// mailboxes the compiler needs, e.g., the results of subroutines.
+func (compiler *Compiler) newAddress() lmc.Address {
+	addr := compiler.freeAddr
+	compiler.freeAddr++
+
+	return addr
+}
+
+// subroutine gives the subroutine of a function, creating it the first time:
+// the label of its entry block, and mailboxes for its parameters, result, and
+// return slot. It may be called before the function is compiled.
+func (compiler *Compiler) subroutine(f *ir.Func) (*subroutine, error) {
+	if s, ok := compiler.subroutines[f]; ok {
+		return s, nil
+	}
+
+	s := &subroutine{}
+
+	var err error
+	if s.Entry, err = compiler.BlockLabel(f.Blocks[0]); err != nil {
+		return nil, err
+	}
+
+	// the signature was checked by *Compiler#Functions
+	for _, param := range f.Params {
+		box, err := compiler.Prog.NewMailbox(lmc.Address(param.ID()), "")
+		if err != nil {
+			return nil, errors.E_LMC("creating parameter mailbox", err)
+		}
+
+		s.Params = append(s.Params, box)
+	}
+
+	if !types.Equal(f.Sig.RetType, types.Void) {
+		if s.Result, err = compiler.Prog.NewMailbox(compiler.newAddress(), ""); err != nil {
+			return nil, errors.E_LMC("creating result mailbox", err)
+		}
+	}
+
+	label, err := compiler.Prog.NewLabel("")
+	if err != nil {
+		return nil, errors.E_LMC("creating return slot label", err)
+	}
+
+	if s.Return, err = compiler.Prog.LabelMailbox(label); err != nil {
+		return nil, errors.E_LMC("creating return slot", err)
+	}
+
+	s.Slot = lmc.NewLabelled(label, lmc.NewSlotInstr(0, nil))
+
+	compiler.subroutines[f] = s
+	return s, nil
+}
+
+// BeginFunc must be called before compiling the blocks of each function given
+// by *Compiler#Functions, in order, and EndFunc after.
+func (compiler *Compiler) BeginFunc(f *ir.Func) error {
+	compiler.function = f
+	compiler.sub = nil
+
+	if f != compiler.entry {
+		s, err := compiler.subroutine(f)
+		if err != nil {
+			return err
+		}
+
+		compiler.sub = s
+	}
+
+	return nil
+}
+
+// wrapCall compiles a call to a subroutine, see *instructions.WCall. Each call
+// returns to its own label, so is a target of the subroutine's slot.
+func (compiler *Compiler) wrapCall(instr *ir.InstCall, f *ir.Func) *Compilation {
+	s, err := compiler.subroutine(f)
+	if err != nil {
+		return &Compilation{Err: err}
+	}
+
+	ret, err := compiler.Prog.NewLabel("")
+	if err != nil {
+		return &Compilation{Err: errors.E_LMC("creating return label", err)}
+	}
+
+	word, err := compiler.Prog.Word(lmc.NewBranchInstr(lmc.BRAlways, ret))
+	if err != nil {
+		return &Compilation{Err: errors.E_LMC("creating return word", err)}
+	}
+
+	var ops []*lmc.MemoryOp
+	var args []*lmc.Mailbox
+
+	for _, a := range instr.Args {
+		op, err := compiler.GetMailboxFromLL(a)
+		if err != nil {
+			return &Compilation{Err: err}
+		}
+
+		ops = append(ops, op)
+		args = append(args, op.Boxes[0].Box)
+	}
+
+	w := instructions.NewWCall(instr, s.Entry, s.Return, word, ret, ops)
+	w.Args, w.Params = args, s.Params
+
+	if s.Result != nil {
+		addr := lmc.Address(instr.ID())
+
+		w.Result = s.Result
+		if w.Dst = compiler.Prog.Memory.GetMailboxAddress(addr); w.Dst == nil {
+			op := compiler.Prog.Memory.NewMailbox(addr, "")
+			w.Dst = op.Boxes[0].Box
+			w.AddMemoryOps(op)
+		}
+	}
+
+	if compiler.stack != nil && compiler.sub != nil && reaches(f, compiler.function) {
+		frame, ops, err := compiler.frame(instr)
+		if err != nil {
+			return &Compilation{Err: err}
+		}
+
+		w.Frame = frame
+		w.AddMemoryOps(ops...)
+	}
+
+	slot := lmc.Unwrap(s.Slot).(*lmc.SlotInstr)
+	slot.Targets = append(slot.Targets, ret)
+
+	return &Compilation{Wrapped: w}
+}
+
+// frame gives the frame saved over a recursive call in the current block: the
+// caller's return slot, and every value live across the call. Their mailboxes
+// are created if they have not been yet, e.g., for a value defined in a block
+// compiled later but run first. Local arrays, and their elements, cannot be
+// saved.
+func (compiler *Compiler) frame(instr *ir.InstCall) (*instructions.Frame, []*lmc.MemoryOp, error) {
+	f := &instructions.Frame{
+		Stack: compiler.stack,
+		Boxes: []*lmc.Mailbox{compiler.sub.Return},
+	}
+
+	var ops []*lmc.MemoryOp
+
+	for _, v := range liveAcross(compiler.function, compiler.block, instr) {
+		var op *lmc.MemoryOp
+		var err error
+
+		if a, ok := elementRoot(v).(*ir.InstAlloca); ok && !types.IsInt(a.ElemType) {
+			return nil, nil, errors.E_Unsupported(fmt.Sprintf("saving the array `%s` over a recursive call", a.LLString()), nil)
+		}
+
+		if phi, ok := v.(*ir.InstPhi); ok {
+			op, err = compiler.phiBox(phi)
+		} else if id, e := ReflectGetLocalID(v); e != nil {
+			err = e
+		} else if box := compiler.Prog.Memory.GetMailboxAddress(id); box != nil {
+			op = lmc.NewMemoryOpBox1(box, false)
+		} else {
+			op = compiler.Prog.Memory.NewMailbox(id, "")
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ops = append(ops, op)
+		f.Boxes = append(f.Boxes, op.Boxes[0].Box)
+	}
+
+	var err error
+
+	for k := range f.Boxes {
+		var offset *lmc.Mailbox
+		if k > 0 {
+			if offset, err = compiler.Prog.Constant(lmc.Value(k)); err != nil {
+				return nil, nil, errors.E_LMC("creating frame offset", err)
+			}
+		}
+
+		f.Offsets = append(f.Offsets, offset)
+
+		for _, slots := range []*[]*instructions.Slot{&f.PushSlots, &f.PopSlots} {
+			slot, err := compiler.newSlot()
+			if err != nil {
+				return nil, nil, err
+			}
+
+			*slots = append(*slots, slot)
+		}
+	}
+
+	if f.Length, err = compiler.Prog.Constant(lmc.Value(len(f.Boxes))); err != nil {
+		return nil, nil, errors.E_LMC("creating frame length", err)
+	}
+
+	if f.Limit, err = compiler.Prog.Constant(lmc.Value(compiler.stack.Size - len(f.Boxes) + 1)); err != nil {
+		return nil, nil, errors.E_LMC("creating stack limit", err)
+	}
+
+	return f, ops, nil
+}
@@ -0,0 +1,560 @@
+package compiler_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/clr1107/lmc-llvm-target/compiler"
+	"github.com/clr1107/lmc-llvm-target/lmc"
+	"github.com/clr1107/lmc-llvm-target/lmc/optimisation"
+	"github.com/clr1107/lmc-llvm-target/lmc/vm"
+	"github.com/llir/llvm/asm"
+	"github.com/llir/llvm/ir"
+)
+
+// compileTest is a fixture of testdata, compiled with the options given (on
+// top of any it sets itself), and run with each set of inputs.
+type compileTest struct {
+	name    string
+	file    string
+	options map[string]int
+	inputs  [][]lmc.Value
+	outputs [][]lmc.Value
+}
+
+// compile compiles and optimises a fixture as compiler/testing/compile.go
+// does, failing the test if it does not compile or fit in its capacity.
+func compile(t *testing.T, file string, options map[string]int) *lmc.Program {
+	t.Helper()
+
+	mod, err := asm.ParseFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatalf("could not parse: %s", err)
+	}
+
+	f := compiler.GetLLEntry(mod)
+	if f == nil {
+		t.Fatal("could not find entry function")
+	}
+
+	comp := compiler.NewCompiler(lmc.NewProgram(lmc.NewBasicMemory()))
+	comp.Module = mod
+	engine := compiler.NewEngine(comp)
+
+	handle := func(c *compiler.Compilation, lls ...ir.LLStringer) {
+		t.Helper()
+
+		if c.Err != nil {
+			t.Fatalf("could not compile %s: %s", lls[0].LLString(), c.Err)
+		} else if err := comp.AddCompiledInstruction(c.Wrapped); err != nil {
+			t.Fatalf("could not add %s: %s", lls[0].LLString(), err)
+		}
+	}
+
+	for _, c := range comp.ReadOptions(f) {
+		handle(c, c.Wrapped.LLBase()[0])
+	}
+
+	// the test's options are set last, so they override the fixture's
+	for k, v := range options {
+		if comp.Options.Set(k, v) == nil {
+			t.Fatalf("could not set option %s to %d", k, v)
+		}
+	}
+
+	funcs, err := comp.Functions(f)
+	if err != nil {
+		t.Fatalf("could not compile calls: %s", err)
+	}
+
+	for _, fn := range funcs {
+		if err := comp.BeginFunc(fn); err != nil {
+			t.Fatalf("could not begin function %s: %s", fn.Ident(), err)
+		}
+
+		for k, block := range fn.Blocks {
+			if err := comp.BeginBlock(block); err != nil {
+				t.Fatalf("could not begin block %s: %s", block.Ident(), err)
+			}
+
+			matches, err := engine.FindAll(block.Insts)
+			if err != nil {
+				t.Fatalf("could not match instructions: %s", err)
+			}
+
+			for _, m := range matches {
+				lls := make([]ir.LLStringer, len(m.Instrs))
+				for j, i := range m.Instrs {
+					lls[j] = i
+				}
+
+				handle(m.Pattern.Compile(m.Instrs), lls...)
+			}
+
+			var next *ir.Block
+			if k+1 < len(fn.Blocks) {
+				next = fn.Blocks[k+1]
+			}
+
+			handle(comp.WrapLLTerm(block.Term, block, next), block.Term)
+		}
+
+		if err := comp.EndFunc(); err != nil {
+			t.Fatalf("could not end function %s: %s", fn.Ident(), err)
+		}
+	}
+
+	var strategies []optimisation.OStrategy
+
+	optValue := optimisation.OStrategy(comp.Options.Get("OPT").Value.(int))
+	order := []optimisation.OStrategy{
+		optimisation.Chaining,
+		optimisation.Thrashing,
+		optimisation.Clean,
+		optimisation.BProp,
+		optimisation.DeadStore,
+		optimisation.Unroll,
+		optimisation.BProp,
+		optimisation.DeadStore,
+		optimisation.Coalesce,
+	}
+
+	for _, s := range order {
+		if optValue&s != 0 {
+			strategies = append(strategies, s)
+		}
+	}
+
+	if optValue&optimisation.DeadStore != 0 && optValue&optimisation.Thrashing != 0 {
+		strategies = append(strategies, optimisation.Thrashing)
+	}
+
+	optimiser := optimisation.NewStackingOptimiser(comp.Prog, strategies)
+	optimiser.ChainLength = comp.Options.Get("CHAIN_LENGTH").Value.(int)
+	optimiser.UnrollLength = comp.Options.Get("UNROLL_LENGTH").Value.(int)
+
+	if err := optimiser.Optimise(); err != nil {
+		t.Fatalf("could not optimise: %s", err)
+	}
+
+	if err := comp.CheckCapacity(); err != nil {
+		t.Fatalf("%s\n%s", err, comp.Prog)
+	}
+
+	return comp.Prog
+}
+
+// testCompile compiles each fixture and checks what it outputs for each set
+// of inputs. Every run must halt.
+func testCompile(t *testing.T, tests []compileTest) {
+	t.Helper()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prog := compile(t, test.file, test.options)
+
+			for k, in := range test.inputs {
+				r, err := vm.Run(prog, vm.Config{Input: vm.Inputs(in...)})
+				if err != nil {
+					t.Fatalf("inputs %v: could not run: %s\n%s", in, err, prog)
+				} else if !r.Halted {
+					t.Fatalf("inputs %v: did not halt\n%s", in, prog)
+				}
+
+				if out := r.SignedOutputs(); !reflect.DeepEqual(out, test.outputs[k]) {
+					t.Errorf("inputs %v: output %v, want %v", in, out, test.outputs[k])
+				}
+			}
+		})
+	}
+}
+
+func TestBranches(t *testing.T) {
+	testCompile(t, []compileTest{
+		{
+			name:    "loop",
+			file:    "countdown.ll",
+			inputs:  [][]lmc.Value{{0}, {1}, {3}},
+			outputs: [][]lmc.Value{{}, {1}, {3, 2, 1}},
+		},
+		{
+			name:    "if else",
+			file:    "branch.ll",
+			inputs:  [][]lmc.Value{{0}, {-7}, {12}},
+			outputs: [][]lmc.Value{{0, 0}, {1, -7}, {1, 12}},
+		},
+		{
+			name:    "unoptimised",
+			file:    "branch.ll",
+			options: map[string]int{"OPT": 0},
+			inputs:  [][]lmc.Value{{0}, {-7}, {12}},
+			outputs: [][]lmc.Value{{0, 0}, {1, -7}, {1, 12}},
+		},
+	})
+}
+
+func TestPhi(t *testing.T) {
+	testCompile(t, []compileTest{
+		{
+			name:    "loop",
+			file:    "phi.ll",
+			inputs:  [][]lmc.Value{{0}, {1}, {4}},
+			outputs: [][]lmc.Value{{0}, {1}, {10}},
+		},
+		{
+			name:    "swap",
+			file:    "swap.ll",
+			inputs:  [][]lmc.Value{{5, 8, 0}, {5, 8, 1}, {5, 8, 4}, {-3, 2, 3}},
+			outputs: [][]lmc.Value{{5, 8}, {8, 5}, {5, 8}, {2, -3}},
+		},
+		{
+			name:    "self loop",
+			file:    "doubling.ll",
+			inputs:  [][]lmc.Value{{1}, {3}, {8}},
+			outputs: [][]lmc.Value{{2}, {8}, {256}},
+		},
+		{
+			name:    "self loop unoptimised",
+			file:    "doubling.ll",
+			options: map[string]int{"OPT": 0},
+			inputs:  [][]lmc.Value{{1}, {3}, {8}},
+			outputs: [][]lmc.Value{{2}, {8}, {256}},
+		},
+	})
+}
+
+func TestICmp(t *testing.T) {
+	pairs := [][]lmc.Value{{3, 3}, {2, 5}, {5, 2}, {-4, 1}, {1, -4}, {-500, 499}, {499, -500}, {-7, -7}}
+
+	testCompile(t, []compileTest{
+		{
+			name:    "eq ne",
+			file:    "equality.ll",
+			inputs:  pairs,
+			outputs: [][]lmc.Value{{1, 0}, {0, 1}, {0, 1}, {0, 1}, {0, 1}, {0, 1}, {0, 1}, {1, 0}},
+		},
+		{
+			name:   "sgt sge slt sle",
+			file:   "order.ll",
+			inputs: pairs,
+			outputs: [][]lmc.Value{
+				{0, 1, 0, 1}, {0, 0, 1, 1}, {1, 1, 0, 0}, {0, 0, 1, 1},
+				{1, 1, 0, 0}, {0, 0, 1, 1}, {1, 1, 0, 0}, {0, 1, 0, 1},
+			},
+		},
+		{
+			name:    "branches",
+			file:    "sign.ll",
+			inputs:  [][]lmc.Value{{-7}, {0}, {12}, {-500}, {499}},
+			outputs: [][]lmc.Value{{-1}, {0}, {1}, {-1}, {1}},
+		},
+	})
+}
+
+func TestICmpUnsigned(t *testing.T) {
+	testCompile(t, []compileTest{
+		{
+			name:   "ugt uge ult ule",
+			file:   "unsigned.ll",
+			inputs: [][]lmc.Value{{3, 3}, {2, 5}, {5, 2}, {-1, 1}, {1, -1}, {-500, 499}, {499, -500}, {0, -1}},
+			outputs: [][]lmc.Value{
+				{0, 1, 0, 1}, {0, 0, 1, 1}, {1, 1, 0, 0}, {1, 1, 0, 0},
+				{0, 0, 1, 1}, {1, 1, 0, 0}, {0, 0, 1, 1}, {0, 0, 1, 1},
+			},
+		},
+	})
+}
+
+func TestInline(t *testing.T) {
+	testCompile(t, []compileTest{
+		{
+			name:    "calls",
+			file:    "inline.ll",
+			inputs:  [][]lmc.Value{{1, 2, 3}, {9, -4, 5}, {-2, -2, -8}},
+			outputs: [][]lmc.Value{{2, 3, 6}, {9, 5, 10}, {-2, -2, -12}},
+		},
+	})
+
+	mod, err := asm.ParseFile(filepath.Join("testdata", "recursion.ll"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := compiler.InlineCalls(compiler.GetLLEntry(mod)); err == nil {
+		t.Error("inlined a recursive call without an error")
+	}
+}
+
+// TestSubroutines checks functions compiled once each and called, rather than
+// inlined, and that those which cannot be subroutines are rejected.
+func TestSubroutines(t *testing.T) {
+	options := map[string]int{"CALLS": compiler.CallsSubroutine}
+
+	testCompile(t, []compileTest{
+		{
+			name:    "calls",
+			file:    "subroutine.ll",
+			options: options,
+			inputs:  [][]lmc.Value{{1, 2, 3}, {9, -4, 5}, {-2, -2, -8}},
+			outputs: [][]lmc.Value{{2, 3, 3}, {9, 5, 9}, {-2, -2, -2}},
+		},
+	})
+
+	for file, want := range map[string]string{
+		"inline.ll":    "parameter %0 of subroutine @add",
+		"recursion.ll": "",
+	} {
+		mod, err := asm.ParseFile(filepath.Join("testdata", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		comp := compiler.NewCompiler(lmc.NewProgram(lmc.NewBasicMemory()))
+		comp.Options.Set("CALLS", compiler.CallsSubroutine)
+
+		if _, err := comp.Functions(compiler.GetLLEntry(mod)); err == nil {
+			t.Errorf("%s: compiled calls without an error", file)
+		} else if !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %q, want it to mention %q", file, err, want)
+		}
+	}
+}
+
+// TestRecursion checks recursive subroutines, saving what they need over each
+// call on the software stack, and that overflowing it is signalled.
+func TestRecursion(t *testing.T) {
+	options := map[string]int{"CALLS": compiler.CallsSubroutine, "STACK": 12, "MAILBOXES": 0}
+
+	testCompile(t, []compileTest{
+		{
+			name:    "return slots",
+			file:    "recursion.ll",
+			options: options,
+			inputs:  [][]lmc.Value{{1}, {3}},
+			outputs: [][]lmc.Value{{1}, {3, 2, 1}},
+		},
+		{
+			name:    "live locals",
+			file:    "sum.ll",
+			options: options,
+			inputs:  [][]lmc.Value{{0}, {1}, {4}},
+			outputs: [][]lmc.Value{{0}, {1}, {10}},
+		},
+		{
+			name:    "overflow",
+			file:    "recursion.ll",
+			options: map[string]int{"CALLS": compiler.CallsSubroutine, "STACK": 3, "MAILBOXES": 0},
+			inputs:  [][]lmc.Value{{9}},
+			outputs: [][]lmc.Value{{9, 8, 7, 6, compiler.StackOverflowOutput}},
+		},
+	})
+}
+
+// TestGlobals checks that integer globals are mailboxes holding their initial
+// values, shared by every function, and named after them where they can be.
+func TestGlobals(t *testing.T) {
+	tests := []compileTest{
+		{
+			name:    "inlined",
+			file:    "globals.ll",
+			inputs:  [][]lmc.Value{{25}, {5, 7, 8, 1}, {-3, 9, 15}},
+			outputs: [][]lmc.Value{{0, 0}, {20, 3}, {6, 2}},
+		},
+	}
+
+	tests = append(tests, tests[0])
+	tests[1].name = "subroutines"
+	tests[1].options = map[string]int{"CALLS": compiler.CallsSubroutine}
+
+	testCompile(t, tests)
+
+	prog := compile(t, "globals.ll", nil)
+	for _, ident := range []string{"total", "count"} {
+		if !strings.Contains(prog.String(), ident) {
+			t.Errorf("no mailbox `%s':\n%s", ident, prog)
+		}
+	}
+
+	if strings.Contains(prog.String(), "MAX") {
+		t.Errorf("mailbox `MAX' may clash with a generated identifier:\n%s", prog)
+	}
+}
+
+// TestArrays checks elements of local and global arrays, nested or not, at
+// constant and variable indices.
+func TestArrays(t *testing.T) {
+	testCompile(t, []compileTest{
+		{
+			name:    "elements",
+			file:    "arrays.ll",
+			options: map[string]int{"MAILBOXES": 0},
+			inputs:  [][]lmc.Value{{1, 2, 3, 4, 0}, {-5, 0, 7, 9, 1}},
+			outputs: [][]lmc.Value{{4, 3, 2, 1, 1, 3, 0, 6}, {9, 7, 0, -5, 4, 6, 0, 0}},
+		},
+	})
+}
+
+// TestBitwise checks the bitwise operators and shifts, for values of as many
+// bits as the words of the program hold.
+func TestBitwise(t *testing.T) {
+	testCompile(t, []compileTest{
+		{
+			name:    "3 digits",
+			file:    "and.ll",
+			inputs:  [][]lmc.Value{{12, 10}, {-6, 5}, {499, -500}, {-1, 300}, {-12, -10}},
+			outputs: [][]lmc.Value{{8}, {0}, {0}, {300}, {-12}},
+		},
+		{
+			name:    "4 digits, all operators",
+			file:    "bitwise.ll",
+			options: map[string]int{"MAILBOXES": 0},
+			inputs:  [][]lmc.Value{{12, 10, 2}, {-6, 5, 1}, {99, -100, 9}},
+			outputs: [][]lmc.Value{{8, 14, 6, -13, 48, 3, 6}, {0, -1, -1, 5, -24, -3, -3}, {0, -1, -1, -100, 396, 0, 49}},
+		},
+		{
+			name:    "4 digits, wider values",
+			file:    "bitwise.ll",
+			options: map[string]int{"MAILBOXES": 1000},
+			inputs:  [][]lmc.Value{{1234, 3000, 4}, {-4000, 1023, 12}},
+			outputs: [][]lmc.Value{{144, 4090, 3946, -1235, 4936, 77, 617}, {96, -3073, -3169, 3999, 4000, -1, -2000}},
+		},
+	})
+}
+
+// TestConversions checks extensions and truncations, which keep values that do
+// not fit by default, and wrap them around with WRAP set to WrapExact.
+func TestConversions(t *testing.T) {
+	testCompile(t, []compileTest{
+		{
+			name:    "kept",
+			file:    "conversions.ll",
+			inputs:  [][]lmc.Value{{5}, {-1}, {200}, {-130}},
+			outputs: [][]lmc.Value{{5, 5, 0, 0, 5}, {-1, 255, 1, -1, -1}, {200, 200, 0, 0, 200}, {-130, 126, 1, -1, -130}},
+		},
+		{
+			name:    "wrapped",
+			file:    "conversions.ll",
+			options: map[string]int{"WRAP": compiler.WrapExact},
+			inputs:  [][]lmc.Value{{5}, {-1}, {200}, {300}, {-130}},
+			outputs: [][]lmc.Value{{5, 5, 0, 0, 1}, {-1, 255, 1, -1, 1}, {-56, 200, 0, 0, 0}, {44, 44, 0, 0, 0}, {126, 126, 1, -1, 0}},
+		},
+	})
+}
+
+// TestSelect checks selects, with a comparison of their own and one shared.
+func TestSelect(t *testing.T) {
+	testCompile(t, []compileTest{
+		{
+			name:    "select",
+			file:    "select.ll",
+			inputs:  [][]lmc.Value{{3, 8}, {-5, -9}, {0, 0}},
+			outputs: [][]lmc.Value{{8, 0, 3}, {-5, 1, 5}, {0, 0, 0}},
+		},
+	})
+}
+
+// TestSwitch checks switches compiled to comparisons in turn, and to a jump
+// table, including each default.
+func TestSwitch(t *testing.T) {
+	testCompile(t, []compileTest{
+		{
+			name:    "switch",
+			file:    "switch.ll",
+			inputs:  [][]lmc.Value{{-40}, {7}, {300}, {0}, {1}, {2}, {3}, {4}, {5}, {6}, {8}, {9}, {10}, {-1}},
+			outputs: [][]lmc.Value{{1, -1}, {2, 30}, {3, -1}, {0, -1}, {0, 10}, {0, 20}, {0, 30}, {0, 10}, {0, 50}, {0, 50}, {0, 20}, {0, 10}, {0, -1}, {0, -1}},
+		},
+	})
+}
+
+// TestMulDiv checks multiplication, division and remainder are as in C
+// whatever the signs of their operands, known or not, and dividing by zero is
+// as DIVZERO gives.
+func TestMulDiv(t *testing.T) {
+	inputs := [][]lmc.Value{{17, 5}, {-17, 5}, {17, -5}, {-17, -5}, {0, 3}, {4, 9}, {123, -25}}
+	outputs := [][]lmc.Value{{85, 3, 2}, {-85, -3, -2}, {-85, -3, 2}, {85, 3, -2}, {0, 0, 0}, {36, 0, 4}, {-3075, -4, 23}}
+
+	var tests []compileTest
+	for name, mode := range map[string]int{
+		"auto":   compiler.MulDivAuto,
+		"repeat": compiler.MulDivRepeat,
+		"fast":   compiler.MulDivFast,
+	} {
+		tests = append(tests,
+			compileTest{
+				name:    name + ", signs",
+				file:    "divide.ll",
+				options: map[string]int{"MAILBOXES": 0, "MULDIV": mode},
+				inputs:  inputs,
+				outputs: outputs,
+			},
+			compileTest{
+				name:    name + ", known signs",
+				file:    "known.ll",
+				options: map[string]int{"MAILBOXES": 0, "MULDIV": mode},
+				inputs:  [][]lmc.Value{{23, 3}, {-23, 4}, {6, -1}},
+				outputs: [][]lmc.Value{{3, 2, 69, 3}, {-3, -2, -92, 4}, {0, 6, 6 * 255, 0}},
+			},
+			compileTest{
+				name:    name + ", unsigned",
+				file:    "udivide.ll",
+				options: map[string]int{"MAILBOXES": 0, "MULDIV": mode},
+				inputs:  [][]lmc.Value{{17, 5}, {-1, 10}, {100, -1}},
+				outputs: [][]lmc.Value{{3, 2}, {999, 9}, {0, 100}},
+			},
+		)
+	}
+
+	testCompile(t, append(tests,
+		compileTest{
+			name:    "by zero",
+			file:    "divide.ll",
+			options: map[string]int{"MAILBOXES": 0},
+			inputs:  [][]lmc.Value{{7, 0}},
+			outputs: [][]lmc.Value{{0}},
+		},
+		compileTest{
+			name:    "by zero, signalled",
+			file:    "divide.ll",
+			options: map[string]int{"MAILBOXES": 0, "DIVZERO": compiler.DivZeroSentinel},
+			inputs:  [][]lmc.Value{{7, 0}},
+			outputs: [][]lmc.Value{{0, compiler.DivZeroOutput}},
+		},
+	))
+}
+
+// TestSharedArith checks arithmetic routines used more than once are as
+// correct shared as inline, by repeated addition or doubling, and that sharing
+// them makes the program smaller.
+func TestSharedArith(t *testing.T) {
+	inputs := [][]lmc.Value{{17, 5}, {-17, 5}, {17, -5}, {-4, -9}}
+	outputs := [][]lmc.Value{
+		{85, 3, 2, 110, 4, 2}, {-85, -3, -2, -60, -2, -2},
+		{-85, -3, 2, -60, -2, 2}, {36, 0, -4, 117, 1, -4},
+	}
+
+	var tests []compileTest
+	for muldivName, muldiv := range map[string]int{"repeat": compiler.MulDivRepeat, "fast": compiler.MulDivFast} {
+		for arithName, arith := range map[string]int{"inline": compiler.ArithInline, "shared": compiler.ArithShared} {
+			tests = append(tests, compileTest{
+				name:    muldivName + ", " + arithName,
+				file:    "arith.ll",
+				options: map[string]int{"MAILBOXES": 0, "MULDIV": muldiv, "ARITH": arith},
+				inputs:  inputs,
+				outputs: outputs,
+			})
+		}
+	}
+
+	testCompile(t, tests)
+
+	size := func(arith int) int {
+		prog := compile(t, "arith.ll", map[string]int{"MAILBOXES": 0, "ARITH": arith})
+		return len(prog.Memory.InstructionsList.Instructions)
+	}
+
+	if inline, shared := size(compiler.ArithInline), size(compiler.ArithShared); shared >= inline {
+		t.Errorf("shared routines take %d instructions, inline %d", shared, inline)
+	}
+}
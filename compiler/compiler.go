@@ -18,6 +18,7 @@ import (
 type Option struct {
 	Value     interface{}
 	Predicate func(interface{}) bool
+	Apply     func(interface{}) // given every value set, e.g., to pass it on to the program
 }
 
 func (o *Option) Set(val interface{}) bool {
@@ -26,6 +27,10 @@ func (o *Option) Set(val interface{}) bool {
 	}
 
 	o.Value = val
+	if o.Apply != nil {
+		o.Apply(val)
+	}
+
 	return true
 }
 
@@ -41,6 +46,15 @@ func NewOptions() *Options {
 	o.validKeys = []string{
 		"WLEVEL",
 		"OPT",
+		"MAILBOXES",
+		"CHAIN_LENGTH",
+		"UNROLL_LENGTH",
+		"CALLS",
+		"STACK",
+		"WRAP",
+		"DIVZERO",
+		"MULDIV",
+		"ARITH",
 	}
 
 	return &o
@@ -97,10 +111,30 @@ func (o *Options) String() string {
 }
 
 type Compiler struct {
-	Prog    *lmc.Program
-	Options *Options
-	Module  *ir.Module
-	tempBox *lmc.Mailbox
+	Prog          *lmc.Program
+	Options       *Options
+	Module        *ir.Module
+	tempBox       *lmc.Mailbox
+	blockLabels   map[*ir.Block]*lmc.Label
+	pendingLabels []*lmc.Label
+	labelAliases  map[string]*lmc.Label
+	fusedCmp      *ir.InstICmp
+	optionsRead   map[*ir.InstCall]struct{}
+	entry         *ir.Func
+	function      *ir.Func
+	block         *ir.Block
+	sub           *subroutine
+	subroutines   map[*ir.Func]*subroutine
+	globals       map[*ir.Global]*lmc.Mailbox
+	elements      map[*ir.InstGetElementPtr]*element
+	freeAddr      lmc.Address
+	stack         *instructions.Stack
+	routineUses   map[routineKind]int
+	routines      map[routineKind]*instructions.Routine
+	bits          *instructions.Bits
+	arith         *instructions.Arith
+	divides       bool
+	divZero       *instructions.DivisionByZero
 }
 
 func NewCompiler(prog *lmc.Program) *Compiler {
@@ -108,6 +142,14 @@ func NewCompiler(prog *lmc.Program) *Compiler {
 
 	c.Prog = prog
 	c.Options = NewOptions()
+	c.blockLabels = make(map[*ir.Block]*lmc.Label)
+	c.labelAliases = make(map[string]*lmc.Label)
+	c.optionsRead = make(map[*ir.InstCall]struct{})
+	c.subroutines = make(map[*ir.Func]*subroutine)
+	c.globals = make(map[*ir.Global]*lmc.Mailbox)
+	c.elements = make(map[*ir.InstGetElementPtr]*element)
+	c.routineUses = make(map[routineKind]int)
+	c.routines = make(map[routineKind]*instructions.Routine)
 
 	c.setDefaultOptions()
 
@@ -123,8 +165,35 @@ func (compiler *Compiler) setDefaultOptions() {
 		}
 	}
 
-	setAndPredicateF("WLEVEL", errors.L_Default, func(x interface{}) bool { return x.(int) >= 0 && x.(int) <= 2 })
-	setAndPredicateF("OPT", optimisation.OStrategy(7), func(x interface{}) bool { return true }) // defaults to all opts
+	setAndPredicateF("WLEVEL", int(errors.L_Default), func(x interface{}) bool { return x.(int) >= 0 && x.(int) <= 2 })
+	setAndPredicateF("OPT", int(optimisation.Thrashing|optimisation.Clean|optimisation.BProp|optimisation.Chaining|optimisation.Unroll|optimisation.DeadStore|optimisation.Coalesce), func(x interface{}) bool { return true }) // defaults to all opts
+	setAndPredicateF("MAILBOXES", lmc.DefaultCapacity, func(x interface{}) bool { return x.(int) >= 0 })
+	setAndPredicateF("CHAIN_LENGTH", optimisation.DefaultChainLength, func(x interface{}) bool { return x.(int) >= 0 })
+	setAndPredicateF("UNROLL_LENGTH", optimisation.DefaultUnrollLength, func(x interface{}) bool { return x.(int) >= 0 })
+	setAndPredicateF("CALLS", CallsInline, func(x interface{}) bool { return x.(int) == CallsInline || x.(int) == CallsSubroutine })
+	setAndPredicateF("STACK", 0, func(x interface{}) bool { return x.(int) >= 0 })
+	setAndPredicateF("WRAP", WrapNone, func(x interface{}) bool { return x.(int) == WrapNone || x.(int) == WrapExact })
+	setAndPredicateF("DIVZERO", DivZeroHalt, func(x interface{}) bool { return x.(int) >= DivZeroHalt && x.(int) <= DivZeroUnspecified })
+	setAndPredicateF("MULDIV", MulDivRepeat, func(x interface{}) bool { return x.(int) >= MulDivAuto && x.(int) <= MulDivFast })
+	setAndPredicateF("ARITH", ArithShared, func(x interface{}) bool { return x.(int) == ArithInline || x.(int) == ArithShared })
+
+	// the program's capacity follows the option, so assembling it directly
+	// honours it too
+	mailboxes := compiler.Options.Get("MAILBOXES")
+	mailboxes.Apply = func(x interface{}) { compiler.Prog.Capacity = x.(int) }
+	mailboxes.Apply(mailboxes.Value)
+}
+
+// CheckCapacity checks that the program fits in the number of mailboxes given
+// by the MAILBOXES option, where 0 is unlimited, which setting it gives the
+// program as its capacity. This should be done once the program is finished,
+// i.e., after optimisation. The child of the error is an *lmc.CapacityError.
+func (compiler *Compiler) CheckCapacity() error {
+	if err := compiler.Prog.CheckCapacity(); err != nil {
+		return errors.E_TargetCapacity(err)
+	}
+
+	return nil
 }
 
 func (compiler *Compiler) GetTempBox() *lmc.MemoryOp {
@@ -144,6 +213,14 @@ func (compiler *Compiler) GetMailboxFromLL(ll interface{}) (*lmc.MemoryOp, error
 		return compiler.GetTempBox(), nil
 	case *constant.Int:
 		return compiler.Prog.Memory.Constant(lmc.Value(x.X.Int64())), nil
+	case *ir.InstPhi:
+		// may be used before the PHI is compiled, e.g., in a loop
+		return compiler.phiBox(x)
+	case *ir.Global:
+		return compiler.globalBox(x)
+	case *ir.InstGetElementPtr, *constant.ExprGetElementPtr:
+		// an element has no mailbox of its own, only an index
+		return nil, errors.E_Unsupported(fmt.Sprintf("the address of an element `%s` other than to load or store", x.(value.Value).Ident()), nil)
 	//case *ir.Param:
 	case value.Value: // last try, just use reflection lol
 		if !ValidLLType(x.Type()) {
@@ -192,7 +269,16 @@ func (compiler *Compiler) AddCompiledInstruction(instr instructions.LLInstructio
 		defs = append(defs, op.Defs()...)
 	}
 
-	compiler.Prog.AddInstructions(instr.LMCInstructions(), defs)
+	instrs := instr.LMCInstructions()
+	compiler.labelInstructions(instrs)
+
+	compiler.Prog.AddInstructions(instrs, defs)
+
+	// a call with nothing to do once it returns returns to whatever is next
+	if c, ok := instr.(*instructions.WCall); ok && c.ReturnsNext() {
+		compiler.pendingLabels = append(compiler.pendingLabels, c.Return)
+	}
+
 	return nil
 }
 
@@ -236,40 +322,66 @@ func (compiler *Compiler) WrapLLBitcast(instr *ir.InstBitCast) *Compilation {
 	return &compilation
 }
 
-func (compiler *Compiler) WrapLLInstICmp(instr *ir.InstICmp, dstId lmc.Address) *Compilation {
-	var compilation Compilation
+// llSign gives the sign of an LL value if it is a constant.
+func llSign(x value.Value) instructions.Sign {
+	switch c := x.(type) {
+	case *constant.Int:
+		if c.X.Sign() < 0 {
+			return instructions.SignNegative
+		}
 
-	if instr.Pred >= 6 { // unsigned comparisons, for later I suppose
-		compilation.Err = errors.E_Unsupported("unsigned integer comparisons are unsupported", nil)
-		return &compilation
+		return instructions.SignPositive
+	case *constant.Null:
+		return instructions.SignPositive
+	default:
+		return instructions.SignUnknown
 	}
+}
 
-	var xBox *lmc.Mailbox
-	var yBox *lmc.Mailbox
-	var dstBox *lmc.Mailbox
-	var oneConst *lmc.Mailbox
+// newCmp creates the comparison of an icmp, and the labels it needs.
+func (compiler *Compiler) newCmp(instr *ir.InstICmp) (*instructions.Cmp, []*lmc.MemoryOp, error) {
+	x, err := compiler.GetMailboxFromLL(instr.X)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	var ops []*lmc.MemoryOp
-	var op *lmc.MemoryOp
-	var err error
+	y, err := compiler.GetMailboxFromLL(instr.Y)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	if op, err = compiler.GetMailboxFromLL(instr.X); err != nil {
-		compilation.Err = err
+	cmp := instructions.NewCmp(instr.Pred, x.Boxes[0].Box, y.Boxes[0].Box, llSign(instr.X), llSign(instr.Y))
+
+	for i := 0; i < cmp.LabelsNeeded(); i++ {
+		label, err := compiler.Prog.NewLabel("")
+		if err != nil {
+			return nil, nil, errors.E_LMC("creating comparison label", err)
+		}
+
+		cmp.Labels = append(cmp.Labels, label)
+	}
+
+	return cmp, []*lmc.MemoryOp{x, y}, nil
+}
+
+// WrapLLInstICmp stores the result of an icmp, 0 or 1, in the mailbox at the
+// address given. If the result is only the condition of its block's branch it
+// is fused with the branch instead, so this compiles to nothing.
+func (compiler *Compiler) WrapLLInstICmp(instr *ir.InstICmp, dstId lmc.Address) *Compilation {
+	var compilation Compilation
+
+	if instr == compiler.fusedCmp {
+		compilation.Wrapped = instructions.NewEmptyWInst([]ir.Instruction{instr})
 		return &compilation
-	} else {
-		xBox = op.Boxes[0].Box
-		ops = append(ops, op)
 	}
 
-	if op, err = compiler.GetMailboxFromLL(instr.Y); err != nil {
+	cmp, ops, err := compiler.newCmp(instr)
+	if err != nil {
 		compilation.Err = err
 		return &compilation
-	} else {
-		yBox = op.Boxes[0].Box
-		ops = append(ops, op)
 	}
 
-	dstBox = compiler.Prog.Memory.GetMailboxAddress(dstId)
+	dstBox := compiler.Prog.Memory.GetMailboxAddress(dstId)
 	if dstBox == nil {
 		op := compiler.Prog.Memory.NewMailbox(dstId, "")
 		dstBox = op.Boxes[0].Box
@@ -277,20 +389,62 @@ func (compiler *Compiler) WrapLLInstICmp(instr *ir.InstICmp, dstId lmc.Address)
 		ops = append(ops, op)
 	}
 
-	oneConst, err = compiler.Prog.Constant(1)
+	oneConst, err := compiler.Prog.Constant(1)
 	if err != nil {
 		compilation.Err = err
 		return &compilation
 	}
 
-	compilation.Wrapped = instructions.NewWInstICmp(instr, xBox, yBox, dstBox, oneConst, ops)
+	zeroConst, err := compiler.Prog.Constant(0)
+	if err != nil {
+		compilation.Err = err
+		return &compilation
+	}
+
+	w := instructions.NewWInstICmp(instr, cmp, dstBox, oneConst, zeroConst, ops)
+
+	for i := 0; i < 3; i++ {
+		label, err := compiler.Prog.NewLabel("")
+		if err != nil {
+			compilation.Err = errors.E_LMC("creating comparison label", err)
+			return &compilation
+		}
+
+		w.Labels = append(w.Labels, label)
+	}
+
+	compilation.Wrapped = w
 	return &compilation
 }
 
+// ReadOptions sets the options of every `__lmc_option__` call in a function
+// before it is compiled, as some decide how it is compiled, e.g., CALLS. The
+// compilations give any errors or warnings; the calls read then compile to
+// nothing.
+func (compiler *Compiler) ReadOptions(f *ir.Func) []*Compilation {
+	pattern := &compOptionPattern{compiler}
+	var l []*Compilation
+
+	for _, block := range f.Blocks {
+		for _, instr := range block.Insts {
+			if i := []ir.Instruction{instr}; pattern.Match(i) {
+				l = append(l, pattern.Compile(i))
+				compiler.optionsRead[instr.(*ir.InstCall)] = struct{}{}
+			}
+		}
+	}
+
+	return l
+}
+
 func (compiler *Compiler) WrapCompOption(instr *ir.InstCall, globals []*ir.Global) *Compilation {
 	var c Compilation
 	c.Wrapped = instructions.NewEmptyWInst([]ir.Instruction{instr})
 
+	if _, ok := compiler.optionsRead[instr]; ok {
+		return &c
+	}
+
 	if len(instr.Args) != 2 {
 		c.Err = errors.E_InvalidOptionSyntax(fmt.Sprintf("expected 2 args, got %d", len(instr.Args)))
 		return &c
@@ -392,13 +546,26 @@ func singleInstWrapper(compiler *Compiler, instr ir.Instruction) *Compilation {
 	case *ir.InstMul:
 		return compiler.WrapLLInstMul(cast)
 	case *ir.InstSDiv:
-		return compiler.WrapLLInstDiv(cast, cast.X, cast.Y, cast.ID())
+		return compiler.WrapLLInstDiv(cast, cast.X, cast.Y, cast.ID(), true)
 	case *ir.InstUDiv:
-		return compiler.WrapLLInstDiv(cast, cast.X, cast.Y, cast.ID())
+		return compiler.WrapLLInstDiv(cast, cast.X, cast.Y, cast.ID(), false)
 	case *ir.InstSRem:
-		return compiler.WrapLLInstRem(cast, cast.X, cast.Y, cast.ID())
+		return compiler.WrapLLInstRem(cast, cast.X, cast.Y, cast.ID(), true)
 	case *ir.InstURem:
-		return compiler.WrapLLInstRem(cast, cast.X, cast.Y, cast.ID())
+		return compiler.WrapLLInstRem(cast, cast.X, cast.Y, cast.ID(), false)
+	// bitwise
+	case *ir.InstAnd:
+		return compiler.WrapLLInstBitwise(cast, instructions.LogicAnd, cast.X, cast.Y, cast.ID())
+	case *ir.InstOr:
+		return compiler.WrapLLInstBitwise(cast, instructions.LogicOr, cast.X, cast.Y, cast.ID())
+	case *ir.InstXor:
+		return compiler.WrapLLInstBitwise(cast, instructions.LogicXor, cast.X, cast.Y, cast.ID())
+	case *ir.InstShl:
+		return compiler.WrapLLInstShl(cast)
+	case *ir.InstLShr:
+		return compiler.WrapLLInstShr(cast, cast.X, cast.Y, cast.ID())
+	case *ir.InstAShr:
+		return compiler.WrapLLInstShr(cast, cast.X, cast.Y, cast.ID())
 	// memory
 	case *ir.InstAlloca:
 		return compiler.WrapLLInstAlloca(cast)
@@ -406,13 +573,26 @@ func singleInstWrapper(compiler *Compiler, instr ir.Instruction) *Compilation {
 		return compiler.WrapLLInstLoad(cast)
 	case *ir.InstStore:
 		return compiler.WrapLLInstStore(cast)
+	case *ir.InstGetElementPtr:
+		return compiler.WrapLLInstGetElementPtr(cast)
 	// other
 	case *ir.InstCall:
 		return compiler.WrapLLInstCall(cast)
 	case *ir.InstBitCast:
 		return compiler.WrapLLBitcast(cast)
+	case *ir.InstZExt:
+		return compiler.WrapLLInstZExt(cast)
+	case *ir.InstSExt:
+		return compiler.WrapLLInstSExt(cast)
+	case *ir.InstTrunc:
+		return compiler.WrapLLInstTrunc(cast)
+	case *ir.InstSelect:
+		return compiler.WrapLLInstSelect(cast, nil)
 	case *ir.InstICmp:
 		return compiler.WrapLLInstICmp(cast, lmc.Address(cast.ID()))
+	// control
+	case *ir.InstPhi:
+		return compiler.WrapLLInstPhi(cast)
 	// unknown
 	default:
 		return &Compilation{Err: errors.E_UnknownLLInstruction(instr, nil)}
@@ -445,9 +625,14 @@ func (c *cmpZExtPattern) Match(i []ir.Instruction) bool {
 
 	if i2ID, err := ReflectGetLocalID(i2.From); err != nil {
 		panic(fmt.Sprintf("could not get local id via reflection from InstZExt: %s", err))
-	} else {
-		return lmc.Address(i1.ID()) == i2ID
+	} else if lmc.Address(i1.ID()) != i2ID {
+		return false
 	}
+
+	// the icmp is stored straight into the zext's mailbox, so nothing else may
+	// use it, e.g., a sext
+	block := c.compiler.block
+	return block == nil || block.Parent == nil || onlyUser(block.Parent, i1, i2)
 }
 
 func (c *cmpZExtPattern) Find(i []ir.Instruction) [][]int {
@@ -477,6 +662,60 @@ func (c *cmpZExtPattern) Priority() int {
 	return 10
 }
 
+// ---------- cmpSelectPattern ----------
+
+// cmpSelectPattern fuses an icmp with the select after it, whose condition is
+// its only use, so the result of the icmp is never stored.
+type cmpSelectPattern struct {
+	compiler *Compiler
+}
+
+func (c *cmpSelectPattern) Match(i []ir.Instruction) bool {
+	if len(i) != 2 {
+		return false
+	}
+
+	var ok bool
+	var i1 *ir.InstICmp
+	var i2 *ir.InstSelect
+
+	i1, ok = i[0].(*ir.InstICmp)
+	if ok {
+		i2, ok = i[1].(*ir.InstSelect)
+	}
+
+	if !ok || i2.Cond != i1 {
+		return false
+	}
+
+	block := c.compiler.block
+	return block != nil && block.Parent != nil && usedOnlyBy(i1, block.Parent, i2)
+}
+
+func (c *cmpSelectPattern) Find(i []ir.Instruction) [][]int {
+	var x [][]int
+
+	for j := 1; j < len(i); j++ {
+		if c.Match(i[j-1 : j+1]) {
+			x = append(x, []int{j - 1, j})
+		}
+	}
+
+	return x
+}
+
+func (c *cmpSelectPattern) Compile(i []ir.Instruction) *Compilation {
+	if len(i) != 2 {
+		panic("instructions given to compiled cmp select pattern is not of length 2")
+	}
+
+	return c.compiler.WrapLLInstSelect(i[1].(*ir.InstSelect), i[0].(*ir.InstICmp))
+}
+
+func (c *cmpSelectPattern) Priority() int {
+	return 10
+}
+
 // ---------- compOptionPattern ----------
 
 type compOptionPattern struct {
@@ -536,8 +775,11 @@ func createPatterns(compiler *Compiler) []Pattern {
 	var patterns []Pattern
 
 	for _, v := range []interface{}{
-		&ir.InstAdd{}, &ir.InstSub{}, &ir.InstMul{}, &ir.InstSDiv{}, &ir.InstSRem{}, &ir.InstURem{}, &ir.InstAlloca{},
-		&ir.InstLoad{}, &ir.InstStore{}, &ir.InstCall{}, &ir.InstBitCast{}, &ir.InstICmp{},
+		&ir.InstAdd{}, &ir.InstSub{}, &ir.InstMul{}, &ir.InstSDiv{}, &ir.InstUDiv{}, &ir.InstSRem{}, &ir.InstURem{},
+		&ir.InstAlloca{}, &ir.InstLoad{}, &ir.InstStore{}, &ir.InstCall{}, &ir.InstBitCast{}, &ir.InstICmp{}, &ir.InstPhi{},
+		&ir.InstGetElementPtr{}, &ir.InstAnd{}, &ir.InstOr{}, &ir.InstXor{}, &ir.InstShl{}, &ir.InstLShr{},
+		&ir.InstAShr{}, &ir.InstZExt{}, &ir.InstSExt{}, &ir.InstTrunc{},
+		&ir.InstSelect{},
 	} {
 		patterns = append(patterns, &singlePattern{
 			matcher: simpleMatcherF(reflect.TypeOf(v)),
@@ -552,6 +794,7 @@ func createPatterns(compiler *Compiler) []Pattern {
 	// standalone patterns - start
 
 	patterns = append(patterns, &cmpZExtPattern{compiler})
+	patterns = append(patterns, &cmpSelectPattern{compiler})
 	patterns = append(patterns, &compOptionPattern{compiler})
 
 	// standalone patterns - end
@@ -0,0 +1,619 @@
+package compiler
+
+import (
+	"fmt"
+	"github.com/clr1107/lmc-llvm-target/compiler/errors"
+	"github.com/clr1107/lmc-llvm-target/compiler/instructions"
+	"github.com/clr1107/lmc-llvm-target/lmc"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/value"
+	"reflect"
+	"sort"
+)
+
+// BlockLabel gives the label of an LL basic block, creating and adding it to
+// the program the first time.
+func (compiler *Compiler) BlockLabel(block *ir.Block) (*lmc.Label, error) {
+	if label, ok := compiler.blockLabels[block]; ok {
+		return label, nil
+	}
+
+	label, err := compiler.Prog.NewLabel("")
+	if err != nil {
+		return nil, errors.E_LMC("creating block label", err)
+	}
+
+	compiler.blockLabels[block] = label
+	return label, nil
+}
+
+// BeginBlock must be called before compiling the instructions of each LL basic
+// block, in order. The block's label is given to the next instruction added.
+func (compiler *Compiler) BeginBlock(block *ir.Block) error {
+	label, err := compiler.BlockLabel(block)
+	if err != nil {
+		return err
+	}
+
+	compiler.pendingLabels = append(compiler.pendingLabels, label)
+	compiler.block = block
+	compiler.fusedCmp = fusableCmp(block)
+
+	return nil
+}
+
+// fusableCmp gives the icmp of a block whose result is only the condition of
+// the block's branch, if there is one. It can be fused with the branch rather
+// than stored.
+func fusableCmp(block *ir.Block) *ir.InstICmp {
+	term, ok := block.Term.(*ir.TermCondBr)
+	if !ok {
+		return nil
+	}
+
+	cmp, ok := term.Cond.(*ir.InstICmp)
+	if !ok {
+		return nil
+	}
+
+	found := false
+	for _, instr := range block.Insts {
+		if instr == cmp {
+			found = true
+			break
+		}
+	}
+
+	if !found || block.Parent == nil || !usedOnlyBy(cmp, block.Parent, term) {
+		return nil
+	}
+
+	return cmp
+}
+
+// usedOnlyBy returns true if an LL value is used by nothing in a function but
+// the instruction or terminator given.
+func usedOnlyBy(v value.Value, f *ir.Func, user interface{}) bool {
+	for _, b := range f.Blocks {
+		if b.Term != user && ReflectUses(b.Term, v) {
+			return false
+		}
+
+		for _, instr := range b.Insts {
+			if instr != user && ReflectUses(instr, v) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// EndFunc must be called once every block of a function has been compiled. A
+// subroutine ends with its return slot, and the entry function with the code
+// for the software stack overflowing, if there is a stack, and for dividing by
+// zero, if anything divides, then the shared runtime routines. Blocks that
+// compiled to nothing share the label of the next instruction, so branches to
+// them are retargeted; as are the slots of subroutines, including those
+// compiled later.
+func (compiler *Compiler) EndFunc() error {
+	if compiler.sub != nil {
+		// anything pending is only reachable by falling off the end, or by a
+		// call made last, either of which returns
+		instrs := []lmc.Instruction{compiler.sub.Slot}
+		compiler.labelInstructions(instrs)
+		compiler.Prog.AddInstructions(instrs, nil)
+	} else {
+		if len(compiler.pendingLabels) > 0 {
+			// only reachable by falling off the end, which halts anyway
+			if err := compiler.AddCompiledInstruction(instructions.NewWTermRet(nil)); err != nil {
+				return err
+			}
+		}
+
+		if compiler.stack != nil {
+			compiler.Prog.AddInstructions(compiler.stack.OverflowInstructions(), nil)
+		}
+
+		if compiler.divides {
+			if _, err := compiler.byZero(); err != nil {
+				return err
+			} else if compiler.divZero != nil {
+				compiler.Prog.AddInstructions(compiler.divZero.Instructions(), nil)
+			}
+		}
+
+		routines, err := compiler.sharedRoutines()
+		if err != nil {
+			return err
+		}
+
+		compiler.Prog.AddInstructions(routines, nil)
+	}
+
+	compiler.Prog.Memory.InstructionsList.RetargetBranches(compiler.labelAliases)
+	return nil
+}
+
+// labelInstructions gives the first instruction the pending labels, if there
+// are any.
+func (compiler *Compiler) labelInstructions(instrs []lmc.Instruction) {
+	if len(instrs) == 0 || len(compiler.pendingLabels) == 0 {
+		return
+	}
+
+	pending := compiler.pendingLabels
+	c, ok := instrs[0].(*lmc.Labelled)
+
+	if !ok {
+		c = lmc.NewLabelled(pending[0], instrs[0])
+		pending = pending[1:]
+	}
+
+	for _, label := range pending {
+		compiler.labelAliases[label.Identifier()] = c.Label()
+	}
+
+	instrs[0] = c
+	compiler.pendingLabels = nil
+}
+
+// phiBox gives the mailbox of a PHI, creating and adding it to the program the
+// first time. It may be needed before the PHI is compiled, by the terminator of
+// a predecessor.
+func (compiler *Compiler) phiBox(phi *ir.InstPhi) (*lmc.MemoryOp, error) {
+	addr := lmc.Address(phi.ID())
+
+	if box := compiler.Prog.Memory.GetMailboxAddress(addr); box != nil {
+		return lmc.NewMemoryOpBox1(box, false), nil
+	}
+
+	box, err := compiler.Prog.NewMailbox(addr, "")
+	if err != nil {
+		return nil, errors.E_LMC("creating phi mailbox", err)
+	}
+
+	return lmc.NewMemoryOpBox1(box, false), nil
+}
+
+// WrapLLInstPhi compiles to nothing; the copies into its mailbox are made on
+// each edge to its block, by the terminators of its predecessors.
+func (compiler *Compiler) WrapLLInstPhi(instr *ir.InstPhi) *Compilation {
+	op, err := compiler.phiBox(instr)
+	if err != nil {
+		return &Compilation{Err: err}
+	}
+
+	return &Compilation{Wrapped: instructions.NewWInstPhi(instr, op.Boxes[0].Box, []*lmc.MemoryOp{op})}
+}
+
+// phiCopies gives the copies into the mailboxes of every PHI of a block, on the
+// edge from a predecessor. PHIs are copied in parallel, so the copies are
+// ordered such that no mailbox is stored to before it is copied from; a cycle
+// is broken by copying through the temp mailbox.
+func (compiler *Compiler) phiCopies(from *ir.Block, to *ir.Block) ([]lmc.Instruction, []*lmc.MemoryOp, error) {
+	type copyPair struct {
+		src *lmc.Mailbox
+		dst *lmc.Mailbox
+	}
+
+	var pending []*copyPair
+	var ops []*lmc.MemoryOp
+
+	for _, instr := range to.Insts {
+		phi, ok := instr.(*ir.InstPhi)
+		if !ok {
+			continue
+		}
+
+		var incoming *ir.Incoming
+		for _, inc := range phi.Incs {
+			if inc.Pred == from {
+				incoming = inc
+				break
+			}
+		}
+
+		if incoming == nil {
+			return nil, nil, errors.E_Err(fmt.Sprintf("phi `%s' has no value from block %s", phi.LLString(), from.Ident()), nil)
+		}
+
+		if _, ok := incoming.X.(*constant.Undef); ok {
+			continue
+		}
+
+		dst, err := compiler.phiBox(phi)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		src, err := compiler.GetMailboxFromLL(incoming.X)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ops = append(ops, dst, src)
+
+		if src.Boxes[0].Box != dst.Boxes[0].Box {
+			pending = append(pending, &copyPair{src: src.Boxes[0].Box, dst: dst.Boxes[0].Box})
+		}
+	}
+
+	var instrs []lmc.Instruction
+
+	for len(pending) > 0 {
+		ready := -1
+
+	search:
+		for k, c := range pending {
+			for _, other := range pending {
+				if other != c && other.src == c.dst {
+					continue search
+				}
+			}
+
+			ready = k
+			break
+		}
+
+		if ready == -1 {
+			// every mailbox left is in a cycle; save one, so it is free
+			op := compiler.GetTempBox()
+			temp := op.Boxes[0].Box
+			saved := pending[0].dst
+
+			ops = append(ops, op)
+			instrs = append(instrs, lmc.NewLoadInstr(saved), lmc.NewStoreInstr(temp))
+
+			for _, c := range pending {
+				if c.src == saved {
+					c.src = temp
+				}
+			}
+
+			continue
+		}
+
+		c := pending[ready]
+		pending = append(pending[:ready], pending[ready+1:]...)
+
+		instrs = append(instrs, lmc.NewLoadInstr(c.src), lmc.NewStoreInstr(c.dst))
+	}
+
+	return instrs, ops, nil
+}
+
+// WrapLLInstSelect compiles a select, see *instructions.WInstSelect. If cmp is
+// given it is the icmp of the condition, which is fused with the select.
+func (compiler *Compiler) WrapLLInstSelect(instr *ir.InstSelect, cmp *ir.InstICmp) *Compilation {
+	var ops []*lmc.MemoryOp
+	var w *instructions.WInstSelect
+
+	t, err := compiler.GetMailboxFromLL(instr.ValueTrue)
+	if err != nil {
+		return &Compilation{Err: err}
+	}
+
+	f, err := compiler.GetMailboxFromLL(instr.ValueFalse)
+	if err != nil {
+		return &Compilation{Err: err}
+	}
+
+	ops = append(ops, t, f)
+
+	dst := compiler.Prog.Memory.GetMailboxAddress(lmc.Address(instr.ID()))
+	if dst == nil {
+		op := compiler.Prog.Memory.NewMailbox(lmc.Address(instr.ID()), "")
+		dst = op.Boxes[0].Box
+
+		ops = append(ops, op)
+	}
+
+	if cmp != nil {
+		c, cmpOps, err := compiler.newCmp(cmp)
+		if err != nil {
+			return &Compilation{Err: err}
+		}
+
+		w = instructions.NewWInstSelect([]ir.Instruction{cmp, instr}, nil, t.Boxes[0].Box, f.Boxes[0].Box, dst, append(ops, cmpOps...))
+		w.Cmp = c
+	} else {
+		cond, err := compiler.GetMailboxFromLL(instr.Cond)
+		if err != nil {
+			return &Compilation{Err: err}
+		}
+
+		w = instructions.NewWInstSelect([]ir.Instruction{instr}, cond.Boxes[0].Box, t.Boxes[0].Box, f.Boxes[0].Box, dst, append(ops, cond))
+	}
+
+	for i := 0; i < 3; i++ {
+		label, err := compiler.Prog.NewLabel("")
+		if err != nil {
+			return &Compilation{Err: errors.E_LMC("creating select label", err)}
+		}
+
+		w.Labels = append(w.Labels, label)
+	}
+
+	return &Compilation{Wrapped: w}
+}
+
+// wrapSwitch compiles a switch, see *instructions.WTermSwitch. The cases are
+// compared in turn, unless a jump table of the values from the least case to
+// the greatest is no larger.
+func (compiler *Compiler) wrapSwitch(term *ir.TermSwitch, from *ir.Block, next *ir.Block) *Compilation {
+	x, err := compiler.GetMailboxFromLL(term.X)
+	if err != nil {
+		return &Compilation{Err: err}
+	}
+
+	// the successors, the default first, each once
+	var succs []*ir.Block
+	index := func(block *ir.Block) int {
+		for k, succ := range succs {
+			if succ == block {
+				return k
+			}
+		}
+
+		succs = append(succs, block)
+		return len(succs) - 1
+	}
+
+	index(term.TargetDefault.(*ir.Block))
+
+	type switchCase struct {
+		value  lmc.Value
+		target int
+	}
+
+	cases := make([]switchCase, 0, len(term.Cases))
+	for _, c := range term.Cases {
+		v, ok := c.X.(*constant.Int)
+		if !ok {
+			return &Compilation{Err: errors.E_Unsupported(fmt.Sprintf("switch case `%s`", c.X.Ident()), nil)}
+		}
+
+		cases = append(cases, switchCase{value: lmc.Value(v.X.Int64()), target: index(c.Target.(*ir.Block))})
+	}
+
+	sort.Slice(cases, func(i, j int) bool {
+		return cases[i].value < cases[j].value
+	})
+
+	targets := make([]*instructions.SwitchTarget, len(succs))
+	w := instructions.NewWTermSwitch(term, x.Boxes[0].Box, targets, []*lmc.MemoryOp{x})
+
+	for k, succ := range succs {
+		t := &instructions.SwitchTarget{Next: succ == next}
+
+		if t.Target, err = compiler.BlockLabel(succ); err != nil {
+			return &Compilation{Err: err}
+		}
+
+		var ops []*lmc.MemoryOp
+		if t.Copies, ops, err = compiler.phiCopies(from, succ); err != nil {
+			return &Compilation{Err: err}
+		}
+
+		w.AddMemoryOps(ops...)
+
+		if len(t.Copies) > 0 {
+			if t.Edge, err = compiler.Prog.NewLabel(""); err != nil {
+				return &Compilation{Err: errors.E_LMC("creating edge label", err)}
+			}
+		}
+
+		targets[k] = t
+	}
+
+	// the steps between the cases, as compared in turn
+	steps := make([]lmc.Value, len(cases))
+	distinct := make(map[lmc.Value]bool)
+
+	for k, c := range cases {
+		steps[k] = c.value
+		if k > 0 {
+			steps[k] -= cases[k-1].value
+		}
+
+		if steps[k] != 0 {
+			distinct[steps[k]] = true
+		}
+	}
+
+	if len(cases) > 1 {
+		low, span := cases[0].value, cases[len(cases)-1].value-cases[0].value+1
+
+		// the mailboxes of each: instructions and constants
+		chainSize := 1 + 2*lmc.Value(len(cases)) + lmc.Value(len(distinct))
+		if steps[0] == 0 {
+			chainSize--
+		}
+
+		tableSize := span + 9
+		if low != 0 {
+			tableSize += 2
+		}
+
+		if tableSize <= chainSize {
+			return compiler.wrapSwitchTable(w, low, span, func(k int) int {
+				i := sort.Search(len(cases), func(i int) bool {
+					return cases[i].value >= low+lmc.Value(k)
+				})
+
+				if i < len(cases) && cases[i].value == low+lmc.Value(k) {
+					return cases[i].target
+				}
+
+				return 0
+			})
+		}
+	}
+
+	for k, c := range cases {
+		w.Cases = append(w.Cases, c.target)
+
+		if k == 0 && steps[k] == 0 {
+			w.Steps = append(w.Steps, nil)
+			continue
+		}
+
+		op := compiler.Prog.Memory.Constant(steps[k])
+		w.Steps = append(w.Steps, op.Boxes[0].Box)
+		w.AddMemoryOps(op)
+	}
+
+	return &Compilation{Wrapped: w}
+}
+
+// wrapSwitchTable sets a switch to branch by a jump table of the values from
+// low, given the index of the target of each.
+func (compiler *Compiler) wrapSwitchTable(w *instructions.WTermSwitch, low lmc.Value, span lmc.Value, target func(k int) int) *Compilation {
+	var err error
+
+	if low != 0 {
+		op := compiler.Prog.Memory.Constant(low)
+		w.Low = op.Boxes[0].Box
+		w.AddMemoryOps(op)
+	}
+
+	spanOp := compiler.Prog.Memory.Constant(span)
+	w.Span = spanOp.Boxes[0].Box
+	w.AddMemoryOps(spanOp)
+
+	w.Entries = make([]*lmc.Label, span)
+	w.Cases = make([]int, span)
+
+	for k := range w.Entries {
+		if w.Entries[k], err = compiler.Prog.NewLabel(""); err != nil {
+			return &Compilation{Err: errors.E_LMC("creating jump table label", err)}
+		}
+
+		w.Cases[k] = target(k)
+	}
+
+	if w.Slot, err = compiler.newSlot(); err != nil {
+		return &Compilation{Err: err}
+	}
+
+	if w.Word, err = compiler.Prog.Word(lmc.NewBranchInstr(lmc.BRAlways, w.Entries[0])); err != nil {
+		return &Compilation{Err: errors.E_LMC("creating jump table word", err)}
+	}
+
+	return &Compilation{Wrapped: w}
+}
+
+// WrapLLTerm compiles the terminator of a block, given the block laid out after
+// it, or nil if it is the last. Branches to the next block fall through. Copies
+// for the PHIs of the successors are made on each edge, see
+// *instructions.WTermCondBr and *instructions.WTermSwitch.
+func (compiler *Compiler) WrapLLTerm(term ir.Terminator, from *ir.Block, next *ir.Block) *Compilation {
+	switch cast := term.(type) {
+	case *ir.TermBr:
+		succs := cast.Succs()
+
+		target, err := compiler.BlockLabel(succs[0])
+		if err != nil {
+			return &Compilation{Err: err}
+		}
+
+		copies, ops, err := compiler.phiCopies(from, succs[0])
+		if err != nil {
+			return &Compilation{Err: err}
+		}
+
+		return &Compilation{Wrapped: instructions.NewWTermBr(cast, target, copies, succs[0] == next, ops)}
+	case *ir.TermCondBr:
+		succs := cast.Succs()
+
+		t, err := compiler.BlockLabel(succs[0])
+		if err != nil {
+			return &Compilation{Err: err}
+		}
+
+		f, err := compiler.BlockLabel(succs[1])
+		if err != nil {
+			return &Compilation{Err: err}
+		}
+
+		var w *instructions.WTermCondBr
+
+		if cast.Cond == compiler.fusedCmp {
+			cmp, ops, err := compiler.newCmp(compiler.fusedCmp)
+			if err != nil {
+				return &Compilation{Err: err}
+			}
+
+			w = instructions.NewWTermCondBr(cast, nil, t, f, ops)
+			w.Cmp = cmp
+		} else {
+			op, err := compiler.GetMailboxFromLL(cast.Cond)
+			if err != nil {
+				return &Compilation{Err: err}
+			}
+
+			w = instructions.NewWTermCondBr(cast, op.Boxes[0].Box, t, f, []*lmc.MemoryOp{op})
+		}
+
+		w.NextTrue, w.NextFalse = succs[0] == next, succs[1] == next
+
+		var ops []*lmc.MemoryOp
+
+		if w.TrueCopies, ops, err = compiler.phiCopies(from, succs[0]); err != nil {
+			return &Compilation{Err: err}
+		}
+
+		w.AddMemoryOps(ops...)
+
+		if w.FalseCopies, ops, err = compiler.phiCopies(from, succs[1]); err != nil {
+			return &Compilation{Err: err}
+		}
+
+		w.AddMemoryOps(ops...)
+
+		if len(w.TrueCopies) > 0 {
+			if w.TrueEdge, err = compiler.Prog.NewLabel(""); err != nil {
+				return &Compilation{Err: errors.E_LMC("creating edge label", err)}
+			}
+		}
+
+		if len(w.FalseCopies) > 0 {
+			if w.FalseEdge, err = compiler.Prog.NewLabel(""); err != nil {
+				return &Compilation{Err: errors.E_LMC("creating edge label", err)}
+			}
+		}
+
+		return &Compilation{Wrapped: w}
+	case *ir.TermSwitch:
+		return compiler.wrapSwitch(cast, from, next)
+	case *ir.TermRet:
+		if compiler.sub == nil {
+			if cast.X != nil {
+				return &Compilation{Err: errors.E_Unsupported(fmt.Sprintf("returning a value from the entry function `%s`", cast.LLString()), nil)}
+			}
+
+			return &Compilation{Wrapped: instructions.NewWTermRet(cast)}
+		}
+
+		w := instructions.NewWTermRet(cast)
+		w.Slot, w.Next = compiler.sub.Slot.Label(), next == nil
+
+		if _, ok := cast.X.(*constant.Undef); cast.X != nil && !ok {
+			op, err := compiler.GetMailboxFromLL(cast.X)
+			if err != nil {
+				return &Compilation{Err: err}
+			}
+
+			w.Value, w.Result = op.Boxes[0].Box, compiler.sub.Result
+			w.AddMemoryOps(op)
+		}
+
+		return &Compilation{Wrapped: w}
+	case *ir.TermUnreachable:
+		return &Compilation{Wrapped: instructions.NewWTermRet(cast)}
+	default:
+		return &Compilation{Err: errors.E_Unsupported(fmt.Sprintf("terminator %s", reflect.TypeOf(term)), nil)}
+	}
+}
@@ -0,0 +1,153 @@
+package compiler
+
+import (
+	"github.com/clr1107/lmc-llvm-target/compiler/errors"
+	"github.com/clr1107/lmc-llvm-target/compiler/instructions"
+	"github.com/clr1107/lmc-llvm-target/lmc"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Values of the WRAP option.
+const (
+	WrapNone  = iota // truncation keeps the value, see WrapLLInstTrunc
+	WrapExact        // truncation wraps around as in LL
+)
+
+// WordBits is the number of bits, with a sign, of the values a word holds at
+// least: classic LMC holds -500 to 499, which is 9 bits and a sign. Every
+// integer is held signed, an i1 being 0 or 1. An integer of fewer bits holds
+// only some of the values of a word, so conversions to or from it change the
+// value; one of at least as many is taken to hold every value of a word.
+const WordBits = 10
+
+// wrapConversion gives the mailboxes of the value converted and of the result.
+func (compiler *Compiler) wrapConversion(from value.Value, addr lmc.Address) (*lmc.Mailbox, *lmc.Mailbox, []*lmc.MemoryOp, error) {
+	var ops []*lmc.MemoryOp
+
+	op, err := compiler.GetMailboxFromLL(from)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	fromBox := op.Boxes[0].Box
+	ops = append(ops, op)
+
+	toBox := compiler.Prog.Memory.GetMailboxAddress(addr)
+	if toBox == nil {
+		op := compiler.Prog.Memory.NewMailbox(addr, "")
+		toBox = op.Boxes[0].Box
+
+		ops = append(ops, op)
+	}
+
+	return fromBox, toBox, ops, nil
+}
+
+// bitSize gives the number of bits of an integer type.
+func bitSize(t types.Type) int64 {
+	if i, ok := t.(*types.IntType); ok {
+		return int64(i.BitSize)
+	}
+
+	return WordBits
+}
+
+// WrapLLInstZExt compiles a zero extension. An i1, or an integer of at least
+// WordBits bits, is unchanged; otherwise a negative value has 2 to the number
+// of bits added, see *instructions.WInstZExt.
+func (compiler *Compiler) WrapLLInstZExt(instr *ir.InstZExt) *Compilation {
+	from, to, ops, err := compiler.wrapConversion(instr.From, lmc.Address(instr.ID()))
+	if err != nil {
+		return &Compilation{Err: err}
+	}
+
+	bits := bitSize(instr.From.Type())
+	if bits == 1 || bits >= WordBits {
+		return &Compilation{Wrapped: instructions.NewWInstBitcast(instr, from, to, ops)}
+	}
+
+	positive, err := compiler.Prog.NewLabel("")
+	if err != nil {
+		return &Compilation{Err: errors.E_LMC("creating extension label", err)}
+	}
+
+	end, err := compiler.Prog.NewLabel("")
+	if err != nil {
+		return &Compilation{Err: errors.E_LMC("creating extension label", err)}
+	}
+
+	modOp := compiler.Prog.Memory.Constant(lmc.Value(1) << bits)
+
+	return &Compilation{Wrapped: instructions.NewWInstZExt(instr, from, to, modOp.Boxes[0].Box, positive, end,
+		append(ops, modOp))}
+}
+
+// WrapLLInstSExt compiles a sign extension. Values are held signed, so this is
+// a copy, except of an i1, which is negated, see *instructions.WInstNegate.
+func (compiler *Compiler) WrapLLInstSExt(instr *ir.InstSExt) *Compilation {
+	from, to, ops, err := compiler.wrapConversion(instr.From, lmc.Address(instr.ID()))
+	if err != nil {
+		return &Compilation{Err: err}
+	}
+
+	if bitSize(instr.From.Type()) != 1 {
+		return &Compilation{Wrapped: instructions.NewWInstBitcast(instr, from, to, ops)}
+	}
+
+	zeroOp := compiler.Prog.Memory.Constant(0)
+	return &Compilation{Wrapped: instructions.NewWInstNegate(instr, from, to, zeroOp.Boxes[0].Box, append(ops, zeroOp))}
+}
+
+// WrapLLInstTrunc compiles a truncation. To an integer of at least WordBits
+// bits it is a copy. To fewer, by default, it is a copy too, with a warning,
+// as the value would be changed if it does not fit; with the WRAP option set to
+// WrapExact it wraps around, see *instructions.WInstWrap.
+func (compiler *Compiler) WrapLLInstTrunc(instr *ir.InstTrunc) *Compilation {
+	from, to, ops, err := compiler.wrapConversion(instr.From, lmc.Address(instr.ID()))
+	if err != nil {
+		return &Compilation{Err: err}
+	}
+
+	bits := bitSize(instr.To)
+	if bits >= WordBits {
+		return &Compilation{Wrapped: instructions.NewWInstBitcast(instr, from, to, ops)}
+	}
+
+	if compiler.Options.Get("WRAP").Value.(int) != WrapExact {
+		return &Compilation{
+			Wrapped: instructions.NewWInstBitcast(instr, from, to, ops),
+			Warnings: []*errors.Warning{
+				errors.W_Truncation(instr.From.Ident(), instr.From.Type().LLString(), instr.To.LLString()),
+			},
+		}
+	}
+
+	n := instructions.WInstWrapLabels
+	if bits == 1 {
+		n = 4
+	}
+
+	labels := make([]*lmc.Label, n)
+	for k := range labels {
+		if labels[k], err = compiler.Prog.NewLabel(""); err != nil {
+			return &Compilation{Err: errors.E_LMC("creating truncation label", err)}
+		}
+	}
+
+	modOp := compiler.Prog.Memory.Constant(lmc.Value(1) << bits)
+	maskOp := compiler.Prog.Memory.Constant(lmc.Value(1)<<bits - 1)
+	maxOp := compiler.Prog.Memory.Constant(-1)
+	ops = append(ops, modOp, maskOp, maxOp)
+
+	var half *lmc.Mailbox
+	if bits != 1 {
+		halfOp := compiler.Prog.Memory.Constant(lmc.Value(1) << (bits - 1))
+		half = halfOp.Boxes[0].Box
+		ops = append(ops, halfOp)
+	}
+
+	return &Compilation{Wrapped: instructions.NewWInstWrap(instr, from, to, modOp.Boxes[0].Box, maskOp.Boxes[0].Box,
+		half, maxOp.Boxes[0].Box, labels, ops)}
+}
@@ -23,6 +23,8 @@ const (
 	BuiltinInvocationError
 	UnknownBuiltinError
 	InvalidOptionSyntaxError
+	TargetCapacityError
+	RecursionError
 )
 
 var errorNames = map[ErrorCode]string{
@@ -37,6 +39,8 @@ var errorNames = map[ErrorCode]string{
 	BuiltinInvocationError:    "BUILTIN_INVOCATION",
 	UnknownBuiltinError:       "UNKNOWN_BUILTIN",
 	InvalidOptionSyntaxError:  "INVALID_OPT_SYNTAX",
+	TargetCapacityError:       "TARGET_CAPACITY",
+	RecursionError:            "RECURSION",
 }
 
 type Error struct {
@@ -126,3 +130,11 @@ func E_UnknownBuiltin(f *ir.Func, child error) *Error {
 func E_InvalidOptionSyntax(problem string) *Error {
 	return NewError(InvalidOptionSyntaxError, "invalid compiler option syntax (__lmc_option__)", errors.New(problem))
 }
+
+func E_TargetCapacity(child error) *Error {
+	return NewError(TargetCapacityError, "program does not fit in target", child)
+}
+
+func E_Recursion(chain []string, child error) *Error {
+	return NewError(RecursionError, fmt.Sprintf("recursive call is not supported: %s", strings.Join(chain, " -> ")), child)
+}
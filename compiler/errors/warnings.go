@@ -10,11 +10,13 @@ type WarningLevel uint8
 const (
 	BitcastWarning WarningCode = iota
 	InvalidCompOpt
+	TruncationWarning
 )
 
 var warningNames = map[WarningCode]string{
-	BitcastWarning: "BITCAST",
-	InvalidCompOpt: "INVALID_COMP_OPTION",
+	BitcastWarning:    "BITCAST",
+	InvalidCompOpt:    "INVALID_COMP_OPTION",
+	TruncationWarning: "TRUNCATION",
 }
 
 const (
@@ -48,3 +50,7 @@ func W_Bitcast(from string, to string) *Warning {
 func W_InvalidCompOption(key string, val string) *Warning {
 	return &Warning{Code: InvalidCompOpt, Level: L_Default, msg: fmt.Sprintf("invalid compiler option pair `%s`=%s; ignored", key, val)}
 }
+
+func W_Truncation(value string, from string, to string) *Warning {
+	return &Warning{Code: TruncationWarning, Level: L_Default, msg: fmt.Sprintf("truncation of %s from %s to %s keeps values that do not fit; set the WRAP option to wrap them around", value, from, to)}
+}
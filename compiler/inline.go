@@ -0,0 +1,222 @@
+package compiler
+
+import (
+	"github.com/clr1107/lmc-llvm-target/compiler/errors"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"reflect"
+)
+
+// InlineCalls replaces every call in a function to another defined function
+// with a copy of the callee's body, including calls in inlined bodies, so
+// only calls to builtins are left. Parameters are replaced by the arguments
+// of each call, and the result by the value returned. Recursion cannot be
+// inlined, so is an error.
+//
+// The local IDs of the function are assigned again afterwards, so mailboxes
+// of different copies are distinct.
+func InlineCalls(f *ir.Func) error {
+	// the chain of calls inlined to reach each call, for recursion
+	chains := make(map[*ir.InstCall][]string)
+
+	for i := 0; i < len(f.Blocks); i++ {
+		for _, instr := range f.Blocks[i].Insts {
+			call, ok := instr.(*ir.InstCall)
+			if !ok {
+				continue
+			}
+
+			callee, ok := call.Callee.(*ir.Func)
+			if !ok || len(callee.Blocks) == 0 {
+				continue
+			}
+
+			chain, ok := chains[call]
+			if !ok {
+				chain = []string{f.Name()}
+			}
+
+			chain = append(append([]string{}, chain...), callee.Name())
+			for _, name := range chain[:len(chain)-1] {
+				if name == callee.Name() {
+					return errors.E_Recursion(chain, nil)
+				}
+			}
+
+			for _, c := range inlineCall(f, i, call, callee) {
+				chains[c] = chain
+			}
+
+			// the rest of the block was moved after the inlined body
+			break
+		}
+	}
+
+	if _, err := numberLocals(f, 0); err != nil {
+		return errors.E_Err("assigning local IDs after inlining", err)
+	}
+
+	return nil
+}
+
+// inlineCall inlines a call in a block of a function. The block is split at
+// the call: the callee's blocks are copied after it, and then the rest of the
+// block, which they return to. Gives the calls in the copied blocks.
+func inlineCall(f *ir.Func, index int, call *ir.InstCall, callee *ir.Func) []*ir.InstCall {
+	block := f.Blocks[index]
+
+	var at int
+	for k, instr := range block.Insts {
+		if instr == call {
+			at = k
+			break
+		}
+	}
+
+	after := ir.NewBlock("")
+	after.Parent = f
+	after.Insts = append([]ir.Instruction{}, block.Insts[at+1:]...)
+	after.Term = block.Term
+
+	block.Insts = block.Insts[:at]
+
+	// the rest of the block is now the predecessor of its successors
+	for _, s := range after.Term.Succs() {
+		for _, instr := range s.Insts {
+			if phi, ok := instr.(*ir.InstPhi); ok {
+				for _, inc := range phi.Incs {
+					if inc.Pred == block {
+						inc.Pred = after
+					}
+				}
+			}
+		}
+	}
+
+	m := make(map[value.Value]value.Value)
+	for k, param := range callee.Params {
+		m[param] = call.Args[k]
+	}
+
+	blocks := make([]*ir.Block, len(callee.Blocks))
+	for k, b := range callee.Blocks {
+		blocks[k] = ir.NewBlock("")
+		blocks[k].Parent = f
+		m[b] = blocks[k]
+	}
+
+	var calls []*ir.InstCall
+	var rets []*ir.Incoming
+
+	for k, b := range callee.Blocks {
+		for _, instr := range b.Insts {
+			c := reflectClone(instr).(ir.Instruction)
+			if v, ok := instr.(value.Value); ok {
+				m[v] = c.(value.Value)
+			}
+
+			if x, ok := c.(*ir.InstCall); ok {
+				calls = append(calls, x)
+			}
+
+			blocks[k].Insts = append(blocks[k].Insts, c)
+		}
+
+		if ret, ok := b.Term.(*ir.TermRet); ok {
+			if ret.X != nil {
+				rets = append(rets, ir.NewIncoming(ret.X, blocks[k]))
+			}
+
+			blocks[k].Term = ir.NewBr(after)
+		} else {
+			blocks[k].Term = reflectClone(b.Term).(ir.Terminator)
+		}
+	}
+
+	for _, b := range blocks {
+		for _, instr := range b.Insts {
+			ReflectReplace(instr, m)
+		}
+
+		ReflectReplace(b.Term, m)
+	}
+
+	for _, inc := range rets {
+		if x, ok := m[inc.X]; ok {
+			inc.X = x
+		}
+	}
+
+	block.Term = ir.NewBr(blocks[0])
+
+	rest := append(blocks, after)
+	rest = append(rest, f.Blocks[index+1:]...)
+	f.Blocks = append(f.Blocks[:index+1], rest...)
+
+	if !types.Equal(call.Type(), types.Void) {
+		var result value.Value
+
+		switch len(rets) {
+		case 0: // never returns
+			result = constant.NewUndef(call.Type())
+		case 1:
+			result = rets[0].X
+		default:
+			phi := ir.NewPhi(rets...)
+			after.Insts = append([]ir.Instruction{phi}, after.Insts...)
+			result = phi
+		}
+
+		uses := map[value.Value]value.Value{call: result}
+
+		for _, b := range f.Blocks {
+			for _, instr := range b.Insts {
+				ReflectReplace(instr, uses)
+			}
+
+			ReflectReplace(b.Term, uses)
+		}
+	}
+
+	return calls
+}
+
+// reflectClone gives a copy of an LL instruction or terminator, with copies
+// of its slices, and of any structs in them which are not values (e.g., PHI
+// incomings), so that replacing operands does not change the original.
+func reflectClone(x interface{}) interface{} {
+	v := reflect.ValueOf(x).Elem()
+
+	c := reflect.New(v.Type())
+	c.Elem().Set(v)
+
+	s := c.Elem()
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Field(i)
+		if f.Kind() != reflect.Slice || f.IsNil() || !f.CanSet() {
+			continue
+		}
+
+		n := reflect.MakeSlice(f.Type(), f.Len(), f.Len())
+		reflect.Copy(n, f)
+
+		for j := 0; j < n.Len(); j++ {
+			e := n.Index(j)
+			if e.Kind() != reflect.Ptr || e.IsNil() || e.Elem().Kind() != reflect.Struct {
+				continue
+			}
+
+			if _, ok := e.Interface().(value.Value); !ok {
+				ec := reflect.New(e.Elem().Type())
+				ec.Elem().Set(e.Elem())
+				e.Set(ec)
+			}
+		}
+
+		f.Set(n)
+	}
+
+	return c.Interface()
+}
@@ -27,6 +27,12 @@ func NewWArithmeticInst(instr ir.Instruction, x *lmc.Mailbox, y *lmc.Mailbox, ds
 	}
 }
 
+// LMCOps gives the memory operations of the operands and destination, for
+// wrappers compiling an arithmetic instruction otherwise, e.g., WRoutineCall.
+func (w *WArithmeticInst) LMCOps() []*lmc.MemoryOp {
+	return w.memoryOps
+}
+
 // ---------- WInstAdd ----------
 
 type WInstAdd struct {
@@ -98,6 +104,8 @@ func (w *WInstMul) LMCInstructions() []lmc.Instruction {
 	return []lmc.Instruction{
 		lmc.NewLoadInstr(w.X),
 		lmc.NewStoreInstr(w.Counter),
+		lmc.NewSubInstr(w.Counter), // Dst starts at 0, it may be run again
+		lmc.NewStoreInstr(w.Dst),
 		lmc.NewLabelled(w.LoopLabel, lmc.NewLoadInstr(w.Dst)),
 		lmc.NewAddInstr(w.Y),
 		lmc.NewStoreInstr(w.Dst),
@@ -117,11 +125,15 @@ func (w *WInstMul) LMCOps() []*lmc.MemoryOp {
 
 // ---------- WInstDiv ----------
 
+// WInstDiv is an unsigned division, counting how many times Y can be
+// subtracted from X before it borrows. If ByZero is set it is branched to if Y
+// is 0; otherwise that never ends.
 type WInstDiv struct {
 	WArithmeticInst
 	Temp *lmc.Mailbox
 	OneConst *lmc.Mailbox
 	LoopLabel *lmc.Label
+	ByZero *lmc.Label
 }
 
 func NewWInstDiv(inst *WArithmeticInst, temp *lmc.Mailbox, oneConst *lmc.Mailbox, loopLabel *lmc.Label, ops []*lmc.MemoryOp) *WInstDiv {
@@ -135,9 +147,11 @@ func NewWInstDiv(inst *WArithmeticInst, temp *lmc.Mailbox, oneConst *lmc.Mailbox
 }
 
 func (w *WInstDiv) LMCInstructions() []lmc.Instruction {
-	return []lmc.Instruction{
+	return append(checkZero(w.Y, w.ByZero),
 		lmc.NewLoadInstr(w.X),
 		lmc.NewStoreInstr(w.Temp),
+		lmc.NewSubInstr(w.Temp), // Dst starts at 0, it may be run again
+		lmc.NewStoreInstr(w.Dst),
 		lmc.NewLabelled(w.LoopLabel, lmc.NewLoadInstr(w.Dst)),
 		lmc.NewAddInstr(w.OneConst),
 		lmc.NewStoreInstr(w.Dst),
@@ -148,7 +162,7 @@ func (w *WInstDiv) LMCInstructions() []lmc.Instruction {
 		lmc.NewLoadInstr(w.Dst),
 		lmc.NewSubInstr(w.OneConst),
 		lmc.NewStoreInstr(w.Dst),
-	}
+	)
 }
 
 func (w *WInstDiv) LMCOps() []*lmc.MemoryOp {
@@ -157,9 +171,12 @@ func (w *WInstDiv) LMCOps() []*lmc.MemoryOp {
 
 // ---------- WInstRem ----------
 
+// WInstRem is an unsigned remainder, subtracting Y from X until it borrows. Y
+// is checked for 0 as by WInstDiv.
 type WInstRem struct {
 	WArithmeticInst
 	LoopLabel *lmc.Label
+	ByZero *lmc.Label
 }
 
 func NewWInstRem(inst *WArithmeticInst, loopLabel *lmc.Label, ops []*lmc.MemoryOp) *WInstRem {
@@ -171,12 +188,12 @@ func NewWInstRem(inst *WArithmeticInst, loopLabel *lmc.Label, ops []*lmc.MemoryO
 }
 
 func (w *WInstRem) LMCInstructions() []lmc.Instruction {
-	return []lmc.Instruction{
+	return append(checkZero(w.Y, w.ByZero),
 		lmc.NewLoadInstr(w.X),
 		lmc.NewLabelled(w.LoopLabel, lmc.NewStoreInstr(w.Dst)),
 		lmc.NewSubInstr(w.Y),
 		lmc.NewBranchInstr(lmc.BRPositive, w.LoopLabel),
-	}
+	)
 }
 
 func (w *WInstRem) LMCOps() []*lmc.MemoryOp {
@@ -0,0 +1,524 @@
+package instructions
+
+import (
+	"github.com/clr1107/lmc-llvm-target/lmc"
+)
+
+// ---------- Bits ----------
+
+// Bits is what the loops of the bitwise routines need: constants, and the
+// temporaries Counter and Sign.
+//
+// Words are decimal, so a value is split into bits by comparing it with Top,
+// taking the bit if it is no less, then doubling it; once for each bit, from
+// the most significant. The bits are as many as every value of a word needs:
+// for classic LMC, 9 bits and a sign, -512 to 511, so Top is 256. Signs are
+// tested as by Cmp, so nothing else depends on the modulus.
+type Bits struct {
+	Zero    *lmc.Mailbox
+	One     *lmc.Mailbox
+	Two     *lmc.Mailbox
+	Last    *lmc.Mailbox // the index of the most significant bit, e.g., 8
+	Top     *lmc.Mailbox // the most significant bit, e.g., 256
+	Span    *lmc.Mailbox // the bits of a negative value less it, e.g., 512
+	Max     *lmc.Mailbox // -1
+	Counter *lmc.Mailbox
+	Sign    *lmc.Mailbox
+}
+
+// AndLabels is the number of labels AndBody needs.
+const AndLabels = 8
+
+// AndBody gives the body of the bitwise and of X and Y, into R. Each negative
+// operand has Span added, so it is its bits, and is counted in Sign; if both
+// are, so is the result, which has Span subtracted. It ends by falling through
+// to the label end, and needs AndLabels labels.
+//
+//	    LDA Zero
+//	    STA R
+//	    STA Sign
+//	    LDA X        ; and the same for Y
+//	    ADD X
+//	    SUB X
+//	    BRP XP
+//	    LDA X
+//	    ADD Span
+//	    STA X
+//	    LDA Sign
+//	    ADD One
+//	    STA Sign
+//	XP  ...
+//	    LDA Last
+//	    STA Counter
+//	L   LDA R        ; R = 2R, plus one if X and Y have the bit
+//	    ADD R
+//	    STA R
+//	    LDA X
+//	    SUB Top
+//	    BRP XB
+//	    LDA Y
+//	    SUB Top
+//	    BRP YD
+//	    BRA D
+//	XB  STA X
+//	    LDA Y
+//	    SUB Top
+//	    BRP YB
+//	    BRA D
+//	YB  STA Y
+//	    LDA R
+//	    ADD One
+//	    STA R
+//	    BRA D
+//	YD  STA Y
+//	D   LDA X        ; and the same for Y
+//	    ADD X
+//	    STA X
+//	    LDA Counter
+//	    SUB One
+//	    STA Counter
+//	    BRP L
+//	    LDA Sign
+//	    SUB Two
+//	    BRP N
+//	    BRA end
+//	N   LDA R
+//	    SUB Span
+//	    STA R
+func (b *Bits) AndBody(x *lmc.Mailbox, y *lmc.Mailbox, r *lmc.Mailbox, labels []*lmc.Label, end *lmc.Label) []lmc.Instruction {
+	xp, yp, l, xb, yb, yd, d, n := labels[0], labels[1], labels[2], labels[3], labels[4], labels[5], labels[6],
+		labels[7]
+
+	instrs := []lmc.Instruction{
+		lmc.NewLoadInstr(b.Zero),
+		lmc.NewStoreInstr(r),
+		lmc.NewStoreInstr(b.Sign),
+	}
+
+	instrs = append(instrs, b.unsign(x, xp)...)
+
+	ys := b.unsign(y, yp)
+	ys[0] = lmc.NewLabelled(xp, ys[0])
+	instrs = append(instrs, ys...)
+
+	return append(instrs,
+		lmc.NewLabelled(yp, lmc.NewLoadInstr(b.Last)),
+		lmc.NewStoreInstr(b.Counter),
+		lmc.NewLabelled(l, lmc.NewLoadInstr(r)),
+		lmc.NewAddInstr(r),
+		lmc.NewStoreInstr(r),
+		lmc.NewLoadInstr(x),
+		lmc.NewSubInstr(b.Top),
+		lmc.NewBranchInstr(lmc.BRPositive, xb),
+		lmc.NewLoadInstr(y),
+		lmc.NewSubInstr(b.Top),
+		lmc.NewBranchInstr(lmc.BRPositive, yd),
+		lmc.NewBranchInstr(lmc.BRAlways, d),
+		lmc.NewLabelled(xb, lmc.NewStoreInstr(x)),
+		lmc.NewLoadInstr(y),
+		lmc.NewSubInstr(b.Top),
+		lmc.NewBranchInstr(lmc.BRPositive, yb),
+		lmc.NewBranchInstr(lmc.BRAlways, d),
+		lmc.NewLabelled(yb, lmc.NewStoreInstr(y)),
+		lmc.NewLoadInstr(r),
+		lmc.NewAddInstr(b.One),
+		lmc.NewStoreInstr(r),
+		lmc.NewBranchInstr(lmc.BRAlways, d),
+		lmc.NewLabelled(yd, lmc.NewStoreInstr(y)),
+		lmc.NewLabelled(d, lmc.NewLoadInstr(x)),
+		lmc.NewAddInstr(x),
+		lmc.NewStoreInstr(x),
+		lmc.NewLoadInstr(y),
+		lmc.NewAddInstr(y),
+		lmc.NewStoreInstr(y),
+		lmc.NewLoadInstr(b.Counter),
+		lmc.NewSubInstr(b.One),
+		lmc.NewStoreInstr(b.Counter),
+		lmc.NewBranchInstr(lmc.BRPositive, l),
+		lmc.NewLoadInstr(b.Sign),
+		lmc.NewSubInstr(b.Two),
+		lmc.NewBranchInstr(lmc.BRPositive, n),
+		lmc.NewBranchInstr(lmc.BRAlways, end),
+		lmc.NewLabelled(n, lmc.NewLoadInstr(r)),
+		lmc.NewSubInstr(b.Span),
+		lmc.NewStoreInstr(r),
+	)
+}
+
+// unsign gives the instructions adding Span to X if it is negative, and one to
+// Sign. If X is not negative, they branch to the label positive, which must
+// label whatever is next.
+func (b *Bits) unsign(x *lmc.Mailbox, positive *lmc.Label) []lmc.Instruction {
+	return []lmc.Instruction{
+		lmc.NewLoadInstr(x),
+		lmc.NewAddInstr(x),
+		lmc.NewSubInstr(x),
+		lmc.NewBranchInstr(lmc.BRPositive, positive),
+		lmc.NewLoadInstr(x),
+		lmc.NewAddInstr(b.Span),
+		lmc.NewStoreInstr(x),
+		lmc.NewLoadInstr(b.Sign),
+		lmc.NewAddInstr(b.One),
+		lmc.NewStoreInstr(b.Sign),
+	}
+}
+
+// ShrLabels is the number of labels ShrBody needs.
+const ShrLabels = 6
+
+// ShrBody gives the body of the arithmetic right shift of X by N, into R, i.e.,
+// X divided by 2 to the N, rounding down. A negative X is the complement of one
+// that is not, -1 - X, and has the same shift: so X is complemented, shifted,
+// then complemented again. The shift takes the most significant Last + 1 - N
+// bits. It ends by falling through to the label end, and needs ShrLabels
+// labels.
+//
+//	    LDA Zero
+//	    STA R
+//	    STA Sign
+//	    LDA X
+//	    ADD X
+//	    SUB X
+//	    BRP XP
+//	    LDA Max
+//	    SUB X
+//	    STA X
+//	    LDA One
+//	    STA Sign
+//	XP  LDA Last
+//	    SUB N
+//	    BRP L0
+//	    BRA T
+//	L0  STA Counter
+//	L   LDA R        ; R = 2R, plus one if X has the bit
+//	    ADD R
+//	    STA R
+//	    LDA X
+//	    SUB Top
+//	    BRP B
+//	    BRA D
+//	B   STA X
+//	    LDA R
+//	    ADD One
+//	    STA R
+//	D   LDA X
+//	    ADD X
+//	    STA X
+//	    LDA Counter
+//	    SUB One
+//	    STA Counter
+//	    BRP L
+//	T   LDA Sign
+//	    BRZ end
+//	    LDA Max
+//	    SUB R
+//	    STA R
+func (b *Bits) ShrBody(x *lmc.Mailbox, n *lmc.Mailbox, r *lmc.Mailbox, labels []*lmc.Label, end *lmc.Label) []lmc.Instruction {
+	xp, l0, l, bit, d, t := labels[0], labels[1], labels[2], labels[3], labels[4], labels[5]
+
+	return []lmc.Instruction{
+		lmc.NewLoadInstr(b.Zero),
+		lmc.NewStoreInstr(r),
+		lmc.NewStoreInstr(b.Sign),
+		lmc.NewLoadInstr(x),
+		lmc.NewAddInstr(x),
+		lmc.NewSubInstr(x),
+		lmc.NewBranchInstr(lmc.BRPositive, xp),
+		lmc.NewLoadInstr(b.Max),
+		lmc.NewSubInstr(x),
+		lmc.NewStoreInstr(x),
+		lmc.NewLoadInstr(b.One),
+		lmc.NewStoreInstr(b.Sign),
+		lmc.NewLabelled(xp, lmc.NewLoadInstr(b.Last)),
+		lmc.NewSubInstr(n),
+		lmc.NewBranchInstr(lmc.BRPositive, l0),
+		lmc.NewBranchInstr(lmc.BRAlways, t),
+		lmc.NewLabelled(l0, lmc.NewStoreInstr(b.Counter)),
+		lmc.NewLabelled(l, lmc.NewLoadInstr(r)),
+		lmc.NewAddInstr(r),
+		lmc.NewStoreInstr(r),
+		lmc.NewLoadInstr(x),
+		lmc.NewSubInstr(b.Top),
+		lmc.NewBranchInstr(lmc.BRPositive, bit),
+		lmc.NewBranchInstr(lmc.BRAlways, d),
+		lmc.NewLabelled(bit, lmc.NewStoreInstr(x)),
+		lmc.NewLoadInstr(r),
+		lmc.NewAddInstr(b.One),
+		lmc.NewStoreInstr(r),
+		lmc.NewLabelled(d, lmc.NewLoadInstr(x)),
+		lmc.NewAddInstr(x),
+		lmc.NewStoreInstr(x),
+		lmc.NewLoadInstr(b.Counter),
+		lmc.NewSubInstr(b.One),
+		lmc.NewStoreInstr(b.Counter),
+		lmc.NewBranchInstr(lmc.BRPositive, l),
+		lmc.NewLabelled(t, lmc.NewLoadInstr(b.Sign)),
+		lmc.NewBranchInstr(lmc.BRZero, end),
+		lmc.NewLoadInstr(b.Max),
+		lmc.NewSubInstr(r),
+		lmc.NewStoreInstr(r),
+	}
+}
+
+// ---------- WInstShl ----------
+
+// WInstShl is a left shift of X by Y, i.e., multiplying by 2 to the Y, by
+// doubling. If Y is a constant, Count, it is repeated that many times:
+//
+//	LDA X
+//	STA Dst
+//	ADD Dst      ; Count times
+//	STA Dst
+//
+// Otherwise Y is counted down in Counter, which needs the labels Loop and Test:
+//
+//	    LDA X
+//	    STA Dst
+//	    LDA Y
+//	    BRA Test
+//	Loop STA Counter
+//	    LDA Dst
+//	    ADD Dst
+//	    STA Dst
+//	    LDA Counter
+//	Test SUB One
+//	    BRP Loop
+type WInstShl struct {
+	WArithmeticInst
+	Count   int
+	Counter *lmc.Mailbox
+	One     *lmc.Mailbox
+	Loop    *lmc.Label
+	Test    *lmc.Label
+}
+
+func NewWInstShl(inst *WArithmeticInst, count int) *WInstShl {
+	return &WInstShl{
+		WArithmeticInst: *inst,
+		Count:           count,
+	}
+}
+
+// Variable sets the mailboxes and labels needed if Y is not a constant.
+func (w *WInstShl) Variable(counter *lmc.Mailbox, one *lmc.Mailbox, loop *lmc.Label, test *lmc.Label, ops []*lmc.MemoryOp) {
+	w.Counter, w.One, w.Loop, w.Test = counter, one, loop, test
+	w.memoryOps = append(w.memoryOps, ops...)
+}
+
+func (w *WInstShl) LMCInstructions() []lmc.Instruction {
+	instrs := []lmc.Instruction{
+		lmc.NewLoadInstr(w.X),
+		lmc.NewStoreInstr(w.Dst),
+	}
+
+	if w.Loop == nil {
+		for i := 0; i < w.Count; i++ {
+			instrs = append(instrs, lmc.NewAddInstr(w.Dst), lmc.NewStoreInstr(w.Dst))
+		}
+
+		return instrs
+	}
+
+	return append(instrs,
+		lmc.NewLoadInstr(w.Y),
+		lmc.NewBranchInstr(lmc.BRAlways, w.Test),
+		lmc.NewLabelled(w.Loop, lmc.NewStoreInstr(w.Counter)),
+		lmc.NewLoadInstr(w.Dst),
+		lmc.NewAddInstr(w.Dst),
+		lmc.NewStoreInstr(w.Dst),
+		lmc.NewLoadInstr(w.Counter),
+		lmc.NewLabelled(w.Test, lmc.NewSubInstr(w.One)),
+		lmc.NewBranchInstr(lmc.BRPositive, w.Loop),
+	)
+}
+
+func (w *WInstShl) LMCOps() []*lmc.MemoryOp {
+	return w.memoryOps
+}
+
+// ---------- WInstShr ----------
+
+// WInstShrLabels is the number of labels WInstShr needs.
+const WInstShrLabels = 5
+
+// WInstShr is a right shift of X by a constant, i.e., dividing by Divisor, 2 to
+// the constant, rounding down. This is as WInstDiv, counting how many times
+// Divisor can be subtracted; a negative X is complemented first, -1 - X, which
+// has the same shift, and the quotient is complemented after:
+//
+//	  LDA X
+//	  ADD X
+//	  SUB X
+//	  BRP P
+//	  LDA Max
+//	  SUB X
+//	  BRA S
+//	P LDA X
+//	S STA Temp
+//	  LDA Zero
+//	  STA Dst
+//	L LDA Dst     ; Dst is one more than the quotient
+//	  ADD One
+//	  STA Dst
+//	  LDA Temp
+//	  SUB Divisor
+//	  STA Temp
+//	  BRP L
+//	  LDA X
+//	  ADD X
+//	  SUB X
+//	  BRP Q
+//	  LDA Zero     ; -1 - (Dst - 1)
+//	  SUB Dst
+//	  BRA E
+//	Q LDA Dst
+//	  SUB One
+//	E STA Dst
+type WInstShr struct {
+	WArithmeticInst
+	Temp    *lmc.Mailbox
+	Divisor *lmc.Mailbox
+	Zero    *lmc.Mailbox
+	One     *lmc.Mailbox
+	Max     *lmc.Mailbox
+	Labels  []*lmc.Label
+}
+
+func NewWInstShr(inst *WArithmeticInst, temp *lmc.Mailbox, divisor *lmc.Mailbox, zero *lmc.Mailbox, one *lmc.Mailbox, max *lmc.Mailbox, labels []*lmc.Label, ops []*lmc.MemoryOp) *WInstShr {
+	inst.memoryOps = append(inst.memoryOps, ops...)
+	return &WInstShr{
+		WArithmeticInst: *inst,
+		Temp:            temp,
+		Divisor:         divisor,
+		Zero:            zero,
+		One:             one,
+		Max:             max,
+		Labels:          labels,
+	}
+}
+
+func (w *WInstShr) LMCInstructions() []lmc.Instruction {
+	p, s, l, q, e := w.Labels[0], w.Labels[1], w.Labels[2], w.Labels[3], w.Labels[4]
+
+	return []lmc.Instruction{
+		lmc.NewLoadInstr(w.X),
+		lmc.NewAddInstr(w.X),
+		lmc.NewSubInstr(w.X),
+		lmc.NewBranchInstr(lmc.BRPositive, p),
+		lmc.NewLoadInstr(w.Max),
+		lmc.NewSubInstr(w.X),
+		lmc.NewBranchInstr(lmc.BRAlways, s),
+		lmc.NewLabelled(p, lmc.NewLoadInstr(w.X)),
+		lmc.NewLabelled(s, lmc.NewStoreInstr(w.Temp)),
+		lmc.NewLoadInstr(w.Zero),
+		lmc.NewStoreInstr(w.Dst),
+		lmc.NewLabelled(l, lmc.NewLoadInstr(w.Dst)),
+		lmc.NewAddInstr(w.One),
+		lmc.NewStoreInstr(w.Dst),
+		lmc.NewLoadInstr(w.Temp),
+		lmc.NewSubInstr(w.Divisor),
+		lmc.NewStoreInstr(w.Temp),
+		lmc.NewBranchInstr(lmc.BRPositive, l),
+		lmc.NewLoadInstr(w.X),
+		lmc.NewAddInstr(w.X),
+		lmc.NewSubInstr(w.X),
+		lmc.NewBranchInstr(lmc.BRPositive, q),
+		lmc.NewLoadInstr(w.Zero),
+		lmc.NewSubInstr(w.Dst),
+		lmc.NewBranchInstr(lmc.BRAlways, e),
+		lmc.NewLabelled(q, lmc.NewLoadInstr(w.Dst)),
+		lmc.NewSubInstr(w.One),
+		lmc.NewLabelled(e, lmc.NewStoreInstr(w.Dst)),
+	}
+}
+
+func (w *WInstShr) LMCOps() []*lmc.MemoryOp {
+	return w.memoryOps
+}
+
+// ---------- WInstLogic ----------
+
+// WInstLogic is a bitwise and, or, or xor of two booleans, 0 or 1:
+//
+//	    LDA X
+//	    ADD Y        ; or SUB Y for xor; neither for and
+//	    BRZ Zero
+//	    LDA One      ; or LDA Y for and
+//	Zero STA Dst
+type WInstLogic struct {
+	WArithmeticInst
+	Op   LogicOp
+	One  *lmc.Mailbox
+	Zero *lmc.Label
+}
+
+// LogicOp is a bitwise operation.
+type LogicOp int
+
+const (
+	LogicAnd LogicOp = iota
+	LogicOr
+	LogicXor
+)
+
+func NewWInstLogic(inst *WArithmeticInst, op LogicOp, one *lmc.Mailbox, zero *lmc.Label, ops []*lmc.MemoryOp) *WInstLogic {
+	inst.memoryOps = append(inst.memoryOps, ops...)
+	return &WInstLogic{
+		WArithmeticInst: *inst,
+		Op:              op,
+		One:             one,
+		Zero:            zero,
+	}
+}
+
+func (w *WInstLogic) LMCInstructions() []lmc.Instruction {
+	instrs := []lmc.Instruction{lmc.NewLoadInstr(w.X)}
+	then := lmc.NewLoadInstr(w.One)
+
+	switch w.Op {
+	case LogicAnd:
+		then = lmc.NewLoadInstr(w.Y)
+	case LogicOr:
+		instrs = append(instrs, lmc.NewAddInstr(w.Y))
+	case LogicXor:
+		instrs = append(instrs, lmc.NewSubInstr(w.Y))
+	}
+
+	return append(instrs,
+		lmc.NewBranchInstr(lmc.BRZero, w.Zero),
+		then,
+		lmc.NewLabelled(w.Zero, lmc.NewStoreInstr(w.Dst)),
+	)
+}
+
+func (w *WInstLogic) LMCOps() []*lmc.MemoryOp {
+	return w.memoryOps
+}
+
+// ---------- WInstNot ----------
+
+// WInstNot is the complement of X, i.e., its xor with -1, which is -1 - X.
+type WInstNot struct {
+	WArithmeticInst
+	Max *lmc.Mailbox
+}
+
+func NewWInstNot(inst *WArithmeticInst, max *lmc.Mailbox, ops []*lmc.MemoryOp) *WInstNot {
+	inst.memoryOps = append(inst.memoryOps, ops...)
+	return &WInstNot{
+		WArithmeticInst: *inst,
+		Max:             max,
+	}
+}
+
+func (w *WInstNot) LMCInstructions() []lmc.Instruction {
+	return []lmc.Instruction{
+		lmc.NewLoadInstr(w.Max),
+		lmc.NewSubInstr(w.X),
+		lmc.NewStoreInstr(w.Dst),
+	}
+}
+
+func (w *WInstNot) LMCOps() []*lmc.MemoryOp {
+	return w.memoryOps
+}
@@ -212,10 +212,15 @@ func NewBuiltinHltInstr() *BuiltinUnaryInstrFunc {
 
 // ---------- WBuiltinCall ----------
 
+// WBuiltinCall is a call to a builtin. Before and After are compiled around it,
+// e.g., to copy an element of an array given as a parameter through the temp
+// mailbox, see Indexed.
 type WBuiltinCall struct {
 	LLInstructionBase
 	Func        Builtin
 	Parameters  []*lmc.Mailbox
+	Before      []lmc.Instruction
+	After       []lmc.Instruction
 	originalOps []*lmc.MemoryOp
 	invocation  *BuiltinReturn
 	Invoked     bool
@@ -251,7 +256,8 @@ func (w *WBuiltinCall) LMCInstructions() []lmc.Instruction {
 		panic("builtin not invoked, cannot return LMC instructions during compilation")
 	}
 
-	return w.invocation.Instructions
+	instrs := append(append([]lmc.Instruction{}, w.Before...), w.invocation.Instructions...)
+	return append(instrs, w.After...)
 }
 
 func (w *WBuiltinCall) LMCOps() []*lmc.MemoryOp {
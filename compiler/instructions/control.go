@@ -0,0 +1,601 @@
+package instructions
+
+import (
+	"github.com/clr1107/lmc-llvm-target/lmc"
+	"github.com/llir/llvm/ir"
+)
+
+// Terminators are not ir.Instruction, so their wrappers have no LL base; the
+// terminator is kept in Term instead.
+
+// ---------- WTermBr ----------
+
+// WTermBr is an unconditional branch, after the copies for any PHIs of the
+// target. If the target is the next block, Next is true and it falls through.
+type WTermBr struct {
+	LLInstructionBase
+	Term      *ir.TermBr
+	Target    *lmc.Label
+	Copies    []lmc.Instruction
+	Next      bool
+	memoryOps []*lmc.MemoryOp
+}
+
+func NewWTermBr(term *ir.TermBr, target *lmc.Label, copies []lmc.Instruction, next bool, ops []*lmc.MemoryOp) *WTermBr {
+	return &WTermBr{
+		Term:      term,
+		Target:    target,
+		Copies:    copies,
+		Next:      next,
+		memoryOps: ops,
+	}
+}
+
+func (w *WTermBr) LMCInstructions() []lmc.Instruction {
+	instrs := append([]lmc.Instruction{}, w.Copies...)
+
+	if !w.Next {
+		instrs = append(instrs, lmc.NewBranchInstr(lmc.BRAlways, w.Target))
+	}
+
+	return instrs
+}
+
+func (w *WTermBr) LMCOps() []*lmc.MemoryOp {
+	return w.memoryOps
+}
+
+// ---------- WTermCondBr ----------
+
+// WTermCondBr branches to True if the condition holds and otherwise to False.
+// The condition is either the mailbox Cond being other than 0, or Cmp if it is
+// set, fusing the comparison with the branch. The copies for PHIs of each
+// target are only done on the edge to it, so an edge with copies is split,
+// with TrueEdge or FalseEdge labelling them:
+//
+//	    LDA Cond
+//	    BRZ FalseEdge
+//	    ...          ; copies for True
+//	    BRA True
+//	FalseEdge ...    ; copies for False
+//	    BRA False
+//
+// The edge the condition's branches end with is laid out first, falling
+// through. The last branch is left out if its target is the next block.
+type WTermCondBr struct {
+	LLInstructionBase
+	Term        *ir.TermCondBr
+	Cond        *lmc.Mailbox
+	Cmp         *Cmp
+	True        *lmc.Label
+	False       *lmc.Label
+	TrueCopies  []lmc.Instruction
+	FalseCopies []lmc.Instruction
+	TrueEdge    *lmc.Label
+	FalseEdge   *lmc.Label
+	NextTrue    bool
+	NextFalse   bool
+	memoryOps   []*lmc.MemoryOp
+}
+
+func NewWTermCondBr(term *ir.TermCondBr, cond *lmc.Mailbox, t *lmc.Label, f *lmc.Label, ops []*lmc.MemoryOp) *WTermCondBr {
+	return &WTermCondBr{
+		Term:      term,
+		Cond:      cond,
+		True:      t,
+		False:     f,
+		memoryOps: ops,
+	}
+}
+
+// condEdge is one edge of a conditional branch: the label branched to, which
+// is the target's unless there are copies to make first.
+type condEdge struct {
+	label  *lmc.Label
+	target *lmc.Label
+	copies []lmc.Instruction
+	next   bool
+}
+
+func newCondEdge(target *lmc.Label, edge *lmc.Label, copies []lmc.Instruction, next bool) *condEdge {
+	e := &condEdge{label: target, target: target, copies: copies, next: next}
+	if len(copies) > 0 {
+		e.label = edge
+	}
+
+	return e
+}
+
+// instructions gives the copies, labelled if needed, then the branch to the
+// target unless it is next.
+func (e *condEdge) instructions(labelled bool) []lmc.Instruction {
+	instrs := append([]lmc.Instruction{}, e.copies...)
+
+	if !e.next {
+		instrs = append(instrs, lmc.NewBranchInstr(lmc.BRAlways, e.target))
+	}
+
+	if labelled && len(e.copies) > 0 {
+		instrs[0] = lmc.NewLabelled(e.label, instrs[0])
+	}
+
+	return instrs
+}
+
+func (w *WTermCondBr) LMCInstructions() []lmc.Instruction {
+	t := newCondEdge(w.True, w.TrueEdge, w.TrueCopies, w.NextTrue)
+	f := newCondEdge(w.False, w.FalseEdge, w.FalseCopies, w.NextFalse)
+
+	var instrs []lmc.Instruction
+
+	if w.Cmp != nil {
+		instrs = w.Cmp.Branches(t.label, f.label)
+	} else {
+		instrs = []lmc.Instruction{
+			lmc.NewLoadInstr(w.Cond),
+			lmc.NewBranchInstr(lmc.BRZero, f.label),
+			lmc.NewBranchInstr(lmc.BRAlways, t.label),
+		}
+	}
+
+	first, second := t, f
+	if lastTarget(instrs) != t.label.Identifier() {
+		first, second = f, t
+	}
+
+	// only the second edge can fall through to the next block, if it has code
+	if len(second.copies) > 0 {
+		first.next = false
+	}
+
+	labelled := branchesTo(instrs, first.label)
+	instrs = append(instrs[:len(instrs)-1], first.instructions(labelled)...)
+
+	if len(second.copies) > 0 {
+		instrs = append(instrs, second.instructions(true)...)
+	}
+
+	return instrs
+}
+
+func (w *WTermCondBr) LMCOps() []*lmc.MemoryOp {
+	return w.memoryOps
+}
+
+// AddMemoryOps adds the memory operations of the copies.
+func (w *WTermCondBr) AddMemoryOps(ops ...*lmc.MemoryOp) {
+	w.memoryOps = append(w.memoryOps, ops...)
+}
+
+// ---------- WTermSwitch ----------
+
+// SwitchTarget is a successor of a switch: the label of its block, Target, and
+// the copies for its PHIs, labelled by Edge if there are any. Next is true if
+// it is the next block.
+type SwitchTarget struct {
+	Target *lmc.Label
+	Edge   *lmc.Label
+	Copies []lmc.Instruction
+	Next   bool
+}
+
+// WTermSwitch branches to the successor of the case X is, Targets[Cases[k]],
+// or otherwise to the default, Targets[0]. The cases are either compared in
+// turn, subtracting each of Steps from the accumulator, so it is 0 once it has
+// subtracted the case's value; a nil step, for a first case of 0, is not
+// subtracted:
+//
+//	LDA X
+//	SUB Steps[0]
+//	BRZ ...          ; Targets[Cases[0]]
+//	SUB Steps[1]
+//	BRZ ...
+//	...              ; copies for the default
+//	BRA Targets[0]
+//
+// Or, if Slot is set, branched to by a jump table, Entries, of the successors of
+// the values Low to Low + Span - 1. X less Low is, if it is in range, added to
+// Word, the word of `BRA Entries[0]`, and stored in Slot to be executed:
+//
+//	    LDA X
+//	    SUB Low      ; unless Low is nil, i.e., 0
+//	    SUB Span     ; in range iff X - Low, unsigned, is less than Span
+//	    BRP ...      ; Targets[0]
+//	    ADD Span
+//	    ADD Word
+//	    STA Slot
+//	Slot DAT 0       ; BRA Entries[X - Low]
+//	Entries[0] BRA ... ; Targets[Cases[0]]
+//	...
+//
+// Either way, the copies for the other successors follow, each labelled by its
+// Edge and branching to its Target.
+type WTermSwitch struct {
+	LLInstructionBase
+	Term      *ir.TermSwitch
+	X         *lmc.Mailbox
+	Targets   []*SwitchTarget
+	Cases     []int
+	Steps     []*lmc.Mailbox
+	Low       *lmc.Mailbox
+	Span      *lmc.Mailbox
+	Word      *lmc.Mailbox
+	Slot      *Slot
+	Entries   []*lmc.Label
+	memoryOps []*lmc.MemoryOp
+}
+
+func NewWTermSwitch(term *ir.TermSwitch, x *lmc.Mailbox, targets []*SwitchTarget, ops []*lmc.MemoryOp) *WTermSwitch {
+	return &WTermSwitch{
+		Term:      term,
+		X:         x,
+		Targets:   targets,
+		memoryOps: ops,
+	}
+}
+
+func (w *WTermSwitch) LMCInstructions() []lmc.Instruction {
+	edges := make([]*condEdge, len(w.Targets))
+	for k, t := range w.Targets {
+		edges[k] = newCondEdge(t.Target, t.Edge, t.Copies, t.Next)
+	}
+
+	instrs := []lmc.Instruction{lmc.NewLoadInstr(w.X)}
+	var after []*condEdge
+
+	if w.Slot == nil {
+		for k, step := range w.Steps {
+			if step != nil {
+				instrs = append(instrs, lmc.NewSubInstr(step))
+			}
+
+			instrs = append(instrs, lmc.NewBranchInstr(lmc.BRZero, edges[w.Cases[k]].label))
+		}
+
+		// the default is fallen through to
+		after = append(after, edges[0])
+	} else {
+		if w.Low != nil {
+			instrs = append(instrs, lmc.NewSubInstr(w.Low))
+		}
+
+		instrs = append(instrs,
+			lmc.NewSubInstr(w.Span),
+			lmc.NewBranchInstr(lmc.BRPositive, edges[0].label),
+			lmc.NewAddInstr(w.Span),
+			lmc.NewAddInstr(w.Word),
+			lmc.NewStoreInstr(w.Slot.Box),
+			lmc.NewLabelled(w.Slot.Label, lmc.NewSlotInstr(0, w.Entries)),
+		)
+
+		for k, entry := range w.Entries {
+			instrs = append(instrs, lmc.NewLabelled(entry, lmc.NewBranchInstr(lmc.BRAlways, edges[w.Cases[k]].label)))
+		}
+	}
+
+	for _, e := range edges {
+		if len(e.copies) > 0 && (len(after) == 0 || e != after[0]) {
+			after = append(after, e)
+		}
+	}
+
+	for k, e := range after {
+		if k < len(after)-1 {
+			e.next = false
+		}
+
+		// only a default fallen through to may be unlabelled, if no case is it
+		labelled := k > 0 || w.Slot != nil
+		for _, c := range w.Cases {
+			labelled = labelled || c == 0
+		}
+
+		instrs = append(instrs, e.instructions(labelled)...)
+	}
+
+	return instrs
+}
+
+func (w *WTermSwitch) LMCOps() []*lmc.MemoryOp {
+	return w.memoryOps
+}
+
+// AddMemoryOps adds memory operations, e.g., of the copies.
+func (w *WTermSwitch) AddMemoryOps(ops ...*lmc.MemoryOp) {
+	w.memoryOps = append(w.memoryOps, ops...)
+}
+
+// ---------- WTermRet ----------
+
+// WTermRet returns from a function. The entry function halts. A subroutine
+// copies the value returned, if any, to its Result and branches to its return
+// slot, Slot, unless it is next. It also handles `unreachable`, which halts, so
+// Term is either.
+type WTermRet struct {
+	LLInstructionBase
+	Term      ir.Terminator
+	Value     *lmc.Mailbox
+	Result    *lmc.Mailbox
+	Slot      *lmc.Label
+	Next      bool
+	memoryOps []*lmc.MemoryOp
+}
+
+func NewWTermRet(term ir.Terminator) *WTermRet {
+	return &WTermRet{
+		Term: term,
+	}
+}
+
+func (w *WTermRet) LMCInstructions() []lmc.Instruction {
+	if w.Slot == nil {
+		return []lmc.Instruction{
+			lmc.NewHaltInstr(),
+		}
+	}
+
+	var instrs []lmc.Instruction
+
+	if w.Value != nil {
+		instrs = append(instrs, lmc.NewLoadInstr(w.Value), lmc.NewStoreInstr(w.Result))
+	}
+
+	if !w.Next {
+		instrs = append(instrs, lmc.NewBranchInstr(lmc.BRAlways, w.Slot))
+	}
+
+	return instrs
+}
+
+func (w *WTermRet) LMCOps() []*lmc.MemoryOp {
+	return w.memoryOps
+}
+
+// AddMemoryOps adds the memory operations of the value returned.
+func (w *WTermRet) AddMemoryOps(ops ...*lmc.MemoryOp) {
+	w.memoryOps = append(w.memoryOps, ops...)
+}
+
+// ---------- WCall ----------
+
+// WCall calls a subroutine. The arguments are copied to its parameters, and
+// the word of a branch to Return, Word, is stored in its return slot, Slot;
+// then it is branched to. Return is the copy of its result to Dst, if it has
+// one, or otherwise whatever follows the call:
+//
+//	    LDA Args[0]
+//	    STA Params[0]
+//	    ...
+//	    LDA Word     ; BRA Return
+//	    STA Slot
+//	    BRA Entry
+//	Return LDA Result
+//	    STA Dst
+//
+// If the subroutine may call the caller again, i.e., it is recursive, Frame is
+// set: it is pushed before the arguments are copied, and popped once the result
+// has been, so Return may label the pop instead.
+type WCall struct {
+	LLInstructionBase
+	Args      []*lmc.Mailbox
+	Params    []*lmc.Mailbox
+	Entry     *lmc.Label
+	Slot      *lmc.Mailbox
+	Word      *lmc.Mailbox
+	Return    *lmc.Label
+	Result    *lmc.Mailbox
+	Dst       *lmc.Mailbox
+	Frame     *Frame
+	memoryOps []*lmc.MemoryOp
+}
+
+func NewWCall(instr *ir.InstCall, entry *lmc.Label, slot *lmc.Mailbox, word *lmc.Mailbox, ret *lmc.Label, ops []*lmc.MemoryOp) *WCall {
+	return &WCall{
+		LLInstructionBase: LLInstructionBase{
+			base: []ir.Instruction{instr},
+		},
+		Entry:     entry,
+		Slot:      slot,
+		Word:      word,
+		Return:    ret,
+		memoryOps: ops,
+	}
+}
+
+func (w *WCall) LMCInstructions() []lmc.Instruction {
+	var instrs []lmc.Instruction
+
+	if w.Frame != nil {
+		instrs = append(instrs, w.Frame.Push()...)
+	}
+
+	for k, param := range w.Params {
+		if w.Args[k] != param {
+			instrs = append(instrs, lmc.NewLoadInstr(w.Args[k]), lmc.NewStoreInstr(param))
+		}
+	}
+
+	instrs = append(instrs,
+		lmc.NewLoadInstr(w.Word),
+		lmc.NewStoreInstr(w.Slot),
+		lmc.NewBranchInstr(lmc.BRAlways, w.Entry),
+	)
+
+	var after []lmc.Instruction
+
+	if w.Dst != nil {
+		after = append(after, lmc.NewLoadInstr(w.Result), lmc.NewStoreInstr(w.Dst))
+	}
+
+	if w.Frame != nil {
+		after = append(after, w.Frame.Pop()...)
+	}
+
+	if len(after) > 0 {
+		after[0] = lmc.NewLabelled(w.Return, after[0])
+	}
+
+	return append(instrs, after...)
+}
+
+// ReturnsNext is true if the call compiles to nothing after branching to the
+// subroutine, so Return must label whatever is compiled next.
+func (w *WCall) ReturnsNext() bool {
+	return w.Dst == nil && w.Frame == nil
+}
+
+func (w *WCall) LMCOps() []*lmc.MemoryOp {
+	return w.memoryOps
+}
+
+// AddMemoryOps adds the memory operations of the result.
+func (w *WCall) AddMemoryOps(ops ...*lmc.MemoryOp) {
+	w.memoryOps = append(w.memoryOps, ops...)
+}
+
+// ---------- Stack ----------
+
+// Stack is the software stack: Size mailboxes of the array Box, of which the
+// first Pointer are in use. Store and Load hold the words of `STA Box` and
+// `LDA Box`; adding an index to one gives the word accessing the mailbox at
+// that index, which is stored in a slot (see lmc.SlotInstr) to be executed.
+// Pushing more than fits branches to Overflow instead, see
+// *Stack#OverflowInstructions.
+type Stack struct {
+	Box      *lmc.Mailbox
+	Size     int
+	Pointer  *lmc.Mailbox
+	Store    *lmc.Mailbox
+	Load     *lmc.Mailbox
+	Overflow *lmc.Label
+	Signal   *lmc.Mailbox
+}
+
+// OverflowInstructions gives the code branched to when the stack overflows,
+// which outputs Signal and halts.
+func (s *Stack) OverflowInstructions() []lmc.Instruction {
+	return []lmc.Instruction{
+		lmc.NewLabelled(s.Overflow, lmc.NewLoadInstr(s.Signal)),
+		lmc.NewOutputInstr(),
+		lmc.NewHaltInstr(),
+	}
+}
+
+// Frame is the mailboxes a call saves on the stack, Boxes, and what is needed
+// to push and pop them. Offsets holds the constant index of each within the
+// frame, nil for the first, and Length their number; Limit is the constant one
+// more than the most mailboxes of the stack that may be used before the push.
+// Each mailbox is pushed, and popped, through its own slot.
+type Frame struct {
+	Stack     *Stack
+	Boxes     []*lmc.Mailbox
+	Offsets   []*lmc.Mailbox
+	Length    *lmc.Mailbox
+	Limit     *lmc.Mailbox
+	PushSlots []*Slot
+	PopSlots  []*Slot
+}
+
+// access gives the instructions accessing each mailbox of the frame, on top of
+// the stack, through the slots given: first the word of the access, from a
+// template, is stored in the slot, then around the slot are the instructions
+// given by around, for the mailbox at that index.
+func (f *Frame) access(template *lmc.Mailbox, slots []*Slot, around func(k int, slot lmc.Instruction) []lmc.Instruction) []lmc.Instruction {
+	var instrs []lmc.Instruction
+
+	for k, slot := range slots {
+		instrs = append(instrs, lmc.NewLoadInstr(template), lmc.NewAddInstr(f.Stack.Pointer))
+
+		if f.Offsets[k] != nil {
+			instrs = append(instrs, lmc.NewAddInstr(f.Offsets[k]))
+		}
+
+		instrs = append(instrs, lmc.NewStoreInstr(slot.Box))
+		instrs = append(instrs, around(k, lmc.NewLabelled(slot.Label, lmc.NewSlotInstr(0, nil)))...)
+	}
+
+	return instrs
+}
+
+// Push gives the instructions pushing the frame, after checking it fits:
+//
+//	    LDA Pointer
+//	    SUB Limit
+//	    BRP Overflow
+//	    LDA Store    ; for each mailbox
+//	    ADD Pointer
+//	    ADD Offsets[k]
+//	    STA PushSlots[k]
+//	    LDA Boxes[k]
+//	PushSlots[k] DAT 0 ; STA Box + Pointer + k
+//	    LDA Pointer
+//	    ADD Length
+//	    STA Pointer
+func (f *Frame) Push() []lmc.Instruction {
+	instrs := []lmc.Instruction{
+		lmc.NewLoadInstr(f.Stack.Pointer),
+		lmc.NewSubInstr(f.Limit),
+		lmc.NewBranchInstr(lmc.BRPositive, f.Stack.Overflow),
+	}
+
+	instrs = append(instrs, f.access(f.Stack.Store, f.PushSlots, func(k int, slot lmc.Instruction) []lmc.Instruction {
+		return []lmc.Instruction{lmc.NewLoadInstr(f.Boxes[k]), slot}
+	})...)
+
+	return append(instrs,
+		lmc.NewLoadInstr(f.Stack.Pointer),
+		lmc.NewAddInstr(f.Length),
+		lmc.NewStoreInstr(f.Stack.Pointer),
+	)
+}
+
+// Pop gives the instructions popping the frame, restoring each mailbox:
+//
+//	    LDA Pointer
+//	    SUB Length
+//	    STA Pointer
+//	    LDA Load     ; for each mailbox
+//	    ADD Pointer
+//	    ADD Offsets[k]
+//	    STA PopSlots[k]
+//	PopSlots[k] DAT 0 ; LDA Box + Pointer + k
+//	    STA Boxes[k]
+func (f *Frame) Pop() []lmc.Instruction {
+	instrs := []lmc.Instruction{
+		lmc.NewLoadInstr(f.Stack.Pointer),
+		lmc.NewSubInstr(f.Length),
+		lmc.NewStoreInstr(f.Stack.Pointer),
+	}
+
+	return append(instrs, f.access(f.Stack.Load, f.PopSlots, func(k int, slot lmc.Instruction) []lmc.Instruction {
+		return []lmc.Instruction{slot, lmc.NewStoreInstr(f.Boxes[k])}
+	})...)
+}
+
+// ---------- WInstPhi ----------
+
+// WInstPhi is a PHI, which compiles to nothing as copies into its mailbox are
+// made by the terminators of its predecessors.
+type WInstPhi struct {
+	LLInstructionBase
+	Dst       *lmc.Mailbox
+	memoryOps []*lmc.MemoryOp
+}
+
+func NewWInstPhi(instr *ir.InstPhi, dst *lmc.Mailbox, ops []*lmc.MemoryOp) *WInstPhi {
+	return &WInstPhi{
+		LLInstructionBase: LLInstructionBase{
+			base: []ir.Instruction{instr},
+		},
+		Dst:       dst,
+		memoryOps: ops,
+	}
+}
+
+func (w *WInstPhi) LMCInstructions() []lmc.Instruction {
+	return nil
+}
+
+func (w *WInstPhi) LMCOps() []*lmc.MemoryOp {
+	return w.memoryOps
+}
@@ -0,0 +1,215 @@
+package instructions
+
+import (
+	"github.com/clr1107/lmc-llvm-target/lmc"
+	"github.com/llir/llvm/ir"
+)
+
+// ---------- WInstZExt ----------
+
+// WInstZExt is the zero extension of a value of fewer bits than a word, which
+// holds it signed: if it is negative, Mod, 2 to the number of bits, is added.
+//
+//	  LDA From
+//	  ADD From
+//	  SUB From
+//	  BRP P
+//	  LDA From
+//	  ADD Mod
+//	  BRA E
+//	P LDA From
+//	E STA To
+type WInstZExt struct {
+	LLInstructionBase
+	From      *lmc.Mailbox
+	To        *lmc.Mailbox
+	Mod       *lmc.Mailbox
+	Positive  *lmc.Label
+	End       *lmc.Label
+	memoryOps []*lmc.MemoryOp
+}
+
+func NewWInstZExt(instr ir.Instruction, from *lmc.Mailbox, to *lmc.Mailbox, mod *lmc.Mailbox, positive *lmc.Label, end *lmc.Label, ops []*lmc.MemoryOp) *WInstZExt {
+	return &WInstZExt{
+		LLInstructionBase: LLInstructionBase{
+			base: []ir.Instruction{instr},
+		},
+		From:      from,
+		To:        to,
+		Mod:       mod,
+		Positive:  positive,
+		End:       end,
+		memoryOps: ops,
+	}
+}
+
+func (w *WInstZExt) LMCInstructions() []lmc.Instruction {
+	return []lmc.Instruction{
+		lmc.NewLoadInstr(w.From),
+		lmc.NewAddInstr(w.From),
+		lmc.NewSubInstr(w.From),
+		lmc.NewBranchInstr(lmc.BRPositive, w.Positive),
+		lmc.NewLoadInstr(w.From),
+		lmc.NewAddInstr(w.Mod),
+		lmc.NewBranchInstr(lmc.BRAlways, w.End),
+		lmc.NewLabelled(w.Positive, lmc.NewLoadInstr(w.From)),
+		lmc.NewLabelled(w.End, lmc.NewStoreInstr(w.To)),
+	}
+}
+
+func (w *WInstZExt) LMCOps() []*lmc.MemoryOp {
+	return w.memoryOps
+}
+
+// ---------- WInstNegate ----------
+
+// WInstNegate is the sign extension of an i1, 0 or 1, to 0 or -1:
+//
+//	LDA Zero
+//	SUB From
+//	STA To
+type WInstNegate struct {
+	LLInstructionBase
+	From      *lmc.Mailbox
+	To        *lmc.Mailbox
+	Zero      *lmc.Mailbox
+	memoryOps []*lmc.MemoryOp
+}
+
+func NewWInstNegate(instr ir.Instruction, from *lmc.Mailbox, to *lmc.Mailbox, zero *lmc.Mailbox, ops []*lmc.MemoryOp) *WInstNegate {
+	return &WInstNegate{
+		LLInstructionBase: LLInstructionBase{
+			base: []ir.Instruction{instr},
+		},
+		From:      from,
+		To:        to,
+		Zero:      zero,
+		memoryOps: ops,
+	}
+}
+
+func (w *WInstNegate) LMCInstructions() []lmc.Instruction {
+	return []lmc.Instruction{
+		lmc.NewLoadInstr(w.Zero),
+		lmc.NewSubInstr(w.From),
+		lmc.NewStoreInstr(w.To),
+	}
+}
+
+func (w *WInstNegate) LMCOps() []*lmc.MemoryOp {
+	return w.memoryOps
+}
+
+// ---------- WInstWrap ----------
+
+// WInstWrapLabels is the number of labels WInstWrap needs.
+const WInstWrapLabels = 6
+
+// WInstWrap is a truncation wrapping around exactly: From modulo Mod, 2 to the
+// number of bits, by subtracting Mod until it is negative, as WInstRem. A
+// negative From is complemented first, -1 - From, and the remainder is
+// complemented after, Mask - To. The remainder is then signed, values of at
+// least Half having Mod subtracted, unless Half is nil, as for an i1, which is
+// 0 or 1. It needs WInstWrapLabels labels, or only the first 4 if Half is nil.
+//
+//	  LDA From
+//	  ADD From
+//	  SUB From
+//	  BRP P
+//	  LDA Max
+//	  SUB From
+//	  BRA L
+//	P LDA From
+//	L SUB Mod
+//	  BRP L
+//	  ADD Mod
+//	  STA To
+//	  LDA From
+//	  ADD From
+//	  SUB From
+//	  BRP S
+//	  LDA Mask
+//	  SUB To
+//	  BRA E
+//	S LDA To
+//	E STA To
+//	  SUB Half     ; if Half is not nil
+//	  BRP W
+//	  ADD Half
+//	  BRA F
+//	W SUB Half
+//	F STA To
+type WInstWrap struct {
+	LLInstructionBase
+	From      *lmc.Mailbox
+	To        *lmc.Mailbox
+	Mod       *lmc.Mailbox
+	Mask      *lmc.Mailbox
+	Half      *lmc.Mailbox
+	Max       *lmc.Mailbox
+	Labels    []*lmc.Label
+	memoryOps []*lmc.MemoryOp
+}
+
+func NewWInstWrap(instr ir.Instruction, from *lmc.Mailbox, to *lmc.Mailbox, mod *lmc.Mailbox, mask *lmc.Mailbox, half *lmc.Mailbox, max *lmc.Mailbox, labels []*lmc.Label, ops []*lmc.MemoryOp) *WInstWrap {
+	return &WInstWrap{
+		LLInstructionBase: LLInstructionBase{
+			base: []ir.Instruction{instr},
+		},
+		From:      from,
+		To:        to,
+		Mod:       mod,
+		Mask:      mask,
+		Half:      half,
+		Max:       max,
+		Labels:    labels,
+		memoryOps: ops,
+	}
+}
+
+func (w *WInstWrap) LMCInstructions() []lmc.Instruction {
+	p, l, s, e := w.Labels[0], w.Labels[1], w.Labels[2], w.Labels[3]
+
+	instrs := []lmc.Instruction{
+		lmc.NewLoadInstr(w.From),
+		lmc.NewAddInstr(w.From),
+		lmc.NewSubInstr(w.From),
+		lmc.NewBranchInstr(lmc.BRPositive, p),
+		lmc.NewLoadInstr(w.Max),
+		lmc.NewSubInstr(w.From),
+		lmc.NewBranchInstr(lmc.BRAlways, l),
+		lmc.NewLabelled(p, lmc.NewLoadInstr(w.From)),
+		lmc.NewLabelled(l, lmc.NewSubInstr(w.Mod)),
+		lmc.NewBranchInstr(lmc.BRPositive, l),
+		lmc.NewAddInstr(w.Mod),
+		lmc.NewStoreInstr(w.To),
+		lmc.NewLoadInstr(w.From),
+		lmc.NewAddInstr(w.From),
+		lmc.NewSubInstr(w.From),
+		lmc.NewBranchInstr(lmc.BRPositive, s),
+		lmc.NewLoadInstr(w.Mask),
+		lmc.NewSubInstr(w.To),
+		lmc.NewBranchInstr(lmc.BRAlways, e),
+		lmc.NewLabelled(s, lmc.NewLoadInstr(w.To)),
+		lmc.NewLabelled(e, lmc.NewStoreInstr(w.To)),
+	}
+
+	if w.Half == nil {
+		return instrs
+	}
+
+	wrap, f := w.Labels[4], w.Labels[5]
+
+	return append(instrs,
+		lmc.NewSubInstr(w.Half),
+		lmc.NewBranchInstr(lmc.BRPositive, wrap),
+		lmc.NewAddInstr(w.Half),
+		lmc.NewBranchInstr(lmc.BRAlways, f),
+		lmc.NewLabelled(wrap, lmc.NewSubInstr(w.Half)),
+		lmc.NewLabelled(f, lmc.NewStoreInstr(w.To)),
+	)
+}
+
+func (w *WInstWrap) LMCOps() []*lmc.MemoryOp {
+	return w.memoryOps
+}
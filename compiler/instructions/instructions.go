@@ -45,6 +45,8 @@ func (w *EmptyWInst) LMCOps() []*lmc.MemoryOp {
 
 // ---------- WInstBitcast ----------
 
+// WInstBitcast copies From to To: a bitcast, or a conversion that does not
+// change the value held, e.g., a sign extension.
 type WInstBitcast struct {
 	LLInstructionBase
 	From      *lmc.Mailbox
@@ -52,7 +54,7 @@ type WInstBitcast struct {
 	memoryOps []*lmc.MemoryOp
 }
 
-func NewWInstBitcast(instr *ir.InstBitCast, fromBox *lmc.Mailbox, toBox *lmc.Mailbox, ops []*lmc.MemoryOp) *WInstBitcast {
+func NewWInstBitcast(instr ir.Instruction, fromBox *lmc.Mailbox, toBox *lmc.Mailbox, ops []*lmc.MemoryOp) *WInstBitcast {
 	return &WInstBitcast{
 		LLInstructionBase: LLInstructionBase{
 			base: []ir.Instruction{instr},
@@ -74,80 +76,320 @@ func (w *WInstBitcast) LMCOps() []*lmc.MemoryOp {
 	return w.memoryOps
 }
 
-// ---------- WInstICmp ----------
+// ---------- Cmp ----------
 
-type iCmpMethod int
+// Sign is what is known of the sign of a value at compile time.
+type Sign int
 
 const (
-	iCmpGtEq = iota
-	iCmpLtEq
-	iCmpGt
-	iCmpLt
-	iCmpEq
-	_
-	iCmpNeq
+	SignUnknown  Sign = iota
+	SignPositive      // including zero
+	SignNegative
 )
 
-type WInstICmp struct {
-	LLInstructionBase
-	X         *lmc.Mailbox
-	Y         *lmc.Mailbox
-	Dst       *lmc.Mailbox
-	oneConst  *lmc.Mailbox
-	method    iCmpMethod
-	memoryOps []*lmc.MemoryOp
+// Cmp is an integer comparison, lowered to branches. `SUB` sets the negative
+// flag on an unsigned borrow, so unsigned `X < Y` is decided by `X - Y`. Signed
+// `X < Y` is decided by their signs, then if they are the same, by `X - Y`. The
+// sign of V is that of `2V - V`:
+//
+//	LDA V
+//	ADD V
+//	SUB V    ; negative iff 2V wrapped around, i.e., V >= modulus / 2
+//
+// Signs known at compile time, e.g., of constants, are not tested. Labels
+// must hold as many new labels as *Cmp#LabelsNeeded.
+type Cmp struct {
+	Pred   enum.IPred
+	X      *lmc.Mailbox
+	Y      *lmc.Mailbox
+	XSign  Sign
+	YSign  Sign
+	Labels []*lmc.Label
 }
 
-func NewWInstICmp(instr *ir.InstICmp, x *lmc.Mailbox, y *lmc.Mailbox, dst *lmc.Mailbox, oneConst *lmc.Mailbox, ops []*lmc.MemoryOp) *WInstICmp {
-	var m iCmpMethod
+func NewCmp(pred enum.IPred, x *lmc.Mailbox, y *lmc.Mailbox, xSign Sign, ySign Sign) *Cmp {
+	return &Cmp{
+		Pred:  pred,
+		X:     x,
+		Y:     y,
+		XSign: xSign,
+		YSign: ySign,
+	}
+}
+
+// operands gives the comparison as `A < B`, and whether it is negated.
+func (c *Cmp) operands() (a *lmc.Mailbox, b *lmc.Mailbox, aSign Sign, bSign Sign, negated bool) {
+	switch c.Pred {
+	case enum.IPredSGT, enum.IPredUGT:
+		return c.Y, c.X, c.YSign, c.XSign, false
+	case enum.IPredSGE, enum.IPredUGE:
+		return c.X, c.Y, c.XSign, c.YSign, true
+	case enum.IPredSLE, enum.IPredULE:
+		return c.Y, c.X, c.YSign, c.XSign, true
+	default:
+		return c.X, c.Y, c.XSign, c.YSign, false
+	}
+}
+
+// Unsigned returns true if the comparison is of unsigned integers.
+func (c *Cmp) Unsigned() bool {
+	return c.Pred >= enum.IPredUGE
+}
+
+// LabelsNeeded gives how many labels the branches need, besides their targets.
+func (c *Cmp) LabelsNeeded() int {
+	if c.Pred == enum.IPredEQ || c.Pred == enum.IPredNE || c.Unsigned() {
+		return 0
+	}
+
+	_, _, aSign, bSign, _ := c.operands()
+
+	switch {
+	case aSign == SignUnknown && bSign == SignUnknown:
+		return 2
+	case aSign == SignPositive && bSign == SignUnknown, aSign == SignUnknown && bSign == SignPositive:
+		return 1
+	default:
+		return 0
+	}
+}
 
-	switch instr.Pred {
-	case enum.IPredEQ: // equals
-		m = iCmpEq
+// Branches gives the instructions branching to yes if the comparison holds and
+// otherwise to no. The last is always `BRA` to either, so it may be left out
+// by the caller to fall through instead.
+func (c *Cmp) Branches(yes *lmc.Label, no *lmc.Label) []lmc.Instruction {
+	switch c.Pred {
+	case enum.IPredEQ:
+		return []lmc.Instruction{
+			lmc.NewLoadInstr(c.X),
+			lmc.NewSubInstr(c.Y),
+			lmc.NewBranchInstr(lmc.BRZero, yes),
+			lmc.NewBranchInstr(lmc.BRAlways, no),
+		}
 	case enum.IPredNE:
-		m = iCmpNeq
-	case enum.IPredSGT:
-		m = iCmpGt
-	case enum.IPredSGE:
-		m = iCmpGtEq
-	case enum.IPredSLT:
-		m = iCmpLt
-	case enum.IPredSLE:
-		m = iCmpLtEq
+		return []lmc.Instruction{
+			lmc.NewLoadInstr(c.X),
+			lmc.NewSubInstr(c.Y),
+			lmc.NewBranchInstr(lmc.BRZero, no),
+			lmc.NewBranchInstr(lmc.BRAlways, yes),
+		}
+	}
+
+	a, b, aSign, bSign, negated := c.operands()
+	if negated {
+		yes, no = no, yes
+	}
+
+	sign := func(v *lmc.Mailbox, positive *lmc.Label) []lmc.Instruction {
+		return []lmc.Instruction{
+			lmc.NewLoadInstr(v),
+			lmc.NewAddInstr(v),
+			lmc.NewSubInstr(v),
+			lmc.NewBranchInstr(lmc.BRPositive, positive),
+		}
+	}
+
+	// unsigned or the same sign, so a < b iff a - b borrows
+	same := []lmc.Instruction{
+		lmc.NewLoadInstr(a),
+		lmc.NewSubInstr(b),
+		lmc.NewBranchInstr(lmc.BRPositive, no),
+		lmc.NewBranchInstr(lmc.BRAlways, yes),
+	}
+
+	if c.Unsigned() {
+		return same
+	}
+
+	var instrs []lmc.Instruction
+
+	switch {
+	case aSign != SignUnknown && bSign != SignUnknown:
+		if aSign == bSign {
+			return same
+		} else if aSign == SignNegative {
+			return []lmc.Instruction{lmc.NewBranchInstr(lmc.BRAlways, yes)}
+		}
+
+		return []lmc.Instruction{lmc.NewBranchInstr(lmc.BRAlways, no)}
+	case aSign == SignNegative:
+		instrs = sign(b, yes)
+	case bSign == SignNegative:
+		instrs = sign(a, no)
+	case aSign == SignPositive:
+		instrs = append(sign(b, c.Labels[0]), lmc.NewBranchInstr(lmc.BRAlways, no))
+		same[0] = lmc.NewLabelled(c.Labels[0], same[0])
+	case bSign == SignPositive:
+		instrs = append(sign(a, c.Labels[0]), lmc.NewBranchInstr(lmc.BRAlways, yes))
+		same[0] = lmc.NewLabelled(c.Labels[0], same[0])
 	default:
-		panic("unsigned integer comparisons are unimplemented")
+		// a negative falls through to b's sign; a positive is at the end
+		positive := sign(b, c.Labels[1])
+		positive[0] = lmc.NewLabelled(c.Labels[0], positive[0])
+
+		instrs = append(sign(a, c.Labels[0]), sign(b, yes)...)
+		same[0] = lmc.NewLabelled(c.Labels[1], same[0])
+
+		instrs = append(instrs, same...)
+		instrs = append(instrs, positive...)
+
+		return append(instrs, lmc.NewBranchInstr(lmc.BRAlways, no))
+	}
+
+	return append(instrs, same...)
+}
+
+// branchesTo returns true if any branch but the last targets the label.
+func branchesTo(instrs []lmc.Instruction, label *lmc.Label) bool {
+	for _, instr := range instrs[:len(instrs)-1] {
+		if c, ok := lmc.Unwrap(instr).(*lmc.BranchInstr); ok && c.Identifier() == label.Identifier() {
+			return true
+		}
 	}
 
+	return false
+}
+
+// lastTarget gives the target of the last instruction, a `BRA`.
+func lastTarget(instrs []lmc.Instruction) string {
+	return lmc.Unwrap(instrs[len(instrs)-1]).(*lmc.BranchInstr).Identifier()
+}
+
+// ---------- WInstICmp ----------
+
+// WInstICmp stores the result of a comparison in Dst, exactly 0 or 1:
+//
+//	    ...       ; branches to T or F
+//	F   LDA ZERO
+//	    BRA S
+//	T   LDA ONE
+//	S   STA Dst
+//
+// Whichever of T and F the branches end with is laid out first, falling
+// through. Labels must hold three new labels, for T, F and S.
+type WInstICmp struct {
+	LLInstructionBase
+	Cmp       *Cmp
+	Dst       *lmc.Mailbox
+	oneConst  *lmc.Mailbox
+	zeroConst *lmc.Mailbox
+	Labels    []*lmc.Label
+	memoryOps []*lmc.MemoryOp
+}
+
+func NewWInstICmp(instr *ir.InstICmp, cmp *Cmp, dst *lmc.Mailbox, oneConst *lmc.Mailbox, zeroConst *lmc.Mailbox, ops []*lmc.MemoryOp) *WInstICmp {
 	return &WInstICmp{
-		X:         x,
-		Y:         y,
+		LLInstructionBase: LLInstructionBase{
+			base: []ir.Instruction{instr},
+		},
+		Cmp:       cmp,
 		Dst:       dst,
 		oneConst:  oneConst,
-		method:    m,
+		zeroConst: zeroConst,
 		memoryOps: ops,
 	}
 }
 
 func (w *WInstICmp) LMCInstructions() []lmc.Instruction {
-	first := w.X
-	second := w.Y
+	t, f, s := w.Labels[0], w.Labels[1], w.Labels[2]
+	instrs := w.Cmp.Branches(t, f)
 
-	if w.method&0x1 != 0 { // if < or <=
+	var first, second lmc.Instruction = lmc.NewLoadInstr(w.zeroConst), lmc.NewLoadInstr(w.oneConst)
+	firstLabel, secondLabel := f, t
+
+	if lastTarget(instrs) == t.Identifier() {
 		first, second = second, first
+		firstLabel, secondLabel = secondLabel, firstLabel
+	}
+
+	if branchesTo(instrs, firstLabel) {
+		first = lmc.NewLabelled(firstLabel, first)
+	}
+
+	return append(instrs[:len(instrs)-1],
+		first,
+		lmc.NewBranchInstr(lmc.BRAlways, s),
+		lmc.NewLabelled(secondLabel, second),
+		lmc.NewLabelled(s, lmc.NewStoreInstr(w.Dst)),
+	)
+}
+
+func (w *WInstICmp) LMCOps() []*lmc.MemoryOp {
+	return w.memoryOps
+}
+
+// ---------- WInstSelect ----------
+
+// WInstSelect stores True in Dst if the condition holds and otherwise False.
+// The condition is either the mailbox Cond being other than 0, or Cmp if it is
+// set, fusing the comparison with the select. It is laid out as WInstICmp:
+//
+//	    LDA Cond     ; or the branches of Cmp
+//	    BRZ F
+//	T   LDA True
+//	    BRA S
+//	F   LDA False
+//	S   STA Dst
+//
+// Labels must hold three new labels, for T, F and S.
+type WInstSelect struct {
+	LLInstructionBase
+	Cond      *lmc.Mailbox
+	Cmp       *Cmp
+	True      *lmc.Mailbox
+	False     *lmc.Mailbox
+	Dst       *lmc.Mailbox
+	Labels    []*lmc.Label
+	memoryOps []*lmc.MemoryOp
+}
+
+func NewWInstSelect(instrs []ir.Instruction, cond *lmc.Mailbox, t *lmc.Mailbox, f *lmc.Mailbox, dst *lmc.Mailbox, ops []*lmc.MemoryOp) *WInstSelect {
+	return &WInstSelect{
+		LLInstructionBase: LLInstructionBase{
+			base: instrs,
+		},
+		Cond:      cond,
+		True:      t,
+		False:     f,
+		Dst:       dst,
+		memoryOps: ops,
+	}
+}
+
+func (w *WInstSelect) LMCInstructions() []lmc.Instruction {
+	t, f, s := w.Labels[0], w.Labels[1], w.Labels[2]
+
+	var instrs []lmc.Instruction
+	if w.Cmp != nil {
+		instrs = w.Cmp.Branches(t, f)
+	} else {
+		instrs = []lmc.Instruction{
+			lmc.NewLoadInstr(w.Cond),
+			lmc.NewBranchInstr(lmc.BRZero, f),
+			lmc.NewBranchInstr(lmc.BRAlways, t),
+		}
 	}
 
-	instrs := []lmc.Instruction{
-		lmc.NewLoadInstr(first),
-		lmc.NewSubInstr(second),
+	var first, second lmc.Instruction = lmc.NewLoadInstr(w.False), lmc.NewLoadInstr(w.True)
+	firstLabel, secondLabel := f, t
+
+	if lastTarget(instrs) == t.Identifier() {
+		first, second = second, first
+		firstLabel, secondLabel = secondLabel, firstLabel
 	}
 
-	if w.method&0x10 != 0 && w.method < 4 { // if >= or <=
-		instrs = append(instrs, lmc.NewAddInstr(w.oneConst))
+	if branchesTo(instrs, firstLabel) {
+		first = lmc.NewLabelled(firstLabel, first)
 	}
 
-	return append(instrs, lmc.NewStoreInstr(w.Dst))
+	return append(instrs[:len(instrs)-1],
+		first,
+		lmc.NewBranchInstr(lmc.BRAlways, s),
+		lmc.NewLabelled(secondLabel, second),
+		lmc.NewLabelled(s, lmc.NewStoreInstr(w.Dst)),
+	)
 }
 
-func (w *WInstICmp) LMCOps() []*lmc.MemoryOp {
+func (w *WInstSelect) LMCOps() []*lmc.MemoryOp {
 	return w.memoryOps
 }
@@ -5,6 +5,47 @@ import (
 	"github.com/llir/llvm/ir"
 )
 
+// ---------- Indexed access ----------
+
+// Slot is a slot (see lmc.SlotInstr) that the word of an access is stored to,
+// to be executed: its label, and the label's mailbox.
+type Slot struct {
+	Label *lmc.Label
+	Box *lmc.Mailbox
+}
+
+// Indexed is an access to an element of an array whose index is only known as
+// the program runs. Word holds the word of the access of the first element,
+// `LDA Array` or `STA Array`; the index is added to it, giving the access of the
+// element, which is stored in Slot to be executed.
+type Indexed struct {
+	Word *lmc.Mailbox
+	Index *lmc.Mailbox
+	Slot *Slot
+}
+
+// Instructions gives the instructions of the access, with those given before
+// and after the slot:
+//
+//	    LDA Word
+//	    ADD Index
+//	    STA Slot
+//	    ...          ; before
+//	Slot DAT 0       ; LDA Array + Index, or STA Array + Index
+//	    ...          ; after
+func (x *Indexed) Instructions(before []lmc.Instruction, after []lmc.Instruction) []lmc.Instruction {
+	instrs := []lmc.Instruction{
+		lmc.NewLoadInstr(x.Word),
+		lmc.NewAddInstr(x.Index),
+		lmc.NewStoreInstr(x.Slot.Box),
+	}
+
+	instrs = append(instrs, before...)
+	instrs = append(instrs, lmc.NewLabelled(x.Slot.Label, lmc.NewSlotInstr(0, nil)))
+
+	return append(instrs, after...)
+}
+
 // ---------- WInstAlloca ----------
 
 type WInstAlloca struct {
@@ -33,10 +74,13 @@ func (w *WInstAlloca) LMCOps() []*lmc.MemoryOp {
 
 // ---------- WInstLoad ----------
 
+// WInstLoad is a load from X into Dst. If Indexed is set, it is from an element
+// of the array X instead.
 type WInstLoad struct {
 	LLInstructionBase
 	X *lmc.Mailbox
 	Dst *lmc.Mailbox
+	Indexed *Indexed
 	memoryOps []*lmc.MemoryOp
 }
 
@@ -52,6 +96,10 @@ func NewWInstLoad(instr *ir.InstLoad, x *lmc.Mailbox, dst *lmc.Mailbox, ops []*l
 }
 
 func (w *WInstLoad) LMCInstructions() []lmc.Instruction {
+	if w.Indexed != nil {
+		return w.Indexed.Instructions(nil, []lmc.Instruction{lmc.NewStoreInstr(w.Dst)})
+	}
+
 	return []lmc.Instruction{
 		lmc.NewLoadInstr(w.X),
 		lmc.NewStoreInstr(w.Dst),
@@ -64,10 +112,13 @@ func (w *WInstLoad) LMCOps() []*lmc.MemoryOp {
 
 // ---------- WInstStore ----------
 
+// WInstStore is a store of X into Dst. If Indexed is set, it is into an element
+// of the array Dst instead.
 type WInstStore struct {
 	LLInstructionBase
 	X *lmc.Mailbox
 	Dst *lmc.Mailbox
+	Indexed *Indexed
 	memoryOps []*lmc.MemoryOp
 }
 
@@ -83,6 +134,10 @@ func NewWInstStore(instr *ir.InstStore, x *lmc.Mailbox, dst *lmc.Mailbox, ops []
 }
 
 func (w *WInstStore) LMCInstructions() []lmc.Instruction {
+	if w.Indexed != nil {
+		return w.Indexed.Instructions([]lmc.Instruction{lmc.NewLoadInstr(w.X)}, nil)
+	}
+
 	return []lmc.Instruction{
 		lmc.NewLoadInstr(w.X),
 		lmc.NewStoreInstr(w.Dst),
@@ -92,3 +147,45 @@ func (w *WInstStore) LMCInstructions() []lmc.Instruction {
 func (w *WInstStore) LMCOps() []*lmc.MemoryOp {
 	return w.memoryOps
 }
+
+// ---------- WInstGetElementPtr ----------
+
+// WInstGetElementPtr is a getelementptr, giving the index of an element of an
+// array in Dst: the sum of Terms, each a mailbox holding a variable index, or
+// the constant part of the index. A constant index needs no mailbox, so has no
+// terms, and compiles to nothing.
+type WInstGetElementPtr struct {
+	LLInstructionBase
+	Terms []*lmc.Mailbox
+	Dst *lmc.Mailbox
+	memoryOps []*lmc.MemoryOp
+}
+
+func NewWInstGetElementPtr(instr *ir.InstGetElementPtr, terms []*lmc.Mailbox, dst *lmc.Mailbox, ops []*lmc.MemoryOp) *WInstGetElementPtr {
+	return &WInstGetElementPtr{
+		LLInstructionBase: LLInstructionBase{
+			base: []ir.Instruction{instr},
+		},
+		Terms: terms,
+		Dst: dst,
+		memoryOps: ops,
+	}
+}
+
+func (w *WInstGetElementPtr) LMCInstructions() []lmc.Instruction {
+	if len(w.Terms) == 0 {
+		return nil
+	}
+
+	instrs := []lmc.Instruction{lmc.NewLoadInstr(w.Terms[0])}
+
+	for _, term := range w.Terms[1:] {
+		instrs = append(instrs, lmc.NewAddInstr(term))
+	}
+
+	return append(instrs, lmc.NewStoreInstr(w.Dst))
+}
+
+func (w *WInstGetElementPtr) LMCOps() []*lmc.MemoryOp {
+	return w.memoryOps
+}
@@ -0,0 +1,599 @@
+package instructions
+
+import (
+	"github.com/clr1107/lmc-llvm-target/lmc"
+)
+
+// ---------- Arith ----------
+
+// Arith is the mailboxes the signed multiplication and division routines need,
+// see *Routine: the constants 0 and 1, and Sign, which holds whether the
+// result is to be negated. Each routine computes the magnitudes of its
+// parameters first, so the loops only count up, or down, to a non-negative
+// value; a word is taken as negative if doubling it overflows, which, as the
+// magnitude of the least value is itself, is then correct unsigned. The
+// doubling routines need Scaled, Count, Diff and Dividend too, see doubling;
+// they are nil unless one is used.
+type Arith struct {
+	Zero     *lmc.Mailbox
+	One      *lmc.Mailbox
+	Sign     *lmc.Mailbox
+	Scaled   *lmc.Mailbox
+	Count    *lmc.Mailbox
+	Diff     *lmc.Mailbox
+	Dividend *lmc.Mailbox
+}
+
+// Known is which parameters of a routine are known not to be negative, whose
+// magnitudes a body then need not take. A copy of a routine compiled inline
+// knows this from its operands; a shared one knows neither.
+type Known struct {
+	X bool
+	Y bool
+}
+
+// MulLabels is the number of labels MulBody needs.
+const MulLabels = 3
+
+// MulBody gives the body of the routine for X times Y into Result, counting
+// the magnitude of X down, adding Y, or -Y if X is negative, each time; like
+// every body, it ends by falling through to end. If X is known not to be
+// negative, it starts at A:
+//
+//	  LDA X
+//	  ADD X
+//	  SUB X
+//	  BRP A
+//	  LDA Zero
+//	  SUB X
+//	  STA X
+//	  LDA Zero
+//	  SUB Y
+//	  STA Y
+//	A LDA Zero
+//	  STA Result
+//	  BRA T
+//	N STA X
+//	  LDA Result
+//	  ADD Y
+//	  STA Result
+//	T LDA X
+//	  SUB One
+//	  BRP N
+func (a *Arith) MulBody(x *lmc.Mailbox, y *lmc.Mailbox, result *lmc.Mailbox, known Known, labels []*lmc.Label, end *lmc.Label) []lmc.Instruction {
+	positive, next, test := labels[0], labels[1], labels[2]
+
+	instrs, from := a.mulSign(x, y, known, positive)
+	instrs = append(instrs,
+		labelled(from, lmc.NewLoadInstr(a.Zero)),
+		lmc.NewStoreInstr(result),
+		lmc.NewBranchInstr(lmc.BRAlways, test),
+		lmc.NewLabelled(next, lmc.NewStoreInstr(x)),
+		lmc.NewLoadInstr(result),
+		lmc.NewAddInstr(y),
+		lmc.NewStoreInstr(result),
+		lmc.NewLabelled(test, lmc.NewLoadInstr(x)),
+		lmc.NewSubInstr(a.One),
+		lmc.NewBranchInstr(lmc.BRPositive, next),
+	)
+
+	return instrs
+}
+
+// DivLabels is the number of labels DivBody needs.
+const DivLabels = 4
+
+// DivBody gives the body of the routine for X divided by Y into Result,
+// truncated toward zero as in C: the magnitude of Y is subtracted from that of
+// X until it would be negative, counting each time, and the count negated if
+// exactly one of X and Y is negative. If byZero is not nil it is branched to
+// if Y is 0; otherwise that never ends. The magnitude of an operand known not
+// to be negative is not taken, nor Sign set for it, and if neither can be
+// negative neither is the count.
+//
+//	  LDA Y        ; if byZero is not nil
+//	  BRZ byZero
+//	  LDA Zero
+//	  STA Sign
+//	  STA Result
+//	  LDA Y
+//	  ADD Y
+//	  SUB Y
+//	  BRP A
+//	  LDA Zero
+//	  SUB Y
+//	  STA Y
+//	  LDA One
+//	  STA Sign
+//	A LDA X
+//	  ADD X
+//	  SUB X
+//	  BRP B
+//	  LDA Zero
+//	  SUB X
+//	  STA X
+//	  LDA One
+//	  SUB Sign
+//	  STA Sign
+//	B LDA X
+//	  BRA T
+//	N STA X
+//	  LDA Result
+//	  ADD One
+//	  STA Result
+//	  LDA X
+//	T SUB Y
+//	  BRP N
+//	  LDA Sign
+//	  BRZ end
+//	  LDA Zero
+//	  SUB Result
+//	  STA Result
+func (a *Arith) DivBody(x *lmc.Mailbox, y *lmc.Mailbox, result *lmc.Mailbox, byZero *lmc.Label, known Known, labels []*lmc.Label, end *lmc.Label) []lmc.Instruction {
+	positiveY, positiveX, next, test := labels[0], labels[1], labels[2], labels[3]
+
+	instrs := checkZero(y, byZero)
+	instrs = append(instrs, lmc.NewLoadInstr(a.Zero))
+	if known.negative(false) {
+		instrs = append(instrs, lmc.NewStoreInstr(a.Sign))
+	}
+
+	sign, from := a.divSign(x, y, false, known, positiveY, positiveX)
+	instrs = append(instrs, lmc.NewStoreInstr(result))
+	instrs = append(instrs, sign...)
+	instrs = append(instrs,
+		labelled(from, lmc.NewLoadInstr(x)),
+		lmc.NewBranchInstr(lmc.BRAlways, test),
+		lmc.NewLabelled(next, lmc.NewStoreInstr(x)),
+		lmc.NewLoadInstr(result),
+		lmc.NewAddInstr(a.One),
+		lmc.NewStoreInstr(result),
+		lmc.NewLoadInstr(x),
+		lmc.NewLabelled(test, lmc.NewSubInstr(y)),
+		lmc.NewBranchInstr(lmc.BRPositive, next),
+	)
+
+	if !known.negative(false) {
+		return instrs
+	}
+
+	return append(instrs, a.negate(result, end)...)
+}
+
+// RemLabels is the number of labels RemBody needs.
+const RemLabels = 3
+
+// RemBody gives the body of the routine for the remainder of X divided by Y
+// into Result, which has the sign of X as in C: the magnitude of Y is
+// subtracted from that of X until it would be negative, and what is left
+// negated if X is negative. Y is checked for 0, and the magnitudes of
+// operands known not to be negative left, as by DivBody.
+//
+//	  LDA Y        ; if byZero is not nil
+//	  BRZ byZero
+//	  LDA Zero
+//	  STA Sign
+//	  LDA Y
+//	  ADD Y
+//	  SUB Y
+//	  BRP A
+//	  LDA Zero
+//	  SUB Y
+//	  STA Y
+//	A LDA X
+//	  ADD X
+//	  SUB X
+//	  BRP B
+//	  LDA Zero
+//	  SUB X
+//	  STA X
+//	  LDA One
+//	  STA Sign
+//	B LDA X
+//	L SUB Y
+//	  BRP L
+//	  ADD Y
+//	  STA Result
+//	  LDA Sign
+//	  BRZ end
+//	  LDA Zero
+//	  SUB Result
+//	  STA Result
+func (a *Arith) RemBody(x *lmc.Mailbox, y *lmc.Mailbox, result *lmc.Mailbox, byZero *lmc.Label, known Known, labels []*lmc.Label, end *lmc.Label) []lmc.Instruction {
+	positiveY, positiveX, loop := labels[0], labels[1], labels[2]
+
+	instrs := checkZero(y, byZero)
+	if known.negative(true) {
+		instrs = append(instrs,
+			lmc.NewLoadInstr(a.Zero),
+			lmc.NewStoreInstr(a.Sign),
+		)
+	}
+
+	sign, from := a.divSign(x, y, true, known, positiveY, positiveX)
+	instrs = append(instrs, sign...)
+	instrs = append(instrs,
+		labelled(from, lmc.NewLoadInstr(x)),
+		lmc.NewLabelled(loop, lmc.NewSubInstr(y)),
+		lmc.NewBranchInstr(lmc.BRPositive, loop),
+		lmc.NewAddInstr(y),
+		lmc.NewStoreInstr(result),
+	)
+
+	if !known.negative(true) {
+		return instrs
+	}
+
+	return append(instrs, a.negate(result, end)...)
+}
+
+// FastMulLabels is the number of labels FastMulBody needs.
+const FastMulLabels = 1 + DoublingLabels
+
+// FastMulBody gives the body of the routine for X times Y into Result by
+// shift-and-add, in time growing with the number of digits of X rather than
+// with X: taking the magnitude of X as MulBody does, Scaled is doubled from 1
+// up to its top bit, and then its bits are read from the top, Result being
+// doubled for each and Y added for each that is set, see doubling. If X is
+// known not to be negative, it starts at A.
+//
+//	  LDA X
+//	  ADD X
+//	  SUB X
+//	  BRP A
+//	  LDA Zero
+//	  SUB X
+//	  STA X
+//	  LDA Zero
+//	  SUB Y
+//	  STA Y
+//	A LDA Zero
+//	  STA Result
+//	  STA Count
+//	  LDA One
+//	  STA Scaled
+//	  LDA X
+//	  BRZ end
+//	  ...          ; doubling, adding Y
+func (a *Arith) FastMulBody(x *lmc.Mailbox, y *lmc.Mailbox, result *lmc.Mailbox, known Known, labels []*lmc.Label, end *lmc.Label) []lmc.Instruction {
+	positive := labels[0]
+
+	instrs, from := a.mulSign(x, y, known, positive)
+	instrs = append(instrs,
+		labelled(from, lmc.NewLoadInstr(a.Zero)),
+		lmc.NewStoreInstr(result),
+		lmc.NewStoreInstr(a.Count),
+		lmc.NewLoadInstr(a.One),
+		lmc.NewStoreInstr(a.Scaled),
+		lmc.NewLoadInstr(x),
+		lmc.NewBranchInstr(lmc.BRZero, end),
+	)
+
+	return append(instrs, a.doubling(x, y, result, labels[1:])...)
+}
+
+// FastDivLabels is the number of labels FastDivBody needs.
+const FastDivLabels = 3 + DoublingLabels
+
+// FastDivBody gives the body of the routine for X divided by Y, or the
+// remainder if rem is true, into Result by binary long division, in time
+// growing with the number of digits of the quotient rather than with the
+// quotient: Scaled is doubled from Y up to X, and then the bits of the
+// quotient found from the top, see doubling. The remainder is X less the
+// quotient times Y, which is summed alongside, X being kept in Dividend.
+//
+// Signed, the magnitudes are taken first and the result negated after, as by
+// DivBody and RemBody, known ones being left; unsigned, each word is taken as
+// unsigned. Y is checked for 0 as by DivBody.
+//
+//	  LDA Y        ; if byZero is not nil
+//	  BRZ byZero
+//	  ...          ; if signed, as DivBody or RemBody, up to B
+//	B LDA Zero
+//	  STA Result
+//	  STA Count
+//	  LDA Y
+//	  STA Scaled
+//	  LDA X
+//	  STA Dividend ; if rem
+//	  SUB Y
+//	  BRP G
+//	  BRA F
+//	  ...          ; doubling from G, adding One, or Y if rem
+//	F LDA Dividend ; if rem
+//	  SUB Result
+//	  STA Result
+//	  LDA Sign     ; if signed
+//	  BRZ end
+//	  LDA Zero
+//	  SUB Result
+//	  STA Result
+func (a *Arith) FastDivBody(x *lmc.Mailbox, y *lmc.Mailbox, result *lmc.Mailbox, byZero *lmc.Label, signed bool, rem bool, known Known, labels []*lmc.Label, end *lmc.Label) []lmc.Instruction {
+	positiveY, positiveX, done := labels[0], labels[1], labels[2]
+	grow := labels[3]
+
+	instrs := checkZero(y, byZero)
+
+	negative := signed && known.negative(rem)
+	if negative {
+		instrs = append(instrs,
+			lmc.NewLoadInstr(a.Zero),
+			lmc.NewStoreInstr(a.Sign),
+		)
+	}
+
+	var from *lmc.Label
+	if signed {
+		var sign []lmc.Instruction
+		sign, from = a.divSign(x, y, rem, known, positiveY, positiveX)
+		instrs = append(instrs, sign...)
+	}
+
+	instrs = append(instrs,
+		labelled(from, lmc.NewLoadInstr(a.Zero)),
+		lmc.NewStoreInstr(result),
+		lmc.NewStoreInstr(a.Count),
+		lmc.NewLoadInstr(y),
+		lmc.NewStoreInstr(a.Scaled),
+		lmc.NewLoadInstr(x),
+	)
+
+	addend := a.One
+	var tail []lmc.Instruction
+	if rem {
+		instrs = append(instrs, lmc.NewStoreInstr(a.Dividend))
+
+		addend = y
+		tail = append(tail,
+			lmc.NewLoadInstr(a.Dividend),
+			lmc.NewSubInstr(result),
+			lmc.NewStoreInstr(result),
+		)
+	}
+
+	if negative {
+		tail = append(tail, a.negate(result, end)...)
+	}
+
+	after := end
+	if len(tail) > 0 {
+		after = done
+		tail[0] = lmc.NewLabelled(done, tail[0])
+	}
+
+	instrs = append(instrs,
+		lmc.NewSubInstr(y),
+		lmc.NewBranchInstr(lmc.BRPositive, grow),
+		lmc.NewBranchInstr(lmc.BRAlways, after),
+	)
+
+	instrs = append(instrs, a.doubling(x, addend, result, labels[3:])...)
+	return append(instrs, tail...)
+}
+
+// DoublingLabels is the number of labels doubling needs.
+const DoublingLabels = 5
+
+// doubling gives the loops shared by the doubling routines, which start at the
+// first, labelled G, with Result and Count 0 and Scaled at most X. Scaled is
+// doubled, counting in Count, while twice it is at most X; as X less Scaled is
+// then compared with Scaled, nothing is ever more than X, so nothing
+// overflows whatever the modulus. Then the bits of X over Scaled are read from
+// the top: Result is doubled for each, and addend added for each that is set.
+// The first is always set, so Scaled is subtracted from X; for each of the
+// Count after, X is below Scaled, so its next bit is set if twice X is at
+// least Scaled, i.e., if X is at least Diff, Scaled less X, and then X becomes
+// X less Diff, and otherwise twice X: again below Scaled.
+//
+//	G LDA X
+//	  SUB Scaled
+//	  SUB Scaled
+//	  BRP M
+//	  LDA X
+//	  SUB Scaled
+//	  STA X
+//	  LDA addend
+//	  STA Result
+//	  BRA C
+//	M LDA Scaled
+//	  ADD Scaled
+//	  STA Scaled
+//	  LDA Count
+//	  ADD One
+//	  STA Count
+//	  BRA G
+//	B LDA Scaled
+//	  SUB X
+//	  STA Diff
+//	  LDA Result
+//	  ADD Result
+//	  STA Result
+//	  LDA X
+//	  SUB Diff
+//	  BRP O
+//	  LDA X
+//	  ADD X
+//	  STA X
+//	  BRA C
+//	O STA X
+//	  LDA Result
+//	  ADD addend
+//	  STA Result
+//	C LDA Count
+//	  SUB One
+//	  STA Count
+//	  BRP B
+func (a *Arith) doubling(x *lmc.Mailbox, addend *lmc.Mailbox, result *lmc.Mailbox, labels []*lmc.Label) []lmc.Instruction {
+	grow, double, bit, one, count := labels[0], labels[1], labels[2], labels[3], labels[4]
+
+	return []lmc.Instruction{
+		lmc.NewLabelled(grow, lmc.NewLoadInstr(x)),
+		lmc.NewSubInstr(a.Scaled),
+		lmc.NewSubInstr(a.Scaled),
+		lmc.NewBranchInstr(lmc.BRPositive, double),
+		lmc.NewLoadInstr(x),
+		lmc.NewSubInstr(a.Scaled),
+		lmc.NewStoreInstr(x),
+		lmc.NewLoadInstr(addend),
+		lmc.NewStoreInstr(result),
+		lmc.NewBranchInstr(lmc.BRAlways, count),
+		lmc.NewLabelled(double, lmc.NewLoadInstr(a.Scaled)),
+		lmc.NewAddInstr(a.Scaled),
+		lmc.NewStoreInstr(a.Scaled),
+		lmc.NewLoadInstr(a.Count),
+		lmc.NewAddInstr(a.One),
+		lmc.NewStoreInstr(a.Count),
+		lmc.NewBranchInstr(lmc.BRAlways, grow),
+		lmc.NewLabelled(bit, lmc.NewLoadInstr(a.Scaled)),
+		lmc.NewSubInstr(x),
+		lmc.NewStoreInstr(a.Diff),
+		lmc.NewLoadInstr(result),
+		lmc.NewAddInstr(result),
+		lmc.NewStoreInstr(result),
+		lmc.NewLoadInstr(x),
+		lmc.NewSubInstr(a.Diff),
+		lmc.NewBranchInstr(lmc.BRPositive, one),
+		lmc.NewLoadInstr(x),
+		lmc.NewAddInstr(x),
+		lmc.NewStoreInstr(x),
+		lmc.NewBranchInstr(lmc.BRAlways, count),
+		lmc.NewLabelled(one, lmc.NewStoreInstr(x)),
+		lmc.NewLoadInstr(result),
+		lmc.NewAddInstr(addend),
+		lmc.NewStoreInstr(result),
+		lmc.NewLabelled(count, lmc.NewLoadInstr(a.Count)),
+		lmc.NewSubInstr(a.One),
+		lmc.NewStoreInstr(a.Count),
+		lmc.NewBranchInstr(lmc.BRPositive, bit),
+	}
+}
+
+// negative returns true if the result of a division, or a remainder if rem is
+// true, may be negative, so is negated by Sign.
+func (k Known) negative(rem bool) bool {
+	return !k.X || (!rem && !k.Y)
+}
+
+// mulSign gives the instructions taking the magnitude of X for a
+// multiplication, negating Y too if X was negative, and the label of the
+// instruction after them, which they branch to if X is not negative. If X is
+// known not to be negative, there are none.
+func (a *Arith) mulSign(x *lmc.Mailbox, y *lmc.Mailbox, known Known, positive *lmc.Label) ([]lmc.Instruction, *lmc.Label) {
+	if known.X {
+		return nil, nil
+	}
+
+	instrs := a.abs(nil, x, positive)
+	instrs = append(instrs,
+		lmc.NewLoadInstr(a.Zero),
+		lmc.NewSubInstr(y),
+		lmc.NewStoreInstr(y),
+	)
+
+	return instrs, positive
+}
+
+// divSign gives the instructions taking the magnitudes of Y and then X for a
+// division, or a remainder if rem is true, setting Sign, which is first 0, if
+// the result is to be negated; and the label of the instruction after them, if
+// any branch there. Those of operands known not to be negative are left out.
+func (a *Arith) divSign(x *lmc.Mailbox, y *lmc.Mailbox, rem bool, known Known, positiveY *lmc.Label, positiveX *lmc.Label) ([]lmc.Instruction, *lmc.Label) {
+	var instrs []lmc.Instruction
+	var from *lmc.Label
+
+	if !known.Y {
+		instrs = a.abs(nil, y, positiveY)
+		if !rem {
+			instrs = append(instrs,
+				lmc.NewLoadInstr(a.One),
+				lmc.NewStoreInstr(a.Sign),
+			)
+		}
+
+		from = positiveY
+	}
+
+	if known.X {
+		return instrs, from
+	}
+
+	instrs = append(instrs, a.abs(from, x, positiveX)...)
+	if !rem {
+		instrs = append(instrs, lmc.NewLoadInstr(a.One), lmc.NewSubInstr(a.Sign))
+	} else {
+		instrs = append(instrs, lmc.NewLoadInstr(a.One))
+	}
+
+	return append(instrs, lmc.NewStoreInstr(a.Sign)), positiveX
+}
+
+// abs gives the instructions negating a mailbox if it is negative, the first
+// labelled by from unless it is nil. If it is not negative they branch to
+// positive; otherwise they fall through once it is negated.
+func (a *Arith) abs(from *lmc.Label, box *lmc.Mailbox, positive *lmc.Label) []lmc.Instruction {
+	return []lmc.Instruction{
+		labelled(from, lmc.NewLoadInstr(box)),
+		lmc.NewAddInstr(box),
+		lmc.NewSubInstr(box),
+		lmc.NewBranchInstr(lmc.BRPositive, positive),
+		lmc.NewLoadInstr(a.Zero),
+		lmc.NewSubInstr(box),
+		lmc.NewStoreInstr(box),
+	}
+}
+
+// labelled gives an instruction labelled by a label, unless it is nil.
+func labelled(label *lmc.Label, instr lmc.Instruction) lmc.Instruction {
+	if label == nil {
+		return instr
+	}
+
+	return lmc.NewLabelled(label, instr)
+}
+
+// checkZero gives the branch to byZero if a mailbox is 0, or nothing if byZero
+// is nil.
+func checkZero(box *lmc.Mailbox, byZero *lmc.Label) []lmc.Instruction {
+	if byZero == nil {
+		return nil
+	}
+
+	return []lmc.Instruction{
+		lmc.NewLoadInstr(box),
+		lmc.NewBranchInstr(lmc.BRZero, byZero),
+	}
+}
+
+// negate gives the instructions negating Result if Sign is set, or otherwise
+// branching to end.
+func (a *Arith) negate(result *lmc.Mailbox, end *lmc.Label) []lmc.Instruction {
+	return []lmc.Instruction{
+		lmc.NewLoadInstr(a.Sign),
+		lmc.NewBranchInstr(lmc.BRZero, end),
+		lmc.NewLoadInstr(a.Zero),
+		lmc.NewSubInstr(result),
+		lmc.NewStoreInstr(result),
+	}
+}
+
+// ---------- DivisionByZero ----------
+
+// DivisionByZero is the code branched to when dividing by zero, from Label:
+// it outputs Signal, unless it is nil, and halts.
+type DivisionByZero struct {
+	Label  *lmc.Label
+	Signal *lmc.Mailbox
+}
+
+// Instructions gives the code, which is compiled after the entry function.
+func (d *DivisionByZero) Instructions() []lmc.Instruction {
+	if d.Signal == nil {
+		return []lmc.Instruction{lmc.NewLabelled(d.Label, lmc.NewHaltInstr())}
+	}
+
+	return []lmc.Instruction{
+		lmc.NewLabelled(d.Label, lmc.NewLoadInstr(d.Signal)),
+		lmc.NewOutputInstr(),
+		lmc.NewHaltInstr(),
+	}
+}
@@ -0,0 +1,103 @@
+package instructions
+
+import (
+	"github.com/clr1107/lmc-llvm-target/lmc"
+	"github.com/llir/llvm/ir"
+)
+
+// ---------- Routine ----------
+
+// Routine is code that instructions need too much of to repeat freely, e.g.,
+// the loop of a bitwise and: a body computing Result from Params, which it may
+// change. A shared routine's body is compiled once, after the entry function,
+// and is called like a subroutine (see WCall), returning through the slot
+// Slot, whose mailbox is Return. Otherwise every use has its own copy.
+type Routine struct {
+	Params []*lmc.Mailbox
+	Result *lmc.Mailbox
+	Shared bool
+	Entry  *lmc.Label
+	Slot   *lmc.Labelled
+	Return *lmc.Mailbox
+}
+
+// Instructions gives the instructions of a shared routine: its body, from
+// Entry, then its return slot. The body must end by falling through to the
+// slot's label.
+func (r *Routine) Instructions(body []lmc.Instruction) []lmc.Instruction {
+	instrs := append([]lmc.Instruction{}, body...)
+	instrs[0] = lmc.NewLabelled(r.Entry, instrs[0])
+
+	return append(instrs, r.Slot)
+}
+
+// ---------- WRoutineCall ----------
+
+// WRoutineCall is a use of a routine: the arguments are copied to its
+// parameters, then, if it is shared, it is called:
+//
+//	    LDA Args[k]  ; for each parameter
+//	    STA Params[k]
+//	    LDA Word     ; BRA Ret
+//	    STA Return
+//	    BRA Entry
+//	Ret ...          ; After
+//
+// Otherwise Body, its own copy of the routine's body, is compiled in place of
+// the call, with Ret labelling what comes after. After computes Dst from the
+// routine's result.
+type WRoutineCall struct {
+	LLInstructionBase
+	Routine   *Routine
+	Args      []*lmc.Mailbox
+	Body      []lmc.Instruction
+	Word      *lmc.Mailbox
+	Ret       *lmc.Label
+	After     []lmc.Instruction
+	memoryOps []*lmc.MemoryOp
+}
+
+func NewWRoutineCall(instr ir.Instruction, routine *Routine, args []*lmc.Mailbox, ret *lmc.Label, after []lmc.Instruction, ops []*lmc.MemoryOp) *WRoutineCall {
+	return &WRoutineCall{
+		LLInstructionBase: LLInstructionBase{
+			base: []ir.Instruction{instr},
+		},
+		Routine:   routine,
+		Args:      args,
+		Ret:       ret,
+		After:     after,
+		memoryOps: ops,
+	}
+}
+
+func (w *WRoutineCall) LMCInstructions() []lmc.Instruction {
+	var instrs []lmc.Instruction
+
+	for k, param := range w.Routine.Params {
+		instrs = append(instrs, lmc.NewLoadInstr(w.Args[k]), lmc.NewStoreInstr(param))
+	}
+
+	if w.Routine.Shared {
+		instrs = append(instrs,
+			lmc.NewLoadInstr(w.Word),
+			lmc.NewStoreInstr(w.Routine.Return),
+			lmc.NewBranchInstr(lmc.BRAlways, w.Routine.Entry),
+		)
+	} else {
+		instrs = append(instrs, w.Body...)
+	}
+
+	after := append([]lmc.Instruction{}, w.After...)
+	after[0] = lmc.NewLabelled(w.Ret, after[0])
+
+	return append(instrs, after...)
+}
+
+func (w *WRoutineCall) LMCOps() []*lmc.MemoryOp {
+	return w.memoryOps
+}
+
+// AddMemoryOps adds memory operations, e.g., of the destination.
+func (w *WRoutineCall) AddMemoryOps(ops ...*lmc.MemoryOp) {
+	w.memoryOps = append(w.memoryOps, ops...)
+}
@@ -18,11 +18,67 @@ typedef _type bool;
 extern void __lmc_option__(const char *, number);
 #define __lmc_option__(k, v) (assert_int_constant((v)), __lmc_option__((k), (v)))
 
-#define O_NONE      0
-#define O_THRASHING 1
-#define O_CLEAN     2
-#define O_BPROP     4
-#define O_ALL       7
+#define O_NONE       0
+#define O_THRASHING  1
+#define O_CLEAN      2
+#define O_BPROP      4
+#define O_CHAINING   8
+#define O_UNROLL     16
+#define O_DEAD_STORE 64
+#define O_COALESCE   128
+#define O_ALL        223
+
+// Values for the "MAILBOXES" option; any other positive number may be used
+#define M_CLASSIC   100
+#define M_UNLIMITED 0
+
+// Default values for the "CHAIN_LENGTH" and "UNROLL_LENGTH" options: the most
+// `ADD`s a multiplication by a constant is replaced with, and the most
+// instructions a loop run a constant number of times is unrolled into
+#define CHAIN_LENGTH_DEFAULT  10
+#define UNROLL_LENGTH_DEFAULT 30
+
+// Values for the "CALLS" option: whether every call to a function is inlined,
+// or each function called is compiled once as a subroutine, which is smaller
+// if it is called often but slower
+#define C_INLINE     0
+#define C_SUBROUTINE 1
+
+// The "STACK" option is the number of mailboxes of the software stack, which
+// recursive subroutines save their locals and return addresses on; without
+// one, recursion is an error. A program that overflows the stack outputs
+// STACK_OVERFLOW and halts
+#define STACK_NONE     0
+#define STACK_OVERFLOW (-500)
+
+// Values for the "WRAP" option: whether narrowing an integer to fewer bits than
+// a word holds, e.g., to a char, keeps its value, with a warning, or wraps it
+// around exactly, which needs a loop
+#define WRAP_NONE  0
+#define WRAP_EXACT 1
+
+// Values for the "DIVZERO" option: whether dividing, or taking a remainder, by
+// zero halts, outputs DIVZERO_OUTPUT and halts, or is not checked for, which
+// is smaller but then never ends
+#define DIVZERO_HALT        0
+#define DIVZERO_SENTINEL    1
+#define DIVZERO_UNSPECIFIED 2
+#define DIVZERO_OUTPUT      (-499)
+
+// Values for the "MULDIV" option: whether multiplication, division and
+// remainder are by repeated addition or subtraction only where the operands are
+// known to be small enough, always, which is smallest and the default, or
+// never, doubling instead, which takes time growing with the number of digits
+#define MULDIV_AUTO   0
+#define MULDIV_REPEAT 1
+#define MULDIV_FAST   2
+
+// Values for the "ARITH" option: whether every multiplication, division and
+// remainder has its own copy of its loop, or each routine used more than once
+// is compiled once and called, which is smaller but a little slower and the
+// default
+#define ARITH_INLINE 0
+#define ARITH_SHARED 1
 
 // Set the temporary mailbox to a value
 #define _mem_temp_set(v)                                        \
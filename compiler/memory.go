@@ -1,13 +1,381 @@
 package compiler
 
 import (
+	"fmt"
+	"github.com/clr1107/lmc-llvm-target/compiler/errors"
 	"github.com/clr1107/lmc-llvm-target/compiler/instructions"
 	"github.com/clr1107/lmc-llvm-target/lmc"
 	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
+// globalName matches names that are legal identifiers in LMC.
+var globalName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// globalIdentifier gives the identifier for the mailbox of a global: its name,
+// if that is legal in LMC and cannot clash with a generated identifier, i.e.,
+// it is neither all capitals nor prefixed like one (e.g., 'c_' for constants);
+// otherwise a generated one, prefixed with 'g_'.
+func globalIdentifier(name string, k int) string {
+	legal := globalName.MatchString(name) && !lmc.IsMnemonic(name) && strings.ToUpper(name) != name &&
+		!(len(name) > 1 && name[1] == '_')
+
+	if legal {
+		return name
+	}
+
+	return "g_" + strconv.Itoa(k)
+}
+
+// typeSize gives the number of mailboxes a value of an LL type takes: one for an
+// integer, and one per element of an array, nested arrays being flattened.
+func typeSize(t types.Type) (int, bool) {
+	if types.IsInt(t) {
+		return 1, true
+	}
+
+	if a, ok := t.(*types.ArrayType); ok {
+		if size, ok := typeSize(a.ElemType); ok {
+			return int(a.Len) * size, true
+		}
+	}
+
+	return 0, false
+}
+
+// constantValues gives the values of the mailboxes of a constant of an LL type,
+// one for an integer and one per element of an array, as does *typeSize.
+func constantValues(c constant.Constant, t types.Type) ([]lmc.Value, bool) {
+	switch x := c.(type) {
+	case *constant.Int:
+		return []lmc.Value{lmc.Value(x.X.Int64())}, true
+	case *constant.ZeroInitializer:
+		size, ok := typeSize(t)
+		return make([]lmc.Value, size), ok
+	case *constant.CharArray:
+		values := make([]lmc.Value, len(x.X))
+		for k, b := range x.X {
+			values[k] = lmc.Value(b)
+		}
+
+		return values, true
+	case *constant.Array:
+		var values []lmc.Value
+
+		for _, e := range x.Elems {
+			v, ok := constantValues(e, x.Typ.ElemType)
+			if !ok {
+				return nil, false
+			}
+
+			values = append(values, v...)
+		}
+
+		return values, true
+	default:
+		return nil, false
+	}
+}
+
+// globalBox gives the mailbox of an integer or array global, the pointee, like
+// that of an alloca; creating it the first time, holding the global's initial
+// value. An array's mailboxes are consecutive, and the mailbox given is the
+// first.
+func (compiler *Compiler) globalBox(g *ir.Global) (*lmc.MemoryOp, error) {
+	if box, ok := compiler.globals[g]; ok {
+		return lmc.NewMemoryOpBox1(box, false), nil
+	}
+
+	if _, ok := typeSize(g.ContentType); !ok {
+		return nil, errors.E_Unsupported(fmt.Sprintf("global %s of type %s", g.Ident(), g.ContentType.LLString()), nil)
+	}
+
+	if g.Init == nil {
+		return nil, errors.E_Unsupported(fmt.Sprintf("global %s without an initialiser", g.Ident()), nil)
+	}
+
+	values, ok := constantValues(g.Init, g.ContentType)
+	if !ok {
+		return nil, errors.E_Unsupported(fmt.Sprintf("global %s initialised to `%s`", g.Ident(), g.Init.Ident()), nil)
+	}
+
+	identifier := globalIdentifier(g.Name(), len(compiler.globals))
+
+	if types.IsInt(g.ContentType) {
+		op := compiler.Prog.Memory.NewMailbox(-1, identifier)
+		op.Boxes[0].Value = values[0]
+
+		compiler.globals[g] = op.Boxes[0].Box
+		return op, nil
+	}
+
+	box, err := compiler.Prog.NewArray(-1, identifier, values)
+	if err != nil {
+		return nil, errors.E_LMC("creating global array", err)
+	}
+
+	compiler.globals[g] = box
+	return lmc.NewMemoryOpBox1(box, false), nil
+}
+
+// element is an element of an array: the array's mailbox, the first of its
+// mailboxes, and the mailbox holding the element's index; nil for the first
+// element, which is accessed directly.
+type element struct {
+	array *lmc.Mailbox
+	index *lmc.Mailbox
+}
+
+// element gives the element of an array an LL pointer points to, if it is a
+// getelementptr, or nil if it is not.
+func (compiler *Compiler) element(ptr value.Value) (*element, error) {
+	switch x := ptr.(type) {
+	case *ir.InstGetElementPtr:
+		if e, ok := compiler.elements[x]; ok {
+			return e, nil
+		}
+
+		return nil, errors.E_Err(fmt.Sprintf("getelementptr `%s` used before it is compiled", x.LLString()), nil)
+	case *constant.ExprGetElementPtr:
+		indices := make([]value.Value, len(x.Indices))
+		for k, index := range x.Indices {
+			if i, ok := index.(*constant.Index); ok {
+				index = i.Constant
+			}
+
+			indices[k] = index
+		}
+
+		array, terms, offset, err := compiler.elementIndex(x.ElemType, x.Src, indices)
+		if err != nil {
+			return nil, err
+		} else if len(terms) > 0 {
+			return nil, errors.E_Unsupported(fmt.Sprintf("getelementptr `%s` with a variable index", x.Ident()), nil)
+		}
+
+		return compiler.constantElement(array, offset)
+	default:
+		return nil, nil
+	}
+}
+
+// elementRoot gives the array an LL pointer to an element points into, by its
+// getelementptrs, or the pointer itself if it is not an element.
+func elementRoot(ptr value.Value) value.Value {
+	for {
+		switch x := ptr.(type) {
+		case *ir.InstGetElementPtr:
+			ptr = x.Src
+		case *constant.ExprGetElementPtr:
+			ptr = x.Src
+		default:
+			return ptr
+		}
+	}
+}
+
+// constantElement gives the element of an array at a constant index.
+func (compiler *Compiler) constantElement(array *lmc.Mailbox, index int64) (*element, error) {
+	e := &element{array: array}
+
+	if index != 0 {
+		var err error
+		if e.index, err = compiler.Prog.Constant(lmc.Value(index)); err != nil {
+			return nil, errors.E_LMC("creating element index", err)
+		}
+	}
+
+	return e, nil
+}
+
+// elementIndex gives the array a getelementptr points into, and the index of
+// the element it points to: the mailboxes holding its variable parts, each
+// repeated as many times as the size of what it indexes, so they are summed,
+// and its constant part. The source is an array alloca or global, or another
+// element.
+func (compiler *Compiler) elementIndex(elemType types.Type, src value.Value, indices []value.Value) (*lmc.Mailbox, []*lmc.Mailbox, int64, error) {
+	var array *lmc.Mailbox
+	var terms []*lmc.Mailbox
+	var offset int64
+
+	e, err := compiler.element(src)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	switch x := src.(type) {
+	case *ir.InstAlloca:
+		if !types.IsInt(x.ElemType) {
+			array = compiler.Prog.Memory.GetMailboxAddress(lmc.Address(x.ID()))
+		}
+	case *ir.Global:
+		if !types.IsInt(x.ContentType) {
+			op, err := compiler.globalBox(x)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+
+			array = op.Boxes[0].Box
+		}
+	}
+
+	if e != nil {
+		array = e.array
+		if e.index != nil {
+			terms = append(terms, e.index)
+		}
+	} else if array == nil {
+		return nil, nil, 0, errors.E_Unsupported(fmt.Sprintf("getelementptr into `%s`, which is not an array", src.Ident()), nil)
+	}
+
+	t := elemType
+
+	for k, index := range indices {
+		if k > 0 {
+			a, ok := t.(*types.ArrayType)
+			if !ok {
+				return nil, nil, 0, errors.E_Unsupported(fmt.Sprintf("getelementptr into %s", t.LLString()), nil)
+			}
+
+			t = a.ElemType
+		}
+
+		size, ok := typeSize(t)
+		if !ok {
+			return nil, nil, 0, errors.E_Unsupported(fmt.Sprintf("getelementptr into %s", t.LLString()), nil)
+		}
+
+		if c, ok := index.(*constant.Int); ok {
+			offset += c.X.Int64() * int64(size)
+			continue
+		}
+
+		op, err := compiler.GetMailboxFromLL(index)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		for i := 0; i < size; i++ {
+			terms = append(terms, op.Boxes[0].Box)
+		}
+	}
+
+	return array, terms, offset, nil
+}
+
+// WrapLLInstGetElementPtr computes the index of the element a getelementptr
+// points to, in its own mailbox; unless it is constant, when it compiles to
+// nothing. Loads and stores of the element then access it through a slot, see
+// *instructions.Indexed.
+func (compiler *Compiler) WrapLLInstGetElementPtr(instr *ir.InstGetElementPtr) *Compilation {
+	array, terms, offset, err := compiler.elementIndex(instr.ElemType, instr.Src, instr.Indices)
+	if err != nil {
+		return &Compilation{Err: err}
+	}
+
+	if len(terms) == 0 {
+		e, err := compiler.constantElement(array, offset)
+		if err != nil {
+			return &Compilation{Err: err}
+		}
+
+		compiler.elements[instr] = e
+		return &Compilation{Wrapped: instructions.NewWInstGetElementPtr(instr, nil, nil, nil)}
+	}
+
+	if offset != 0 {
+		c, err := compiler.Prog.Constant(lmc.Value(offset))
+		if err != nil {
+			return &Compilation{Err: errors.E_LMC("creating element offset", err)}
+		}
+
+		terms = append(terms, c)
+	}
+
+	var ops []*lmc.MemoryOp
+
+	addr := lmc.Address(instr.ID())
+	dst := compiler.Prog.Memory.GetMailboxAddress(addr)
+
+	if dst == nil {
+		op := compiler.Prog.Memory.NewMailbox(addr, "")
+		dst = op.Boxes[0].Box
+
+		ops = append(ops, op)
+	}
+
+	compiler.elements[instr] = &element{array: array, index: dst}
+	return &Compilation{Wrapped: instructions.NewWInstGetElementPtr(instr, terms, dst, ops)}
+}
+
+// newSlot creates a slot, and its mailbox, see *instructions.Slot.
+func (compiler *Compiler) newSlot() (*instructions.Slot, error) {
+	slot := &instructions.Slot{}
+	var err error
+
+	if slot.Label, err = compiler.Prog.NewLabel(""); err != nil {
+		return nil, errors.E_LMC("creating slot label", err)
+	}
+
+	if slot.Box, err = compiler.Prog.LabelMailbox(slot.Label); err != nil {
+		return nil, errors.E_LMC("creating slot", err)
+	}
+
+	return slot, nil
+}
+
+// indexed gives the access of an element, a load or a store, through a new
+// slot; or nil for the first element, which is accessed directly.
+func (compiler *Compiler) indexed(e *element, store bool) (*instructions.Indexed, error) {
+	if e.index == nil {
+		return nil, nil
+	}
+
+	var instr lmc.Instruction = lmc.NewLoadInstr(e.array)
+	if store {
+		instr = lmc.NewStoreInstr(e.array)
+	}
+
+	word, err := compiler.Prog.Word(instr)
+	if err != nil {
+		return nil, errors.E_LMC("creating element access word", err)
+	}
+
+	slot, err := compiler.newSlot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &instructions.Indexed{Word: word, Index: e.index, Slot: slot}, nil
+}
+
 func (compiler *Compiler) WrapLLInstAlloca(instr *ir.InstAlloca) *Compilation {
 	addr := lmc.Address(instr.ID())
+
+	// may have been created already, to be saved over a recursive call
+	if box := compiler.Prog.Memory.GetMailboxAddress(addr); box != nil {
+		return &Compilation{Wrapped: instructions.NewWInstAlloca(instr, box, nil)}
+	}
+
+	if !types.IsInt(instr.ElemType) {
+		size, ok := typeSize(instr.ElemType)
+		if !ok {
+			return &Compilation{Err: errors.E_InvalidLLTypes(nil, instr.ElemType.LLString())}
+		}
+
+		box, err := compiler.Prog.NewArray(addr, "", make([]lmc.Value, size))
+		if err != nil {
+			return &Compilation{Err: errors.E_LMC("creating array", err)}
+		}
+
+		return &Compilation{Wrapped: instructions.NewWInstAlloca(instr, box, nil)}
+	}
+
 	op := compiler.Prog.Memory.NewMailbox(addr, "")
 
 	return &Compilation{Wrapped: instructions.NewWInstAlloca(instr, op.Boxes[0].Box, []*lmc.MemoryOp{op})}
@@ -20,9 +388,20 @@ func (compiler *Compiler) WrapLLInstLoad(instr *ir.InstLoad) *Compilation {
 	var op *lmc.MemoryOp
 	var err error
 
-	op, err = compiler.GetMailboxFromLL(instr.Src)
+	e, err := compiler.element(instr.Src)
 	if err != nil {
 		return &Compilation{Err: err}
+	}
+
+	var indexed *instructions.Indexed
+
+	if e != nil {
+		srcBox = e.array
+		if indexed, err = compiler.indexed(e, false); err != nil {
+			return &Compilation{Err: err}
+		}
+	} else if op, err = compiler.GetMailboxFromLL(instr.Src); err != nil {
+		return &Compilation{Err: err}
 	} else {
 		srcBox = op.Boxes[0].Box
 		ops = append(ops, op)
@@ -38,7 +417,10 @@ func (compiler *Compiler) WrapLLInstLoad(instr *ir.InstLoad) *Compilation {
 		ops = append(ops, op)
 	}
 
-	return &Compilation{Wrapped: instructions.NewWInstLoad(instr, srcBox, dstBox, ops)}
+	w := instructions.NewWInstLoad(instr, srcBox, dstBox, ops)
+	w.Indexed = indexed
+
+	return &Compilation{Wrapped: w}
 }
 
 func (compiler *Compiler) WrapLLInstStore(instr *ir.InstStore) *Compilation {
@@ -56,13 +438,27 @@ func (compiler *Compiler) WrapLLInstStore(instr *ir.InstStore) *Compilation {
 		ops = append(ops, op)
 	}
 
-	op, err = compiler.GetMailboxFromLL(instr.Dst)
+	e, err := compiler.element(instr.Dst)
 	if err != nil {
 		return &Compilation{Err: err}
+	}
+
+	var indexed *instructions.Indexed
+
+	if e != nil {
+		dstBox = e.array
+		if indexed, err = compiler.indexed(e, true); err != nil {
+			return &Compilation{Err: err}
+		}
+	} else if op, err = compiler.GetMailboxFromLL(instr.Dst); err != nil {
+		return &Compilation{Err: err}
 	} else {
 		dstBox = op.Boxes[0].Box
 		ops = append(ops, op)
 	}
 
-	return &Compilation{Wrapped: instructions.NewWInstStore(instr, srcBox, dstBox, ops)}
+	w := instructions.NewWInstStore(instr, srcBox, dstBox, ops)
+	w.Indexed = indexed
+
+	return &Compilation{Wrapped: w}
 }
@@ -0,0 +1,34 @@
+; Outputs the product, quotient and remainder of the first input by the
+; second, then of their sum by the second, so each routine is used twice.
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  %2 = alloca i32, align 4
+  call void @input(i32* %1)
+  call void @input(i32* %2)
+  %3 = load i32, i32* %1, align 4
+  %4 = load i32, i32* %2, align 4
+  %5 = mul nsw i32 %3, %4
+  store i32 %5, i32* %1, align 4
+  call void @output(i32* %1)
+  %6 = sdiv i32 %3, %4
+  store i32 %6, i32* %1, align 4
+  call void @output(i32* %1)
+  %7 = srem i32 %3, %4
+  store i32 %7, i32* %1, align 4
+  call void @output(i32* %1)
+  %8 = add nsw i32 %3, %4
+  %9 = mul nsw i32 %8, %4
+  store i32 %9, i32* %1, align 4
+  call void @output(i32* %1)
+  %10 = sdiv i32 %8, %4
+  store i32 %10, i32* %1, align 4
+  call void @output(i32* %1)
+  %11 = srem i32 %8, %4
+  store i32 %11, i32* %1, align 4
+  call void @output(i32* %1)
+  ret void
+}
@@ -0,0 +1,43 @@
+; Reads four inputs into a local array and outputs them in reverse, then
+; outputs the row of a global table given by the next input, and its corner.
+
+@grid = global [2 x [3 x i32]] [[3 x i32] [i32 1, i32 2, i32 3], [3 x i32] [i32 4, i32 5, i32 6]], align 4
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define void @_lmc() {
+  %1 = alloca [4 x i32], align 4
+  %2 = alloca i32, align 4
+  br label %3
+
+3:
+  %4 = phi i32 [ 0, %0 ], [ %7, %3 ]
+  %5 = getelementptr inbounds [4 x i32], [4 x i32]* %1, i32 0, i32 %4
+  call void @input(i32* %5)
+  %6 = icmp ne i32 %4, 3
+  %7 = add nsw i32 %4, 1
+  br i1 %6, label %3, label %8
+
+8:
+  %9 = phi i32 [ 3, %3 ], [ %12, %8 ]
+  %10 = getelementptr inbounds [4 x i32], [4 x i32]* %1, i32 0, i32 %9
+  call void @output(i32* %10)
+  %11 = icmp ne i32 %9, 0
+  %12 = sub nsw i32 %9, 1
+  br i1 %11, label %8, label %13
+
+13:
+  call void @input(i32* %2)
+  %14 = load i32, i32* %2, align 4
+  %15 = getelementptr inbounds [2 x [3 x i32]], [2 x [3 x i32]]* @grid, i32 0, i32 %14, i32 0
+  %16 = load i32, i32* %15, align 4
+  store i32 %16, i32* %2, align 4
+  call void @output(i32* %2)
+  %17 = getelementptr inbounds [2 x [3 x i32]], [2 x [3 x i32]]* @grid, i32 0, i32 %14, i32 2
+  call void @output(i32* %17)
+  store i32 0, i32* %17, align 4
+  call void @output(i32* %17)
+  call void @output(i32* getelementptr inbounds ([2 x [3 x i32]], [2 x [3 x i32]]* @grid, i32 0, i32 1, i32 2))
+  ret void
+}
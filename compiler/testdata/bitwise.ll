@@ -0,0 +1,39 @@
+; Outputs the and, or and xor of the first two inputs, the complement of the
+; first, and it shifted left by two, right by the third input, and right by one.
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  %2 = alloca i32, align 4
+  %3 = alloca i32, align 4
+  call void @input(i32* %1)
+  call void @input(i32* %2)
+  call void @input(i32* %3)
+  %4 = load i32, i32* %1, align 4
+  %5 = load i32, i32* %2, align 4
+  %6 = load i32, i32* %3, align 4
+  %7 = and i32 %4, %5
+  store i32 %7, i32* %3, align 4
+  call void @output(i32* %3)
+  %8 = or i32 %4, %5
+  store i32 %8, i32* %3, align 4
+  call void @output(i32* %3)
+  %9 = xor i32 %4, %5
+  store i32 %9, i32* %3, align 4
+  call void @output(i32* %3)
+  %10 = xor i32 %4, -1
+  store i32 %10, i32* %3, align 4
+  call void @output(i32* %3)
+  %11 = shl i32 %4, 2
+  store i32 %11, i32* %3, align 4
+  call void @output(i32* %3)
+  %12 = ashr i32 %4, %6
+  store i32 %12, i32* %3, align 4
+  call void @output(i32* %3)
+  %13 = lshr i32 %4, 1
+  store i32 %13, i32* %3, align 4
+  call void @output(i32* %3)
+  ret void
+}
@@ -0,0 +1,26 @@
+; Outputs whether the input is non-zero, then the input.
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  %2 = alloca i32, align 4
+  call void @input(i32* %1)
+  %3 = load i32, i32* %1, align 4
+  %4 = icmp ne i32 %3, 0
+  br i1 %4, label %5, label %6
+
+5:
+  store i32 1, i32* %2, align 4
+  br label %7
+
+6:
+  store i32 0, i32* %2, align 4
+  br label %7
+
+7:
+  call void @output(i32* %2)
+  call void @output(i32* %1)
+  ret void
+}
@@ -0,0 +1,30 @@
+; Outputs the input truncated to a char, then sign- and zero-extended; whether
+; it is negative, zero- and sign-extended; and its low bit.
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  call void @input(i32* %1)
+  %2 = load i32, i32* %1, align 4
+  %3 = trunc i32 %2 to i8
+  %4 = sext i8 %3 to i32
+  store i32 %4, i32* %1, align 4
+  call void @output(i32* %1)
+  %5 = zext i8 %3 to i32
+  store i32 %5, i32* %1, align 4
+  call void @output(i32* %1)
+  %6 = icmp slt i32 %2, 0
+  %7 = zext i1 %6 to i32
+  store i32 %7, i32* %1, align 4
+  call void @output(i32* %1)
+  %8 = sext i1 %6 to i32
+  store i32 %8, i32* %1, align 4
+  call void @output(i32* %1)
+  %9 = trunc i32 %2 to i1
+  %10 = zext i1 %9 to i32
+  store i32 %10, i32* %1, align 4
+  call void @output(i32* %1)
+  ret void
+}
@@ -0,0 +1,25 @@
+; Outputs the input, counting down to 1; it must not be negative.
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  call void @input(i32* %1)
+  br label %2
+
+2:
+  %3 = load i32, i32* %1, align 4
+  %4 = icmp ne i32 %3, 0
+  br i1 %4, label %5, label %8
+
+5:
+  call void @output(i32* %1)
+  %6 = load i32, i32* %1, align 4
+  %7 = sub nsw i32 %6, 1
+  store i32 %7, i32* %1, align 4
+  br label %2
+
+8:
+  ret void
+}
@@ -0,0 +1,25 @@
+; Outputs 2 to the power of the input, which must be at least 1, in a block
+; that branches back to itself.
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  call void @input(i32* %1)
+  %2 = load i32, i32* %1, align 4
+  br label %3
+
+3:
+  %4 = phi i32 [ %2, %0 ], [ %6, %3 ]
+  %5 = phi i32 [ 1, %0 ], [ %7, %3 ]
+  %6 = sub nsw i32 %4, 1
+  %7 = add nsw i32 %5, %5
+  %8 = icmp ne i32 %6, 0
+  br i1 %8, label %3, label %9
+
+9:
+  store i32 %7, i32* %1, align 4
+  call void @output(i32* %1)
+  ret void
+}
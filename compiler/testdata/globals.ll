@@ -0,0 +1,42 @@
+; Adds each input to a running total, kept in a global by a function, until
+; the total would pass a limit, then outputs the total and the number added.
+
+@total = global i32 0, align 4
+@MAX = constant i32 20, align 4
+@count = global i32 zeroinitializer, align 4
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define void @add(i32 %0) {
+  %2 = load i32, i32* @total, align 4
+  %3 = add nsw i32 %2, %0
+  store i32 %3, i32* @total, align 4
+  %4 = load i32, i32* @count, align 4
+  %5 = add nsw i32 %4, 1
+  store i32 %5, i32* @count, align 4
+  ret void
+}
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  br label %2
+
+2:
+  call void @input(i32* %1)
+  %3 = load i32, i32* %1, align 4
+  %4 = load i32, i32* @total, align 4
+  %5 = add nsw i32 %4, %3
+  %6 = load i32, i32* @MAX, align 4
+  %7 = icmp sgt i32 %5, %6
+  br i1 %7, label %8, label %9
+
+8:
+  call void @output(i32* @total)
+  call void @output(i32* @count)
+  ret void
+
+9:
+  call void @add(i32 %3)
+  br label %2
+}
@@ -0,0 +1,46 @@
+; Outputs the greater of the first two inputs, then of the last two, through a
+; function called twice; and the sum of all three through one taking pointers.
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define i32 @max(i32 %0, i32 %1) {
+  %3 = icmp sgt i32 %0, %1
+  br i1 %3, label %4, label %5
+
+4:
+  ret i32 %0
+
+5:
+  ret i32 %1
+}
+
+define void @add(i32* %0, i32 %1) {
+  %3 = load i32, i32* %0, align 4
+  %4 = add nsw i32 %3, %1
+  store i32 %4, i32* %0, align 4
+  ret void
+}
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  %2 = alloca i32, align 4
+  %3 = alloca i32, align 4
+  %4 = alloca i32, align 4
+  call void @input(i32* %1)
+  call void @input(i32* %2)
+  call void @input(i32* %3)
+  %5 = load i32, i32* %1, align 4
+  %6 = load i32, i32* %2, align 4
+  %7 = load i32, i32* %3, align 4
+  %8 = call i32 @max(i32 %5, i32 %6)
+  store i32 %8, i32* %4, align 4
+  call void @output(i32* %4)
+  %9 = call i32 @max(i32 %6, i32 %7)
+  store i32 %9, i32* %4, align 4
+  call void @output(i32* %4)
+  call void @add(i32* %1, i32 %6)
+  call void @add(i32* %1, i32 %7)
+  call void @output(i32* %1)
+  ret void
+}
@@ -0,0 +1,30 @@
+; Outputs the first input divided by 7 and its remainder, which are signed by
+; the input alone; then the second input, an unsigned char, times the first and
+; its remainder by 5.
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  %2 = alloca i32, align 4
+  call void @input(i32* %1)
+  call void @input(i32* %2)
+  %3 = load i32, i32* %1, align 4
+  %4 = load i32, i32* %2, align 4
+  %5 = sdiv i32 %3, 7
+  store i32 %5, i32* %2, align 4
+  call void @output(i32* %2)
+  %6 = srem i32 %3, 7
+  store i32 %6, i32* %2, align 4
+  call void @output(i32* %2)
+  %7 = trunc i32 %4 to i8
+  %8 = zext i8 %7 to i32
+  %9 = mul nsw i32 %3, %8
+  store i32 %9, i32* %2, align 4
+  call void @output(i32* %2)
+  %10 = srem i32 %8, 5
+  store i32 %10, i32* %2, align 4
+  call void @output(i32* %2)
+  ret void
+}
@@ -0,0 +1,32 @@
+; Outputs whether the first input is sgt, sge, slt and sle to the second, as 0
+; or 1.
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  %2 = alloca i32, align 4
+  %3 = alloca i32, align 4
+  call void @input(i32* %1)
+  call void @input(i32* %2)
+  %4 = load i32, i32* %1, align 4
+  %5 = load i32, i32* %2, align 4
+  %6 = icmp sgt i32 %4, %5
+  %7 = zext i1 %6 to i32
+  store i32 %7, i32* %3, align 4
+  call void @output(i32* %3)
+  %8 = icmp sge i32 %4, %5
+  %9 = zext i1 %8 to i32
+  store i32 %9, i32* %3, align 4
+  call void @output(i32* %3)
+  %10 = icmp slt i32 %4, %5
+  %11 = zext i1 %10 to i32
+  store i32 %11, i32* %3, align 4
+  call void @output(i32* %3)
+  %12 = icmp sle i32 %4, %5
+  %13 = zext i1 %12 to i32
+  store i32 %13, i32* %3, align 4
+  call void @output(i32* %3)
+  ret void
+}
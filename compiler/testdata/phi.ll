@@ -0,0 +1,27 @@
+; Outputs the sum of 1 to the input, counted in PHIs.
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  call void @input(i32* %1)
+  %2 = load i32, i32* %1, align 4
+  br label %3
+
+3:
+  %4 = phi i32 [ %2, %0 ], [ %9, %7 ]
+  %5 = phi i32 [ 0, %0 ], [ %8, %7 ]
+  %6 = icmp ne i32 %4, 0
+  br i1 %6, label %7, label %10
+
+7:
+  %8 = add nsw i32 %5, %4
+  %9 = sub nsw i32 %4, 1
+  br label %3
+
+10:
+  store i32 %5, i32* %1, align 4
+  call void @output(i32* %1)
+  ret void
+}
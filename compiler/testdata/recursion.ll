@@ -0,0 +1,28 @@
+; Counts down from the input through a recursive function.
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define void @down(i32 %0) {
+  %2 = alloca i32, align 4
+  store i32 %0, i32* %2, align 4
+  call void @output(i32* %2)
+  %3 = icmp sgt i32 %0, 1
+  br i1 %3, label %4, label %6
+
+4:
+  %5 = sub nsw i32 %0, 1
+  call void @down(i32 %5)
+  br label %6
+
+6:
+  ret void
+}
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  call void @input(i32* %1)
+  %2 = load i32, i32* %1, align 4
+  call void @down(i32 %2)
+  ret void
+}
@@ -0,0 +1,27 @@
+; Outputs the greater of two inputs, then whether the first is negative and
+; its magnitude, both selected by the same comparison.
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  %2 = alloca i32, align 4
+  call void @input(i32* %1)
+  call void @input(i32* %2)
+  %3 = load i32, i32* %1, align 4
+  %4 = load i32, i32* %2, align 4
+  %5 = icmp sgt i32 %3, %4
+  %6 = select i1 %5, i32 %3, i32 %4
+  store i32 %6, i32* %2, align 4
+  call void @output(i32* %2)
+  %7 = icmp slt i32 %3, 0
+  %8 = select i1 %7, i32 1, i32 0
+  store i32 %8, i32* %2, align 4
+  call void @output(i32* %2)
+  %9 = sub nsw i32 0, %3
+  %10 = select i1 %7, i32 %9, i32 %3
+  store i32 %10, i32* %2, align 4
+  call void @output(i32* %2)
+  ret void
+}
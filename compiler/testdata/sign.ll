@@ -0,0 +1,33 @@
+; Outputs the sign of the input: -1, 0 or 1.
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  %2 = alloca i32, align 4
+  call void @input(i32* %1)
+  %3 = load i32, i32* %1, align 4
+  %4 = icmp slt i32 %3, 0
+  br i1 %4, label %5, label %6
+
+5:
+  store i32 -1, i32* %2, align 4
+  br label %10
+
+6:
+  %7 = icmp eq i32 %3, 0
+  br i1 %7, label %8, label %9
+
+8:
+  store i32 0, i32* %2, align 4
+  br label %10
+
+9:
+  store i32 1, i32* %2, align 4
+  br label %10
+
+10:
+  call void @output(i32* %2)
+  ret void
+}
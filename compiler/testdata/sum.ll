@@ -0,0 +1,29 @@
+; Outputs the sum of the numbers from 1 to the input, through a recursive
+; function whose parameter is still needed after it calls itself.
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define i32 @sum(i32 %0) {
+  %2 = icmp sgt i32 %0, 0
+  br i1 %2, label %3, label %7
+
+3:
+  %4 = sub nsw i32 %0, 1
+  %5 = call i32 @sum(i32 %4)
+  %6 = add nsw i32 %0, %5
+  ret i32 %6
+
+7:
+  ret i32 0
+}
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  call void @input(i32* %1)
+  %2 = load i32, i32* %1, align 4
+  %3 = call i32 @sum(i32 %2)
+  store i32 %3, i32* %1, align 4
+  call void @output(i32* %1)
+  ret void
+}
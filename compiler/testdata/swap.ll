@@ -0,0 +1,36 @@
+; Swaps the first two inputs as many times as the third, through PHIs that
+; copy each other, then outputs them.
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  %2 = alloca i32, align 4
+  %3 = alloca i32, align 4
+  call void @input(i32* %1)
+  call void @input(i32* %2)
+  call void @input(i32* %3)
+  %4 = load i32, i32* %1, align 4
+  %5 = load i32, i32* %2, align 4
+  %6 = load i32, i32* %3, align 4
+  br label %7
+
+7:
+  %8 = phi i32 [ %4, %0 ], [ %9, %12 ]
+  %9 = phi i32 [ %5, %0 ], [ %8, %12 ]
+  %10 = phi i32 [ %6, %0 ], [ %13, %12 ]
+  %11 = icmp ne i32 %10, 0
+  br i1 %11, label %12, label %14
+
+12:
+  %13 = sub nsw i32 %10, 1
+  br label %7
+
+14:
+  store i32 %8, i32* %1, align 4
+  store i32 %9, i32* %2, align 4
+  call void @output(i32* %1)
+  call void @output(i32* %2)
+  ret void
+}
@@ -0,0 +1,71 @@
+; Outputs a code for the input by a switch of a few cases far apart, then one
+; for it by a switch of dense cases, 1 to 9.
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  %2 = alloca i32, align 4
+  call void @input(i32* %1)
+  %3 = load i32, i32* %1, align 4
+  switch i32 %3, label %7 [
+    i32 -40, label %4
+    i32 7, label %5
+    i32 300, label %6
+  ]
+
+4:
+  store i32 1, i32* %2, align 4
+  br label %8
+
+5:
+  store i32 2, i32* %2, align 4
+  br label %8
+
+6:
+  store i32 3, i32* %2, align 4
+  br label %8
+
+7:
+  store i32 0, i32* %2, align 4
+  br label %8
+
+8:
+  call void @output(i32* %2)
+  switch i32 %3, label %13 [
+    i32 1, label %9
+    i32 2, label %10
+    i32 3, label %11
+    i32 4, label %9
+    i32 5, label %12
+    i32 6, label %12
+    i32 7, label %11
+    i32 8, label %10
+    i32 9, label %9
+  ]
+
+9:
+  store i32 10, i32* %2, align 4
+  br label %14
+
+10:
+  store i32 20, i32* %2, align 4
+  br label %14
+
+11:
+  store i32 30, i32* %2, align 4
+  br label %14
+
+12:
+  store i32 50, i32* %2, align 4
+  br label %14
+
+13:
+  store i32 -1, i32* %2, align 4
+  br label %14
+
+14:
+  call void @output(i32* %2)
+  ret void
+}
@@ -0,0 +1,21 @@
+; Outputs the unsigned quotient and remainder of two inputs. Words are
+; unsigned, e.g., -1 is the largest.
+
+declare void @input(i32*)
+declare void @output(i32*)
+
+define void @_lmc() {
+  %1 = alloca i32, align 4
+  %2 = alloca i32, align 4
+  call void @input(i32* %1)
+  call void @input(i32* %2)
+  %3 = load i32, i32* %1, align 4
+  %4 = load i32, i32* %2, align 4
+  %5 = udiv i32 %3, %4
+  store i32 %5, i32* %2, align 4
+  call void @output(i32* %2)
+  %6 = urem i32 %3, %4
+  store i32 %6, i32* %2, align 4
+  call void @output(i32* %2)
+  ret void
+}
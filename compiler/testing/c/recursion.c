@@ -0,0 +1,33 @@
+#include "lmc.h"
+
+number fact(number n)
+{
+    if (n <= 1)
+        return 1;
+
+    return n * fact(n - 1);
+}
+
+number fib(number n)
+{
+    if (n < 2)
+        return n;
+
+    return fib(n - 1) + fib(n - 2);
+}
+
+void _lmc(void)
+{
+    // each recursive call pushes three words, so five frames are enough for n
+    // up to 6; with the code pushing and popping them, this needs 256
+    // mailboxes, more than a classic LMC has
+    __lmc_option__("CALLS", C_SUBROUTINE);
+    __lmc_option__("STACK", 15);
+    __lmc_option__("MAILBOXES", 256);
+
+    number n;
+    input(&n);
+
+    put(fact(n));
+    put(fib(n));
+}
@@ -1,4 +1,5 @@
 // An example compiler. It just takes in an ll file, compiles it, and optimises it.
+// The file is the first argument, or ll/test.ll if there is none.
 package main
 
 import (
@@ -8,12 +9,18 @@ import (
 	"github.com/clr1107/lmc-llvm-target/lmc"
 	"github.com/clr1107/lmc-llvm-target/lmc/optimisation"
 	"github.com/llir/llvm/asm"
+	"github.com/llir/llvm/ir"
 	"os"
 	"strings"
 )
 
 func main() {
-	mod, err := asm.ParseFile("ll/test.ll")
+	path := "ll/test.ll"
+	if len(os.Args) > 1 {
+		path = os.Args[1]
+	}
+
+	mod, err := asm.ParseFile(path)
 	if err != nil {
 		fmt.Printf("error whilst parsing file: %s\n", err)
 		os.Exit(1)
@@ -30,55 +37,99 @@ func main() {
 
 	engine := compiler.NewEngine(comp)
 
-	for _, block := range f.Blocks {
-		matches, err := engine.FindAll(block.Insts)
+	// handle reports the error or warnings of a compilation, then adds it
+	handle := func(c *compiler.Compilation, lls []ir.LLStringer) {
+		if c.Err != nil {
+			fmt.Printf("could not compile instructions: ")
+			for _, i := range lls {
+				fmt.Printf("%s, ", i.LLString())
+			}
+			fmt.Printf("\n")
 
-		if err != nil {
-			fmt.Printf("error pattern matching instructions\n\t%s\n", err)
+			fmt.Printf("\t%s\n", c.Err)
 			os.Exit(1)
 		}
 
-		for _, m := range matches {
-			c := m.Pattern.Compile(m.Instrs)
+		if len(c.Warnings) != 0 {
+			var warningBuf strings.Builder
+
+			for _, w := range c.Warnings {
+				if w.Level <= errors.WarningLevel(comp.Options.Get("WLEVEL").Value.(int)) {
+					warningBuf.WriteString(fmt.Sprintf("\t%s\n", w))
+				}
+			}
 
-			if c.Err != nil {
-				fmt.Printf("could not compile instructions: ")
-				for _, i := range m.Instrs {
+			if warningBuf.Len() > 0 {
+				fmt.Printf("Warnings: ")
+				for _, i := range lls {
 					fmt.Printf("%s, ", i.LLString())
 				}
-				fmt.Printf("\n")
+				fmt.Printf("\n%s", warningBuf.String())
+			}
+		}
 
-				fmt.Printf("\t%s\n", c.Err)
-				os.Exit(1)
+		if err := comp.AddCompiledInstruction(c.Wrapped); err != nil {
+			fmt.Printf("could not add compiled instruction: ")
+			for _, i := range lls {
+				fmt.Printf("%s, ", i.LLString())
 			}
+			fmt.Printf("\n")
 
-			if len(c.Warnings) != 0 {
-				var warningBuf strings.Builder
+			fmt.Printf("\t%s\n", err)
+		}
+	}
 
-				for _, w := range c.Warnings {
-					if w.Level <= errors.WarningLevel(comp.Options.Get("WLEVEL").Value.(int)) {
-						warningBuf.WriteString(fmt.Sprintf("\t%s\n", w))
-					}
-				}
+	// options first, as they decide how calls are compiled
+	for _, c := range comp.ReadOptions(f) {
+		lls := []ir.LLStringer{c.Wrapped.LLBase()[0]}
+		handle(c, lls)
+	}
 
-				if warningBuf.Len() > 0 {
-					fmt.Printf("Warnings: ")
-					for _, i := range m.Instrs {
-						fmt.Printf("%s, ", i.LLString())
-					}
-					fmt.Printf("\n%s", warningBuf.String())
-				}
+	funcs, err := comp.Functions(f)
+	if err != nil {
+		fmt.Printf("could not compile calls\n\t%s\n", err)
+		os.Exit(1)
+	}
+
+	for _, fn := range funcs {
+		if err := comp.BeginFunc(fn); err != nil {
+			fmt.Printf("could not begin function %s\n\t%s\n", fn.Ident(), err)
+			os.Exit(1)
+		}
+
+		for k, block := range fn.Blocks {
+			if err := comp.BeginBlock(block); err != nil {
+				fmt.Printf("could not begin block %s\n\t%s\n", block.Ident(), err)
+				os.Exit(1)
 			}
 
-			if err := comp.AddCompiledInstruction(c.Wrapped); err != nil {
-				fmt.Printf("could not add compiled instruction: ")
-				for _, i := range m.Instrs {
-					fmt.Printf("%s, ", i.LLString())
+			matches, err := engine.FindAll(block.Insts)
+
+			if err != nil {
+				fmt.Printf("error pattern matching instructions\n\t%s\n", err)
+				os.Exit(1)
+			}
+
+			for _, m := range matches {
+				lls := make([]ir.LLStringer, len(m.Instrs))
+				for j, i := range m.Instrs {
+					lls[j] = i
 				}
-				fmt.Printf("\n")
 
-				fmt.Printf("\t%s\n", err)
+				handle(m.Pattern.Compile(m.Instrs), lls)
+			}
+
+			var next *ir.Block
+			if k+1 < len(fn.Blocks) {
+				next = fn.Blocks[k+1]
 			}
+
+			handle(comp.WrapLLTerm(block.Term, block, next), []ir.LLStringer{block.Term})
+		}
+
+		if err := comp.EndFunc(); err != nil {
+			fmt.Printf("could not end function %s\n\t%s\n", fn.Ident(), err)
+			os.Exit(1)
 		}
 	}
 
@@ -87,19 +138,33 @@ func main() {
 
 	var strategies []optimisation.OStrategy
 
-	optValue := comp.Options.Get("OPT").Value.(optimisation.OStrategy)
-	for l := 0; l <= 3; l++ {
-		mask := (optValue >> l) & 1
-		if mask == 1 {
-			if l == 0 {
-				strategies = append(strategies, optimisation.OStrategy(1))
-			} else {
-				strategies = append(strategies, optimisation.OStrategy(2<<(l-1)))
-			}
+	optValue := optimisation.OStrategy(comp.Options.Get("OPT").Value.(int))
+	order := []optimisation.OStrategy{
+		optimisation.Chaining, // before anything changes the loops it looks for
+		optimisation.Thrashing,
+		optimisation.Clean,
+		optimisation.BProp,
+		optimisation.DeadStore,
+		optimisation.Unroll, // once the loops are as short as they get
+		optimisation.BProp,  // then again, for the copies and stores it repeats
+		optimisation.DeadStore,
+		optimisation.Coalesce,
+	}
+
+	for _, s := range order {
+		if optValue&s != 0 {
+			strategies = append(strategies, s)
 		}
 	}
 
+	// stores made dead leave loads for thrashing to remove
+	if optValue&optimisation.DeadStore != 0 && optValue&optimisation.Thrashing != 0 {
+		strategies = append(strategies, optimisation.Thrashing)
+	}
+
 	optimiser := optimisation.NewStackingOptimiser(comp.Prog, strategies)
+	optimiser.ChainLength = comp.Options.Get("CHAIN_LENGTH").Value.(int)
+	optimiser.UnrollLength = comp.Options.Get("UNROLL_LENGTH").Value.(int)
 
 	if err := optimiser.Optimise(); err != nil {
 		fmt.Printf("could not optimise program: %s\n", err)
@@ -109,5 +174,10 @@ func main() {
 	prog := optimiser.Program()
 	fmt.Printf("Optimised %d instrs, %d defs:\n%s\n", len(prog.Memory.InstructionsList.Instructions), len(prog.Memory.InstructionsList.DefInstructions), prog)
 
+	if err := comp.CheckCapacity(); err != nil {
+		fmt.Printf("%s\n", err)
+		os.Exit(1)
+	}
+
 	fmt.Printf("\n\nCompiler options:\n%s\n", comp.Options)
 }
@@ -5,6 +5,7 @@ import (
 	"github.com/clr1107/lmc-llvm-target/lmc"
 	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
 	"reflect"
 	"sort"
 )
@@ -63,6 +64,122 @@ func ReflectGetLocalID(x interface{}) (lmc.Address, error) {
 	return lmc.Address(id.Int()), nil
 }
 
+// ReflectUses returns true if an LL instruction or terminator has the value as
+// an operand. Only exported fields are searched, including slices of values
+// (e.g., call arguments) and of structs holding them (e.g., PHI incomings).
+func ReflectUses(x interface{}, v value.Value) bool {
+	s := reflect.ValueOf(x)
+	if s.Kind() == reflect.Ptr {
+		s = s.Elem()
+	}
+
+	if s.Kind() != reflect.Struct {
+		return false
+	}
+
+	is := func(f reflect.Value) bool {
+		return f.Kind() == reflect.Interface && !f.IsNil() && f.Elem().Kind() == reflect.Ptr && f.Interface() == v
+	}
+
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Field(i)
+		if !f.CanInterface() {
+			continue
+		}
+
+		if is(f) {
+			return true
+		} else if f.Kind() != reflect.Slice {
+			continue
+		}
+
+		for j := 0; j < f.Len(); j++ {
+			e := f.Index(j)
+
+			if is(e) {
+				return true
+			} else if e.Kind() != reflect.Ptr || e.IsNil() {
+				continue
+			}
+
+			// not into other values, e.g., successor blocks
+			if _, ok := e.Interface().(value.Value); !ok && ReflectUses(e.Interface(), v) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// onlyUser returns true if an LL value is used by no instruction or terminator
+// of a function but the one given.
+func onlyUser(f *ir.Func, v value.Value, user ir.Instruction) bool {
+	for _, block := range f.Blocks {
+		if ReflectUses(block.Term, v) {
+			return false
+		}
+
+		for _, instr := range block.Insts {
+			if instr != user && ReflectUses(instr, v) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// ReflectReplace replaces the operands of an LL instruction or terminator that
+// are keys of the map with their values, in the same fields as *ReflectUses.
+func ReflectReplace(x interface{}, m map[value.Value]value.Value) {
+	s := reflect.ValueOf(x)
+	if s.Kind() == reflect.Ptr {
+		s = s.Elem()
+	}
+
+	if s.Kind() != reflect.Struct {
+		return
+	}
+
+	replace := func(f reflect.Value) {
+		if (f.Kind() != reflect.Interface && f.Kind() != reflect.Ptr) || f.IsNil() || !f.CanSet() {
+			return
+		}
+
+		if v, ok := f.Interface().(value.Value); ok {
+			if n, ok := m[v]; ok && reflect.TypeOf(n).AssignableTo(f.Type()) {
+				f.Set(reflect.ValueOf(n))
+			}
+		}
+	}
+
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Field(i)
+		if !f.CanInterface() {
+			continue
+		}
+
+		if f.Kind() != reflect.Slice {
+			replace(f)
+			continue
+		}
+
+		for j := 0; j < f.Len(); j++ {
+			e := f.Index(j)
+			replace(e)
+
+			if e.Kind() != reflect.Ptr || e.IsNil() {
+				continue
+			}
+
+			if _, ok := e.Interface().(value.Value); !ok {
+				ReflectReplace(e.Interface(), m)
+			}
+		}
+	}
+}
+
 func ReflectGetProperty(x interface{}, field string) (interface{}, error) {
 	property := reflect.ValueOf(x).FieldByName(field)
 	if property.IsZero() {
@@ -81,6 +198,10 @@ func ValidLLType(t types.Type) bool {
 		return ValidLLType(c.ElemType)
 	}
 
+	if c, ok := t.(*types.ArrayType); ok {
+		return ValidLLType(c.ElemType)
+	}
+
 	return false
 }
 
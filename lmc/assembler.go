@@ -0,0 +1,285 @@
+package lmc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ---------- Opcodes ----------
+
+// Opcode is the leading digit of an assembled instruction word; the remaining
+// digits are the address operand.
+type Opcode int
+
+const (
+	OpHalt           Opcode = 0
+	OpAdd            Opcode = 1
+	OpSub            Opcode = 2
+	OpStore          Opcode = 3
+	OpLoad           Opcode = 5
+	OpBranchAlways   Opcode = 6
+	OpBranchZero     Opcode = 7
+	OpBranchPositive Opcode = 8
+	OpIO             Opcode = 9
+)
+
+// Operands of OpIO.
+const (
+	IOInput  Address = 1
+	IOOutput Address = 2
+)
+
+var bOpcodes = [...]Opcode{OpBranchAlways, OpBranchPositive, OpBranchZero}
+
+// AddressDigits gives how many decimal digits are needed to address the given
+// number of mailboxes. Classic LMC has 100 mailboxes, so 2 digits, which is
+// also the minimum.
+func AddressDigits(mailboxes int) int {
+	digits := 2
+
+	for n := 100; n < mailboxes; n *= 10 {
+		digits++
+	}
+
+	return digits
+}
+
+// UnlimitedDigits is how many digits addresses have in a program with an
+// Unlimited capacity, whatever its size, so that its arithmetic does not
+// change as it grows: up to 1000 mailboxes, holding words of 0 to 9999.
+const UnlimitedDigits = 3
+
+// WordModulus gives the number of distinct values a word can take when
+// addresses have the given number of digits: one extra digit for the opcode.
+// E.g., 1000 for classic LMC.
+func WordModulus(digits int) Value {
+	m := Value(10)
+
+	for i := 0; i < digits; i++ {
+		m *= 10
+	}
+
+	return m
+}
+
+// Encode gives the instruction word for an opcode and address.
+func Encode(op Opcode, addr Address, digits int) Value {
+	return Value(op)*(WordModulus(digits)/10) + Value(addr)
+}
+
+// Decode splits an instruction word into its opcode and address.
+func Decode(word Value, digits int) (Opcode, Address) {
+	s := WordModulus(digits) / 10
+	return Opcode(word / s), Address(word % s)
+}
+
+// ---------- Image ----------
+
+// Image is an assembled program: the numeric contents of every mailbox, in
+// address order, along with the address given to every label and mailbox
+// identifier.
+type Image struct {
+	Words   []Value
+	Symbols map[string]Address
+	Digits  int
+}
+
+// Modulus gives the number of distinct values a word in this image can take.
+// See WordModulus.
+func (i *Image) Modulus() Value {
+	return WordModulus(i.Digits)
+}
+
+// Normalise gives the word storing a value, wrapping it into [0, modulus);
+// negative values are stored in ten's complement, e.g., -1 as 999.
+func (i *Image) Normalise(v Value) Value {
+	m := i.Modulus()
+	return ((v % m) + m) % m
+}
+
+// String gives a memory dump, one mailbox per line: the address then the word,
+// both zero padded.
+//
+// E.g., `00 901`.
+func (i *Image) String() string {
+	var buf strings.Builder
+
+	for addr, word := range i.Words {
+		_, _ = fmt.Fprintf(&buf, "%0*d %0*d\n", i.Digits, addr, i.Digits+1, word)
+	}
+
+	return buf.String()
+}
+
+// SymbolTable gives each identifier and its address, one per line, ordered by
+// address.
+//
+// E.g., `00 l_A`.
+func (i *Image) SymbolTable() string {
+	identifiers := make([]string, 0, len(i.Symbols))
+	for k := range i.Symbols {
+		identifiers = append(identifiers, k)
+	}
+
+	sort.Slice(identifiers, func(a int, b int) bool {
+		x, y := i.Symbols[identifiers[a]], i.Symbols[identifiers[b]]
+		return x < y || (x == y && identifiers[a] < identifiers[b])
+	})
+
+	var buf strings.Builder
+
+	for _, identifier := range identifiers {
+		_, _ = fmt.Fprintf(&buf, "%0*d %s\n", i.Digits, i.Symbols[identifier], identifier)
+	}
+
+	return buf.String()
+}
+
+// ---------- Assembling ----------
+
+// Assemble lays out every instruction, in order, starting at mailbox 0,
+// followed by every data instruction, each array taking as many mailboxes as
+// it has values. Labels and mailboxes are then resolved to the addresses they
+// were given and each instruction is encoded as a word.
+// Data values are normalised, see *Image#Normalise, and the words held by data
+// instructions are encoded likewise. The mailbox of a label (see
+// *Program#LabelMailbox) is resolved to the address of the label.
+//
+// The program must fit in its capacity (see *Program#CheckCapacity), which
+// also decides how many digits are used for addresses, see *Program#Digits.
+func (p *Program) Assemble() (*Image, error) {
+	if err := p.CheckCapacity(); err != nil {
+		return nil, AssemblyError(err)
+	}
+
+	list := p.Memory.InstructionsList
+	size := len(list.Instructions) + list.DataSize()
+	digits := p.Digits()
+
+	if AddressDigits(size) > digits {
+		return nil, AssemblyError(fmt.Errorf("%d mailboxes cannot be addressed with %d digits; the program needs a capacity", size, digits))
+	}
+
+	image := &Image{
+		Words:   make([]Value, size),
+		Symbols: make(map[string]Address, size),
+		Digits:  digits,
+	}
+
+	define := func(identifier string, addr Address) error {
+		if _, ok := image.Symbols[identifier]; ok {
+			return AssemblyError(fmt.Errorf("identifier `%s' is defined more than once", identifier))
+		}
+
+		image.Symbols[identifier] = addr
+		return nil
+	}
+
+	for k, instr := range list.Instructions {
+		var err error
+
+		if c, ok := instr.(*Labelled); ok {
+			err = define(c.Identifier(), Address(k))
+		} else if c, ok := instr.(*DataInstr); ok {
+			err = define(c.Box.Identifier(), Address(k))
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the address of each data instruction, after any arrays before it
+	defAddrs := make([]Address, len(list.DefInstructions))
+	next := Address(len(list.Instructions))
+
+	for k, def := range list.DefInstructions {
+		defAddrs[k] = next
+		next += Address(def.Size())
+
+		if err := define(def.Box.Identifier(), defAddrs[k]); err != nil {
+			return nil, err
+		}
+	}
+
+	resolve := func(identifier string, err func(string) error) (Address, error) {
+		if addr, ok := image.Symbols[identifier]; ok {
+			return addr, nil
+		}
+
+		return 0, AssemblyError(err(identifier))
+	}
+
+	var encode func(instr Instruction) (Value, error)
+
+	encode = func(instr Instruction) (Value, error) {
+		var op Opcode
+		var addr Address
+		var err error
+
+		switch c := Unwrap(instr).(type) {
+		case *DataInstr:
+			if c.Word != nil {
+				return encode(c.Word)
+			}
+
+			return image.Normalise(c.Data), nil
+		case *SlotInstr:
+			return image.Normalise(c.Data), nil
+		case *InputInstr:
+			op, addr = OpIO, IOInput
+		case *OutputInstr:
+			op, addr = OpIO, IOOutput
+		case *HaltInstr:
+			op = OpHalt
+		case *AddInstr:
+			op = OpAdd
+			addr, err = resolve(c.Param.Identifier(), UnknownMailboxError)
+		case *SubInstr:
+			op = OpSub
+			addr, err = resolve(c.Param.Identifier(), UnknownMailboxError)
+		case *StoreInstr:
+			op = OpStore
+			addr, err = resolve(c.Param.Identifier(), UnknownMailboxError)
+		case *LoadInstr:
+			op = OpLoad
+			addr, err = resolve(c.Param.Identifier(), UnknownMailboxError)
+		case *BranchInstr:
+			op = bOpcodes[c.BranchType]
+			addr, err = resolve(c.Identifier(), UnknownLabelError)
+		default:
+			err = AssemblyError(fmt.Errorf("cannot encode `%s'", instr.LMCString()))
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		return Encode(op, addr, image.Digits), nil
+	}
+
+	for k, instr := range list.Instructions {
+		word, err := encode(instr)
+		if err != nil {
+			return nil, err
+		}
+
+		image.Words[k] = word
+	}
+
+	for k, def := range list.DefInstructions {
+		word, err := encode(def)
+		if err != nil {
+			return nil, err
+		}
+
+		image.Words[defAddrs[k]] = word
+
+		for j, v := range def.Rest {
+			image.Words[defAddrs[k]+Address(j+1)] = image.Normalise(v)
+		}
+	}
+
+	return image, nil
+}
@@ -0,0 +1,193 @@
+package lmc
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestAssemble checks the words and symbols a fixture is assembled into.
+func TestAssemble(t *testing.T) {
+	text, err := os.ReadFile(filepath.Join("testdata", "sum.lmc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prog, err := ParseString(string(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image, err := prog.Assemble()
+	if err != nil {
+		t.Fatalf("could not assemble: %s", err)
+	}
+
+	words := []Value{
+		901, 314, 516, 315, 515, 114, 315, 514, 217, 314, 804, 515, 902, 0,
+		0, 0, 0, 1,
+	}
+
+	if image.Digits != 2 {
+		t.Errorf("assembled with %d address digits, want 2", image.Digits)
+	}
+
+	if !reflect.DeepEqual(image.Words, words) {
+		t.Errorf("assembled into\n%v\nwant\n%v", image.Words, words)
+	}
+
+	symbols := map[string]Address{
+		"l_A": 0, "l_B": 4,
+		"X": 14, "Y": 15, "c_A": 16, "c_B": 17,
+	}
+
+	if !reflect.DeepEqual(image.Symbols, symbols) {
+		t.Errorf("symbols are\n%v\nwant\n%v", image.Symbols, symbols)
+	}
+}
+
+// TestEncodeDecode checks that words are split back into the opcode and
+// address they were encoded from, at more than one width.
+func TestEncodeDecode(t *testing.T) {
+	for _, c := range []struct {
+		op     Opcode
+		addr   Address
+		digits int
+		word   Value
+	}{
+		{OpHalt, 0, 2, 0},
+		{OpAdd, 14, 2, 114},
+		{OpLoad, 99, 2, 599},
+		{OpIO, IOOutput, 2, 902},
+		{OpStore, 150, 3, 3150},
+		{OpBranchPositive, 7, 3, 8007},
+	} {
+		if word := Encode(c.op, c.addr, c.digits); word != c.word {
+			t.Errorf("encoded %d %d as %d, want %d", c.op, c.addr, word, c.word)
+		}
+
+		if op, addr := Decode(c.word, c.digits); op != c.op || addr != c.addr {
+			t.Errorf("decoded %d as %d %d, want %d %d", c.word, op, addr, c.op, c.addr)
+		}
+	}
+
+	for _, c := range []struct {
+		mailboxes int
+		digits    int
+		modulus   Value
+	}{
+		{10, 2, 1000},
+		{100, 2, 1000},
+		{101, 3, 10000},
+		{1000, 3, 10000},
+		{1001, 4, 100000},
+	} {
+		if digits := AddressDigits(c.mailboxes); digits != c.digits {
+			t.Errorf("%d mailboxes need %d digits, want %d", c.mailboxes, digits, c.digits)
+		} else if modulus := WordModulus(digits); modulus != c.modulus {
+			t.Errorf("%d digits give a modulus of %d, want %d", digits, modulus, c.modulus)
+		}
+	}
+
+	image := &Image{Digits: 2}
+	for v, want := range map[Value]Value{-1: 999, -500: 500, 1000: 0, 1234: 234} {
+		if got := image.Normalise(v); got != want {
+			t.Errorf("normalised %d to %d, want %d", v, got, want)
+		}
+	}
+}
+
+// TestAssembleErrors checks that programs whose identifiers cannot be
+// resolved to one address each are rejected.
+func TestAssembleErrors(t *testing.T) {
+	box := NewMailbox(0, "X")
+	missing := NewMailbox(1, "Y")
+	label := NewLabel("X")
+	nowhere := NewLabel("l_Z")
+
+	for name, c := range map[string]struct {
+		instrs []Instruction
+		defs   []*DataInstr
+	}{
+		"duplicate identifier": {
+			[]Instruction{NewLabelled(label, NewLoadInstr(box)), NewHaltInstr()},
+			[]*DataInstr{NewDataInstr(0, box)},
+		},
+		"unknown mailbox": {
+			[]Instruction{NewLoadInstr(missing), NewHaltInstr()},
+			[]*DataInstr{NewDataInstr(0, box)},
+		},
+		"unknown label": {
+			[]Instruction{NewBranchInstr(BRAlways, nowhere), NewHaltInstr()},
+			nil,
+		},
+	} {
+		prog := NewProgram(NewBasicMemory())
+		prog.AddInstructions(c.instrs, c.defs)
+
+		if image, err := prog.Assemble(); err == nil {
+			t.Errorf("%s: assembled without an error:\n%s", name, image)
+		}
+	}
+}
+
+// TestAssembleCapacity checks that the capacity of a program, not its size,
+// decides the width of its words, and that a program which does not fit is
+// rejected with a *CapacityError.
+func TestAssembleCapacity(t *testing.T) {
+	text, err := os.ReadFile(filepath.Join("testdata", "sum.lmc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prog, err := ParseString(string(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		capacity int
+		digits   int
+	}{
+		{DefaultCapacity, 2},
+		{18, 2},
+		{150, 3},
+		{Unlimited, UnlimitedDigits},
+	} {
+		prog.Capacity = c.capacity
+
+		image, err := prog.Assemble()
+		if err != nil {
+			t.Errorf("capacity %d: could not assemble: %s", c.capacity, err)
+		} else if image.Digits != c.digits {
+			t.Errorf("capacity %d: assembled with %d address digits, want %d", c.capacity, image.Digits, c.digits)
+		} else if op, addr := Decode(image.Words[1], image.Digits); op != OpStore || addr != 14 {
+			t.Errorf("capacity %d: second word %d decodes to %d %d, want %d 14", c.capacity, image.Words[1], op, addr, OpStore)
+		}
+	}
+
+	prog.Capacity = 10
+
+	err = prog.CheckCapacity()
+	capErr, ok := err.(*CapacityError)
+	if !ok {
+		t.Fatalf("got error %v, want a *CapacityError", err)
+	}
+
+	if capErr.Instructions != 14 || capErr.Data != 4 || capErr.Available != 10 {
+		t.Errorf("got %d instructions, %d data, %d available, want 14, 4, 10", capErr.Instructions, capErr.Data, capErr.Available)
+	}
+
+	if capErr.Constants() != 2 || capErr.Variables() != 2 {
+		t.Errorf("got %d constants and %d variables, want 2 and 2", capErr.Constants(), capErr.Variables())
+	}
+
+	if u := capErr.Usage[0]; u.Box.Identifier() != "X" || u.Uses != 4 {
+		t.Errorf("most used is `%s' (%d), want `X' (4)", u.Box.Identifier(), u.Uses)
+	}
+
+	if image, err := prog.Assemble(); err == nil {
+		t.Errorf("assembled without an error:\n%s", image)
+	}
+}
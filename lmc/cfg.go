@@ -0,0 +1,365 @@
+package lmc
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ---------- BasicBlock ----------
+
+// BasicBlock is a run of instructions that can only be entered at the first
+// and only left after the last. Blocks start at labelled instructions and after
+// branches, slots, and `HLT`. Start is the index of the first instruction in
+// the instruction list the block was built from.
+//
+// A block is an exit if it leaves the program: it ends with `HLT`, or is the
+// last block and falls through off the end of the instructions.
+type BasicBlock struct {
+	Index        int
+	Start        int
+	Instructions []Instruction
+	Successors   []*BasicBlock
+	Predecessors []*BasicBlock
+	Exit         bool
+}
+
+// End gives the index after the last instruction of the block.
+func (b *BasicBlock) End() int {
+	return b.Start + len(b.Instructions)
+}
+
+// Last gives the last instruction of the block, unwrapped.
+func (b *BasicBlock) Last() Instruction {
+	return Unwrap(b.Instructions[len(b.Instructions)-1])
+}
+
+// Label gives the label of the first instruction, if it has one.
+func (b *BasicBlock) Label() *Label {
+	if c, ok := b.Instructions[0].(*Labelled); ok {
+		return c.Label()
+	}
+
+	return nil
+}
+
+func (b *BasicBlock) String() string {
+	return fmt.Sprintf("BasicBlock[%d,%d:%d]", b.Index, b.Start, b.End())
+}
+
+func (b *BasicBlock) addEdge(to *BasicBlock) {
+	for _, s := range b.Successors {
+		if s == to {
+			return
+		}
+	}
+
+	b.Successors = append(b.Successors, to)
+	to.Predecessors = append(to.Predecessors, b)
+}
+
+// ---------- Loop ----------
+
+// Loop is a natural loop: a header which dominates every block in the loop,
+// and latches, the blocks with back edges to the header. Blocks includes the
+// header and latches, ordered as in the program.
+type Loop struct {
+	Header  *BasicBlock
+	Latches []*BasicBlock
+	Blocks  []*BasicBlock
+}
+
+// Contains returns true if the block is in the loop.
+func (l *Loop) Contains(b *BasicBlock) bool {
+	for _, x := range l.Blocks {
+		if x == b {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ---------- CFG ----------
+
+// CFG is the control flow graph of an instruction list. Blocks are in program
+// order, so the entry is always the first.
+type CFG struct {
+	Blocks []*BasicBlock
+	order  []*BasicBlock
+	idom   []*BasicBlock
+}
+
+// NewCFG splits the instructions into basic blocks and connects them by
+// branches and fall through. A slot is connected to each of its targets, or
+// falls through if it has none, see SlotInstr. An error is returned if a
+// branch targets a label not attached to an instruction in the list.
+func NewCFG(list *InstructionList) (*CFG, error) {
+	c := &CFG{}
+	labels := make(map[string]*BasicBlock)
+
+	var block *BasicBlock
+
+	for i, instr := range list.Instructions {
+		_, labelled := instr.(*Labelled)
+
+		if block == nil || labelled {
+			block = &BasicBlock{Index: len(c.Blocks), Start: i}
+			c.Blocks = append(c.Blocks, block)
+		}
+
+		if labelled {
+			labels[instr.(*Labelled).Identifier()] = block
+		}
+
+		block.Instructions = append(block.Instructions, instr)
+
+		switch Unwrap(instr).(type) {
+		case *BranchInstr, *HaltInstr, *SlotInstr:
+			block = nil
+		}
+	}
+
+	for k, b := range c.Blocks {
+		var next *BasicBlock
+		if k+1 < len(c.Blocks) {
+			next = c.Blocks[k+1]
+		}
+
+		fall := true
+
+		switch last := b.Last().(type) {
+		case *HaltInstr:
+			fall = false
+			b.Exit = true
+		case *BranchInstr:
+			target, ok := labels[last.Identifier()]
+			if !ok {
+				return nil, UnknownLabelError(last.Identifier())
+			}
+
+			b.addEdge(target)
+			fall = last.BranchType != BRAlways
+		case *SlotInstr:
+			for _, l := range last.Targets {
+				target, ok := labels[l.Identifier()]
+				if !ok {
+					return nil, UnknownLabelError(l.Identifier())
+				}
+
+				b.addEdge(target)
+			}
+
+			fall = len(last.Targets) == 0
+		}
+
+		if fall {
+			if next != nil {
+				b.addEdge(next)
+			} else {
+				b.Exit = true
+			}
+		}
+	}
+
+	c.computeDominators()
+	return c, nil
+}
+
+// Entry gives the block the program starts in, or nil if there are no
+// instructions.
+func (c *CFG) Entry() *BasicBlock {
+	if len(c.Blocks) == 0 {
+		return nil
+	}
+
+	return c.Blocks[0]
+}
+
+// BlockOf gives the block containing the instruction at an index of the
+// instruction list, or nil if out of range.
+func (c *CFG) BlockOf(i int) *BasicBlock {
+	k := sort.Search(len(c.Blocks), func(k int) bool {
+		return c.Blocks[k].End() > i
+	})
+
+	if k == len(c.Blocks) || i < c.Blocks[k].Start {
+		return nil
+	}
+
+	return c.Blocks[k]
+}
+
+// ReversePostorder gives the blocks reachable from the entry in reverse
+// postorder, i.e., every block comes before its successors, back edges aside.
+func (c *CFG) ReversePostorder() []*BasicBlock {
+	l := make([]*BasicBlock, len(c.order))
+	copy(l, c.order)
+
+	return l
+}
+
+// Reachable returns true if the block can be reached from the entry.
+func (c *CFG) Reachable(b *BasicBlock) bool {
+	return b == c.Entry() || c.idom[b.Index] != nil
+}
+
+// IDom gives the immediate dominator of a block. The entry, and unreachable
+// blocks, have none.
+func (c *CFG) IDom(b *BasicBlock) *BasicBlock {
+	if b == c.Entry() {
+		return nil
+	}
+
+	return c.idom[b.Index]
+}
+
+// Dominates returns true if every path from the entry to b goes through a. A
+// block dominates itself.
+func (c *CFG) Dominates(a *BasicBlock, b *BasicBlock) bool {
+	if !c.Reachable(b) {
+		return false
+	}
+
+	for x := b; x != nil; x = c.IDom(x) {
+		if x == a {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NaturalLoops finds every loop by its back edges, i.e., edges to a block that
+// dominates the source. Back edges to the same header form one loop. Loops are
+// ordered by header.
+func (c *CFG) NaturalLoops() []*Loop {
+	var loops []*Loop
+	headers := make(map[*BasicBlock]*Loop)
+
+	for _, b := range c.order {
+		for _, s := range b.Successors {
+			if !c.Dominates(s, b) {
+				continue
+			}
+
+			loop, ok := headers[s]
+			if !ok {
+				loop = &Loop{Header: s}
+				headers[s] = loop
+				loops = append(loops, loop)
+			}
+
+			loop.Latches = append(loop.Latches, b)
+		}
+	}
+
+	for _, loop := range loops {
+		body := map[*BasicBlock]struct{}{loop.Header: {}}
+		worklist := append([]*BasicBlock{}, loop.Latches...)
+
+		for len(worklist) > 0 {
+			b := worklist[len(worklist)-1]
+			worklist = worklist[:len(worklist)-1]
+
+			if _, ok := body[b]; ok || !c.Reachable(b) {
+				continue
+			}
+
+			body[b] = struct{}{}
+			worklist = append(worklist, b.Predecessors...)
+		}
+
+		for b := range body {
+			loop.Blocks = append(loop.Blocks, b)
+		}
+
+		sort.Slice(loop.Blocks, func(i int, j int) bool {
+			return loop.Blocks[i].Index < loop.Blocks[j].Index
+		})
+	}
+
+	sort.Slice(loops, func(i int, j int) bool {
+		return loops[i].Header.Index < loops[j].Header.Index
+	})
+
+	return loops
+}
+
+// computeDominators computes the reverse postorder and immediate dominators,
+// using the iterative algorithm of Cooper, Harvey, and Kennedy.
+func (c *CFG) computeDominators() {
+	c.idom = make([]*BasicBlock, len(c.Blocks))
+	c.order = nil
+
+	if len(c.Blocks) == 0 {
+		return
+	}
+
+	visited := make([]bool, len(c.Blocks))
+	var visit func(b *BasicBlock)
+
+	visit = func(b *BasicBlock) {
+		visited[b.Index] = true
+
+		for _, s := range b.Successors {
+			if !visited[s.Index] {
+				visit(s)
+			}
+		}
+
+		c.order = append(c.order, b)
+	}
+
+	visit(c.Entry())
+
+	for i, j := 0, len(c.order)-1; i < j; i, j = i+1, j-1 {
+		c.order[i], c.order[j] = c.order[j], c.order[i]
+	}
+
+	position := make([]int, len(c.Blocks))
+	for k, b := range c.order {
+		position[b.Index] = k
+	}
+
+	intersect := func(a *BasicBlock, b *BasicBlock) *BasicBlock {
+		for a != b {
+			for position[a.Index] > position[b.Index] {
+				a = c.idom[a.Index]
+			}
+
+			for position[b.Index] > position[a.Index] {
+				b = c.idom[b.Index]
+			}
+		}
+
+		return a
+	}
+
+	entry := c.Entry()
+	c.idom[entry.Index] = entry
+
+	for changed := true; changed; {
+		changed = false
+
+		for _, b := range c.order[1:] {
+			var idom *BasicBlock
+
+			for _, p := range b.Predecessors {
+				if c.idom[p.Index] == nil {
+					continue
+				}
+
+				if idom == nil {
+					idom = p
+				} else {
+					idom = intersect(p, idom)
+				}
+			}
+
+			if c.idom[b.Index] != idom {
+				c.idom[b.Index] = idom
+				changed = true
+			}
+		}
+	}
+}
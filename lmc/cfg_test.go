@@ -0,0 +1,221 @@
+package lmc
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// indices gives the index of each block, or -1 for nil.
+func indices(blocks ...*BasicBlock) []int {
+	l := make([]int, len(blocks))
+	for k, b := range blocks {
+		if b == nil {
+			l[k] = -1
+		} else {
+			l[k] = b.Index
+		}
+	}
+
+	return l
+}
+
+// TestCFG checks the blocks, edges, dominators and loops found in programs.
+// Blocks are given by their index; a missing immediate dominator is -1.
+func TestCFG(t *testing.T) {
+	sum, err := os.ReadFile(filepath.Join("testdata", "sum.lmc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type loop struct {
+		header  int
+		latches []int
+		blocks  []int
+	}
+
+	for _, c := range []struct {
+		name        string
+		text        string
+		starts      []int
+		successors  [][]int
+		exits       []int
+		idoms       []int
+		unreachable []int
+		loops       []loop
+	}{
+		{
+			name:       "sum.lmc",
+			text:       string(sum),
+			starts:     []int{0, 4, 11},
+			successors: [][]int{{1}, {1, 2}, {}},
+			exits:      []int{2},
+			idoms:      []int{-1, 0, 1},
+			loops:      []loop{{1, []int{1}, []int{1}}},
+		},
+		{
+			name: "nested",
+			text: `    INP
+    STA N
+l_A LDA N
+    BRZ l_C
+l_B SUB c_A
+    BRP l_B
+    LDA N
+    SUB c_A
+    STA N
+    BRA l_A
+    OUT
+l_C HLT
+
+N DAT 0
+c_A DAT 1
+`,
+			starts:      []int{0, 2, 4, 6, 10, 11},
+			successors:  [][]int{{1}, {5, 2}, {2, 3}, {1}, {5}, {}},
+			exits:       []int{5},
+			idoms:       []int{-1, 0, 1, 2, -1, 1},
+			unreachable: []int{4},
+			loops: []loop{
+				{1, []int{3}, []int{1, 2, 3}},
+				{2, []int{2}, []int{2}},
+			},
+		},
+		{
+			name: "falls off the end",
+			text: `    INP
+    BRZ l_A
+    OUT
+l_A OUT
+`,
+			starts:     []int{0, 2, 3},
+			successors: [][]int{{2, 1}, {2}, {}},
+			exits:      []int{2},
+			idoms:      []int{-1, 0, 0},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			prog, err := ParseString(c.text)
+			if err != nil {
+				t.Fatalf("could not parse: %s", err)
+			}
+
+			cfg, err := NewCFG(prog.Memory.InstructionsList)
+			if err != nil {
+				t.Fatalf("could not build: %s", err)
+			}
+
+			var starts, exits []int
+			var successors [][]int
+			var idoms []int
+
+			for k, b := range cfg.Blocks {
+				if b.Index != k {
+					t.Errorf("block %d has index %d", k, b.Index)
+				}
+
+				starts = append(starts, b.Start)
+				successors = append(successors, indices(b.Successors...))
+				idoms = append(idoms, indices(cfg.IDom(b))[0])
+
+				if b.Exit {
+					exits = append(exits, k)
+				}
+
+				for i := b.Start; i < b.End(); i++ {
+					if cfg.BlockOf(i) != b {
+						t.Errorf("instruction %d is not in block %d", i, k)
+					}
+				}
+
+				for _, s := range b.Successors {
+					if !containsBlock(s.Predecessors, b) {
+						t.Errorf("block %d is not a predecessor of its successor %d", k, s.Index)
+					}
+				}
+			}
+
+			if !reflect.DeepEqual(starts, c.starts) {
+				t.Errorf("blocks start at %v, want %v", starts, c.starts)
+			}
+
+			if !reflect.DeepEqual(successors, c.successors) {
+				t.Errorf("successors are %v, want %v", successors, c.successors)
+			}
+
+			if !reflect.DeepEqual(exits, c.exits) {
+				t.Errorf("exits are %v, want %v", exits, c.exits)
+			}
+
+			if !reflect.DeepEqual(idoms, c.idoms) {
+				t.Errorf("immediate dominators are %v, want %v", idoms, c.idoms)
+			}
+
+			var unreachable []int
+			for _, b := range cfg.Blocks {
+				if !cfg.Reachable(b) {
+					unreachable = append(unreachable, b.Index)
+				}
+			}
+
+			if !reflect.DeepEqual(unreachable, c.unreachable) {
+				t.Errorf("unreachable blocks are %v, want %v", unreachable, c.unreachable)
+			}
+
+			// Dominance follows the immediate dominators, and every block
+			// comes before those it dominates in reverse postorder.
+			order := cfg.ReversePostorder()
+			position := make(map[*BasicBlock]int, len(order))
+			for k, b := range order {
+				position[b] = k
+			}
+
+			for _, a := range order {
+				for _, b := range order {
+					want := a == b
+					for d := cfg.IDom(b); d != nil && !want; d = cfg.IDom(d) {
+						want = d == a
+					}
+
+					if got := cfg.Dominates(a, b); got != want {
+						t.Errorf("block %d dominates block %d: %t, want %t", a.Index, b.Index, got, want)
+					} else if got && position[a] > position[b] {
+						t.Errorf("block %d comes after block %d, which it dominates", a.Index, b.Index)
+					}
+				}
+			}
+
+			var loops []loop
+			for _, l := range cfg.NaturalLoops() {
+				loops = append(loops, loop{l.Header.Index, indices(l.Latches...), indices(l.Blocks...)})
+			}
+
+			if !reflect.DeepEqual(loops, c.loops) {
+				t.Errorf("loops are %v, want %v", loops, c.loops)
+			}
+		})
+	}
+}
+
+// TestCFGUnknownLabel checks that a branch to a label on no instruction is
+// rejected.
+func TestCFGUnknownLabel(t *testing.T) {
+	list := NewInstructionList()
+	list.AddInstruction(NewBranchInstr(BRAlways, NewLabel("l_Z")))
+	list.AddInstruction(NewHaltInstr())
+
+	if _, err := NewCFG(list); err == nil {
+		t.Error("built without an error")
+	}
+}
+
+func containsBlock(blocks []*BasicBlock, b *BasicBlock) bool {
+	for _, x := range blocks {
+		if x == b {
+			return true
+		}
+	}
+
+	return false
+}
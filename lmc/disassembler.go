@@ -0,0 +1,182 @@
+package lmc
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ---------- Disassembling ----------
+
+// Disassemble lifts a numeric memory image, such as a dump from a simulator,
+// back into a program. Code is found by following every path of execution from
+// mailbox 0; each branch target is given a label. Every mailbox used as the
+// parameter of an instruction is given a mailbox (with its original address)
+// and a data instruction holding its initial value; except that those never
+// stored to are registered as constants, which, as for *Memory#Constant, have
+// address -1, so the assembler places them. Words neither executed nor used
+// are dropped. As in a simulator, mailboxes past the end of the image hold 0,
+// i.e., `HLT`.
+//
+// Self-modifying code, i.e., instructions reading or writing mailboxes that are
+// also executed, cannot be represented and is an error; as is executing an
+// invalid instruction. Only the words in the image are followed, so the targets
+// of words stored and then executed as the program runs could not be found
+// anyway.
+func Disassemble(words []int) (*Program, error) {
+	digits := AddressDigits(len(words))
+	size := Address(WordModulus(digits) / 10)
+
+	word := func(addr Address) Value {
+		if int(addr) < len(words) {
+			return Value(words[addr])
+		}
+
+		return 0
+	}
+
+	code := make(map[Address]struct{})
+	targets := make(map[Address]struct{})
+	data := make(map[Address]struct{})
+	stored := make(map[Address]struct{})
+
+	for worklist := []Address{0}; len(worklist) > 0; {
+		addr := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+
+		for addr < size {
+			if _, ok := code[addr]; ok {
+				break
+			}
+
+			code[addr] = struct{}{}
+			op, param := Decode(word(addr), digits)
+			next := true
+
+			switch op {
+			case OpHalt:
+				next = false
+			case OpStore:
+				stored[param] = struct{}{}
+				data[param] = struct{}{}
+			case OpAdd, OpSub, OpLoad:
+				data[param] = struct{}{}
+			case OpBranchAlways:
+				next = false
+				fallthrough
+			case OpBranchZero, OpBranchPositive:
+				targets[param] = struct{}{}
+				worklist = append(worklist, param)
+			case OpIO:
+				if param != IOInput && param != IOOutput {
+					return nil, DisassemblyError(fmt.Errorf("invalid instruction %d at mailbox %d", word(addr), addr))
+				}
+			default:
+				return nil, DisassemblyError(fmt.Errorf("invalid instruction %d at mailbox %d", word(addr), addr))
+			}
+
+			if !next {
+				break
+			}
+
+			addr++
+		}
+	}
+
+	for addr := range data {
+		if _, ok := code[addr]; ok {
+			return nil, DisassemblyError(fmt.Errorf("mailbox %d is both executed and used as data", addr))
+		}
+	}
+
+	prog := NewProgram(NewBasicMemory())
+
+	// Mailboxes, in address order.
+
+	boxes := make(map[Address]*Mailbox, len(data))
+
+	for _, addr := range sortedAddresses(data) {
+		value := word(addr)
+		_, isStored := stored[addr]
+		_, isConstant := prog.Memory.constants[value]
+
+		var op *MemoryOp
+		if !isStored && !isConstant {
+			op = prog.Memory.Constant(value)
+		} else {
+			op = prog.Memory.NewMailbox(addr, "")
+			op.Boxes[0].Value = value
+		}
+
+		if err := prog.AddMemoryOp(op); err != nil {
+			return nil, DisassemblyError(err)
+		}
+
+		boxes[addr] = op.Boxes[0].Box
+	}
+
+	// Labels, in address order.
+
+	labels := make(map[Address]*Label, len(targets))
+
+	for _, addr := range sortedAddresses(targets) {
+		label, err := prog.NewLabel("")
+		if err != nil {
+			return nil, DisassemblyError(err)
+		}
+
+		labels[addr] = label
+	}
+
+	// Instructions, in address order.
+
+	for _, addr := range sortedAddresses(code) {
+		var instr Instruction
+		op, param := Decode(word(addr), digits)
+
+		switch op {
+		case OpHalt:
+			instr = NewHaltInstr()
+		case OpAdd:
+			instr = NewAddInstr(boxes[param])
+		case OpSub:
+			instr = NewSubInstr(boxes[param])
+		case OpStore:
+			instr = NewStoreInstr(boxes[param])
+		case OpLoad:
+			instr = NewLoadInstr(boxes[param])
+		case OpBranchAlways:
+			instr = NewBranchInstr(BRAlways, labels[param])
+		case OpBranchZero:
+			instr = NewBranchInstr(BRZero, labels[param])
+		case OpBranchPositive:
+			instr = NewBranchInstr(BRPositive, labels[param])
+		case OpIO:
+			if param == IOInput {
+				instr = NewInputInstr()
+			} else {
+				instr = NewOutputInstr()
+			}
+		}
+
+		if label, ok := labels[addr]; ok {
+			instr = NewLabelled(label, instr)
+		}
+
+		prog.AddInstructions([]Instruction{instr}, nil)
+	}
+
+	return prog, nil
+}
+
+func sortedAddresses(set map[Address]struct{}) []Address {
+	l := make([]Address, 0, len(set))
+	for addr := range set {
+		l = append(l, addr)
+	}
+
+	sort.Slice(l, func(i int, j int) bool {
+		return l[i] < l[j]
+	})
+
+	return l
+}
@@ -0,0 +1,90 @@
+package lmc
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestDisassembleRoundTrip assembles every fixture, disassembles the image,
+// and checks that assembling that again gives the same words; or, for those
+// with slots, that the self-modifying image is rejected.
+func TestDisassembleRoundTrip(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.lmc"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(paths) == 0 {
+		t.Fatal("no fixtures")
+	}
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			text, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			prog, err := ParseString(string(text))
+			if err != nil {
+				t.Fatalf("could not parse: %s", err)
+			}
+
+			image, err := prog.Assemble()
+			if err != nil {
+				t.Fatalf("could not assemble: %s", err)
+			}
+
+			words := make([]int, len(image.Words))
+			for i, w := range image.Words {
+				words[i] = int(w)
+			}
+
+			lifted, err := Disassemble(words)
+			if hasSlots(prog) {
+				if err == nil {
+					t.Errorf("disassembled self-modifying code without an error:\n%s", lifted)
+				}
+
+				return
+			} else if err != nil {
+				t.Fatalf("could not disassemble: %s", err)
+			}
+
+			again, err := lifted.Assemble()
+			if err != nil {
+				t.Fatalf("could not assemble the disassembly:\n%s\n%s", lifted, err)
+			}
+
+			if !reflect.DeepEqual(again.Words, image.Words) {
+				t.Errorf("disassembled into\n%s\nwhich assembles into\n%v\nwant\n%v", lifted, again.Words, image.Words)
+			}
+		})
+	}
+}
+
+// hasSlots reports whether a program has any slots, i.e., modifies itself.
+func hasSlots(prog *Program) bool {
+	for _, instr := range prog.Memory.InstructionsList.Instructions {
+		if _, ok := Unwrap(instr).(*SlotInstr); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TestDisassembleErrors checks that images that cannot be represented as a
+// program are rejected.
+func TestDisassembleErrors(t *testing.T) {
+	for name, words := range map[string][]int{
+		"invalid instruction": {901, 400, 0},
+		"invalid IO":          {903, 0},
+		"loads code":          {502, 902, 0},
+		"stores to code":      {901, 302, 0},
+	} {
+		if prog, err := Disassemble(words); err == nil {
+			t.Errorf("%s: disassembled without an error:\n%s", name, prog)
+		}
+	}
+}
@@ -49,6 +49,105 @@ func (s *InstructionList) RemoveInstruction(i int) error {
 	return nil
 }
 
+// RemoveInstructions removes the instructions at all the given indices. Unlike
+// RemoveInstruction, labels are kept: the label of a removed instruction moves
+// to the next instruction that is kept. If that instruction is already
+// labelled, branches to the removed label are changed to branch to its label.
+// It is an error to remove a labelled instruction with none kept after it.
+func (s *InstructionList) RemoveInstructions(indices []int) error {
+	remove := make(map[int]struct{}, len(indices))
+	last := -1
+
+	for _, i := range indices {
+		if i < 0 || i >= len(s.Instructions) {
+			return CannotRemoveInstructionIndexError(i, len(s.Instructions))
+		}
+
+		remove[i] = struct{}{}
+	}
+
+	for i := len(s.Instructions) - 1; i >= 0; i-- {
+		if _, ok := remove[i]; !ok {
+			last = i
+			break
+		}
+	}
+
+	for i := range remove {
+		if c, ok := s.Instructions[i].(*Labelled); ok && i > last {
+			return CannotMoveLabelError(c.Identifier())
+		}
+	}
+
+	var carried []*Label
+	retarget := make(map[string]*Label)
+	kept := make([]Instruction, 0, len(s.Instructions)-len(remove))
+
+	for i, instr := range s.Instructions {
+		if _, ok := remove[i]; ok {
+			if c, ok := instr.(*Labelled); ok {
+				carried = append(carried, c.Label())
+			}
+
+			continue
+		}
+
+		if len(carried) > 0 {
+			c, ok := instr.(*Labelled)
+			if !ok {
+				c = NewLabelled(carried[0], instr)
+				carried = carried[1:]
+			}
+
+			for _, l := range carried {
+				retarget[l.Identifier()] = c.Label()
+			}
+
+			instr = c
+			carried = nil
+		}
+
+		kept = append(kept, instr)
+	}
+
+	s.Instructions = kept
+	s.RetargetBranches(retarget)
+
+	return nil
+}
+
+// RetargetBranches changes every branch to a label, by identifier, in the map
+// to branch to the label it maps to; including the targets of slots, and the
+// words of branches held by data instructions.
+func (s *InstructionList) RetargetBranches(labels map[string]*Label) {
+	if len(labels) == 0 {
+		return
+	}
+
+	for _, instr := range s.Instructions {
+		switch c := Unwrap(instr).(type) {
+		case *BranchInstr:
+			if l, ok := labels[c.Identifier()]; ok {
+				c.label = l
+			}
+		case *SlotInstr:
+			for k, target := range c.Targets {
+				if l, ok := labels[target.Identifier()]; ok {
+					c.Targets[k] = l
+				}
+			}
+		}
+	}
+
+	for _, def := range s.DefInstructions {
+		if b, ok := def.Word.(*BranchInstr); ok {
+			if l, ok := labels[b.Identifier()]; ok {
+				b.label = l
+			}
+		}
+	}
+}
+
 func (s *InstructionList) AddDef(def *DataInstr) {
 	s.DefInstructions = append(s.DefInstructions, def)
 }
@@ -66,7 +165,7 @@ func (s *InstructionList) RemoveDef(identifier string) error {
 		}
 	}
 
-	if c != 0 {
+	if c == 0 {
 		return VariableDoesNotExistError(identifier)
 	} else {
 		for j := i; j < len(s.DefInstructions); j++ {
@@ -129,6 +228,17 @@ func (s *InstructionList) LMCString() string {
 	return buf.String()
 }
 
+// DataSize gives how many mailboxes the data instructions define, counting
+// every mailbox of an array.
+func (s *InstructionList) DataSize() int {
+	var n int
+	for _, def := range s.DefInstructions {
+		n += def.Size()
+	}
+
+	return n
+}
+
 func (s *InstructionList) String() string {
 	return fmt.Sprintf("InstructionList[%d,%d]", len(s.Instructions), len(s.DefInstructions))
 }
@@ -158,11 +268,20 @@ func (i *InstructionBase) Boxes() []*Mailbox {
 
 // ---------- Data instruction ----------
 
-// DataInstr handles the LMC data defining instruction `DAT`.
+// DataInstr handles the LMC data defining instruction `DAT`. If Word is set
+// the mailbox holds the word of that instruction instead of Data, e.g., for
+// self-modifying code to copy; see *Memory#Word.
+//
+// If Rest is set, Box is the first mailbox of an array, and Rest gives the
+// values of the mailboxes laid out after it. These have no identifiers of
+// their own, so are only accessed by self-modifying code adding an offset to
+// the word of an instruction using Box.
 type DataInstr struct {
 	InstructionBase
 	Data Value
+	Word Instruction
 	Box  *Mailbox
+	Rest []Value
 }
 
 func NewDataInstr(data Value, box *Mailbox) *DataInstr {
@@ -175,7 +294,35 @@ func NewDataInstr(data Value, box *Mailbox) *DataInstr {
 	}
 }
 
+// NewWordInstr creates a data instruction holding the word of an instruction.
+func NewWordInstr(word Instruction, box *Mailbox) *DataInstr {
+	i := NewDataInstr(0, box)
+	i.Word = word
+
+	return i
+}
+
+// NewArrayInstr creates a data instruction for an array of mailboxes, one for
+// each value given, of which there must be at least one.
+func NewArrayInstr(values []Value, box *Mailbox) *DataInstr {
+	i := NewDataInstr(values[0], box)
+	i.Rest = append([]Value{}, values[1:]...)
+
+	return i
+}
+
+// Size gives how many mailboxes are defined: 1, or the length of an array.
+func (i *DataInstr) Size() int {
+	return 1 + len(i.Rest)
+}
+
 func (i *DataInstr) String() string {
+	if i.Word != nil {
+		return formatInstrStr(i.Name(), []string{i.Word.LMCString(), i.Box.Identifier()})
+	} else if len(i.Rest) > 0 {
+		return formatInstrStr(i.Name(), []string{strconv.Itoa(int(i.Data)), i.Box.Identifier(), strconv.Itoa(i.Size())})
+	}
+
 	return formatInstrStr(i.Name(), []string{strconv.Itoa(int(i.Data)), i.Box.Identifier()})
 }
 
@@ -188,11 +335,66 @@ func (i *DataInstr) Boxes() []*Mailbox {
 }
 
 // Output form: `X DAT Y` where `X` is the box to be defined, and `Y` is the
-// initial value, usually 0.
+// initial value, usually 0; or `X Y` if it holds the word of instruction `Y`.
+// An array has a further unlabelled `DAT` line for each mailbox after the
+// first.
 //
-// E.g., `A DAT 0`.
+// E.g., `A DAT 0`, or `w_A BRA l_C`.
 func (i *DataInstr) LMCString() string {
-	return fmt.Sprintf("%s DAT %d", i.Box.Identifier(), i.Data)
+	if i.Word != nil {
+		return fmt.Sprintf("%s %s", i.Box.Identifier(), i.Word.LMCString())
+	}
+
+	var buf strings.Builder
+	_, _ = fmt.Fprintf(&buf, "%s DAT %d", i.Box.Identifier(), i.Data)
+
+	for _, v := range i.Rest {
+		_, _ = fmt.Fprintf(&buf, "\n%s DAT %d", strings.Repeat(" ", len(i.Box.Identifier())), v)
+	}
+
+	return buf.String()
+}
+
+// ---------- Slot instruction ----------
+
+// SlotInstr is a mailbox amongst the instructions that is both stored to and
+// executed, i.e., self-modifying code. It is labelled, and stored to through
+// the label's mailbox (see *Program#LabelMailbox). It holds Data, usually 0,
+// until an instruction word is stored.
+//
+// Targets are the labels of every branch that may be stored to it, so it is a
+// branch to any of them; with none, whatever is stored is presumed not to
+// branch, and execution continues after it.
+type SlotInstr struct {
+	InstructionBase
+	Data    Value
+	Targets []*Label
+}
+
+func NewSlotInstr(data Value, targets []*Label) *SlotInstr {
+	return &SlotInstr{
+		InstructionBase: InstructionBase{
+			name: "Slot",
+		},
+		Data:    data,
+		Targets: targets,
+	}
+}
+
+func (i *SlotInstr) String() string {
+	return formatInstrStr(i.Name(), []string{strconv.Itoa(int(i.Data))})
+}
+
+// The instruction stored is not known, so it may change the accumulator.
+func (i *SlotInstr) ACC() bool {
+	return true
+}
+
+// Output form: `DAT Y` where `Y` is the initial value, after the label.
+//
+// E.g., `l_D DAT 0`.
+func (i *SlotInstr) LMCString() string {
+	return fmt.Sprintf("DAT %d", i.Data)
 }
 
 // ---------- Labelled instruction ----------
@@ -215,6 +417,10 @@ func (m *Labelled) Identifier() string {
 	return m.label.Identifier()
 }
 
+func (m *Labelled) Label() *Label {
+	return m.label
+}
+
 // Output form: `X Y` where `X` is the identifier and `Y` is the lmc string form
 // of the underlying instruction being labelled.
 //
@@ -228,6 +434,16 @@ func (m *Labelled) ACC() bool {
 	return m.Instruction.ACC()
 }
 
+// Unwrap returns the instruction underneath any label, or the instruction
+// itself if it is not labelled.
+func Unwrap(instr Instruction) Instruction {
+	if c, ok := instr.(*Labelled); ok {
+		return c.Instruction
+	}
+
+	return instr
+}
+
 // ---------- Branch instruction ----------
 
 type BranchType uint
@@ -261,6 +477,10 @@ func (b *BranchInstr) Identifier() string {
 	return b.label.Identifier()
 }
 
+func (b *BranchInstr) Label() *Label {
+	return b.label
+}
+
 // Output form: `BR[A|P|Z] X` where `X` is the label to branch to.
 //
 // E.g., `BRZ l_A`.
@@ -0,0 +1,202 @@
+package lmc
+
+import (
+	"sort"
+)
+
+// ---------- LiveSet ----------
+
+// LiveSet is a set of mailboxes, by identifier, whose values may be read
+// before being overwritten.
+type LiveSet map[string]struct{}
+
+// Has returns true if the mailbox is in the set.
+func (s LiveSet) Has(box *Mailbox) bool {
+	_, ok := s[box.Identifier()]
+	return ok
+}
+
+// Identifiers gives every identifier in the set, sorted.
+func (s LiveSet) Identifiers() []string {
+	l := make([]string, 0, len(s))
+	for k := range s {
+		l = append(l, k)
+	}
+
+	sort.Strings(l)
+	return l
+}
+
+func (s LiveSet) copy() LiveSet {
+	c := make(LiveSet, len(s))
+	for k := range s {
+		c[k] = struct{}{}
+	}
+
+	return c
+}
+
+// ---------- Liveness ----------
+
+// Liveness is a backward analysis of which mailboxes are live, i.e., may be
+// read before being stored to, at each point of a CFG.
+//
+// Only mailboxes defined by a data instruction in the list's DefInstructions
+// are analysed, and none is live once the program exits. Every other mailbox
+// (e.g., one defined by a `DAT` amongst the instructions, which may be
+// executed) is presumed to be always live, see *Liveness#Tracked; as are
+// arrays, mailboxes holding words, and those used by the words, as
+// self-modifying code may access them.
+type Liveness struct {
+	cfg     *CFG
+	tracked map[string]struct{}
+	in      []LiveSet
+	out     []LiveSet
+}
+
+// NewLiveness analyses the CFG built from the given instruction list.
+func NewLiveness(cfg *CFG, list *InstructionList) *Liveness {
+	l := &Liveness{
+		cfg:     cfg,
+		tracked: make(map[string]struct{}, len(list.DefInstructions)),
+		in:      make([]LiveSet, len(cfg.Blocks)),
+		out:     make([]LiveSet, len(cfg.Blocks)),
+	}
+
+	for _, def := range list.DefInstructions {
+		l.tracked[def.Box.Identifier()] = struct{}{}
+	}
+
+	for _, instr := range list.Instructions {
+		if c, ok := Unwrap(instr).(*DataInstr); ok {
+			delete(l.tracked, c.Box.Identifier())
+		}
+	}
+
+	for _, def := range list.DefInstructions {
+		if len(def.Rest) > 0 {
+			delete(l.tracked, def.Box.Identifier())
+		}
+
+		if def.Word != nil {
+			delete(l.tracked, def.Box.Identifier())
+
+			for _, box := range def.Word.Boxes() {
+				delete(l.tracked, box.Identifier())
+			}
+		}
+	}
+
+	for k := range cfg.Blocks {
+		l.in[k] = make(LiveSet)
+		l.out[k] = make(LiveSet)
+	}
+
+	// blocks in postorder, so successors are mostly visited first
+	order := cfg.ReversePostorder()
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+
+	for _, b := range cfg.Blocks {
+		if !cfg.Reachable(b) {
+			order = append(order, b)
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		for _, b := range order {
+			out := make(LiveSet)
+			for _, s := range b.Successors {
+				for k := range l.in[s.Index] {
+					out[k] = struct{}{}
+				}
+			}
+
+			l.out[b.Index] = out
+
+			in := out.copy()
+			for i := len(b.Instructions) - 1; i >= 0; i-- {
+				l.transfer(in, b.Instructions[i])
+			}
+
+			if len(in) != len(l.in[b.Index]) {
+				l.in[b.Index] = in
+				changed = true
+			}
+		}
+	}
+
+	return l
+}
+
+// transfer updates the set to before an instruction, from after it.
+func (l *Liveness) transfer(live LiveSet, instr Instruction) {
+	switch c := Unwrap(instr).(type) {
+	case *StoreInstr:
+		if l.Tracked(c.Param) {
+			delete(live, c.Param.Identifier())
+		}
+	case *AddInstr, *SubInstr, *LoadInstr:
+		if box := c.Boxes()[0]; l.Tracked(box) {
+			live[box.Identifier()] = struct{}{}
+		}
+	}
+}
+
+// Tracked returns true if the mailbox is analysed. Any other mailbox is always
+// live.
+func (l *Liveness) Tracked(box *Mailbox) bool {
+	_, ok := l.tracked[box.Identifier()]
+	return ok
+}
+
+// LiveIn gives the mailboxes live at the start of a block. The set must not be
+// modified.
+func (l *Liveness) LiveIn(b *BasicBlock) LiveSet {
+	return l.in[b.Index]
+}
+
+// LiveOut gives the mailboxes live at the end of a block. The set must not be
+// modified.
+func (l *Liveness) LiveOut(b *BasicBlock) LiveSet {
+	return l.out[b.Index]
+}
+
+// LiveAfter gives, for every instruction of a block, the mailboxes live
+// directly after it.
+func (l *Liveness) LiveAfter(b *BasicBlock) []LiveSet {
+	sets := make([]LiveSet, len(b.Instructions))
+	live := l.out[b.Index].copy()
+
+	for i := len(b.Instructions) - 1; i >= 0; i-- {
+		sets[i] = live.copy()
+		l.transfer(live, b.Instructions[i])
+	}
+
+	return sets
+}
+
+// Live returns true if the mailbox is live in the set, or is not tracked and
+// so always live.
+func (l *Liveness) Live(set LiveSet, box *Mailbox) bool {
+	return !l.Tracked(box) || set.Has(box)
+}
+
+// LiveBefore gives the mailboxes live directly before the instruction at an
+// index of the instruction list. Past the last instruction, none are live.
+func (l *Liveness) LiveBefore(i int) LiveSet {
+	b := l.cfg.BlockOf(i)
+	if b == nil {
+		return make(LiveSet)
+	}
+
+	live := l.out[b.Index].copy()
+	for k := len(b.Instructions) - 1; k >= i-b.Start; k-- {
+		l.transfer(live, b.Instructions[k])
+	}
+
+	return live
+}
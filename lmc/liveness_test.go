@@ -0,0 +1,143 @@
+package lmc
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestLiveness checks the mailboxes live at the start and end of every block,
+// and after every instruction.
+func TestLiveness(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		text  string
+		in    [][]string
+		out   [][]string
+		after [][][]string
+	}{
+		{
+			// A is stored at the end of the loop and read only on the way
+			// round it, so is live at the end of the loop.
+			name: "loop",
+			text: `     INP
+     STA A
+     INP
+     STA A
+     STA B
+LOOP LDA A
+     OUT
+     SUB ONE
+     STA A
+     BRP LOOP
+     HLT
+
+A    DAT 0
+B    DAT 0
+ONE  DAT 1
+`,
+			in:  [][]string{{"ONE"}, {"A", "ONE"}, {}},
+			out: [][]string{{"A", "ONE"}, {"A", "ONE"}, {}},
+			after: [][][]string{
+				{{"ONE"}, {"ONE"}, {"ONE"}, {"A", "ONE"}, {"A", "ONE"}},
+				{{"ONE"}, {"ONE"}, {"ONE"}, {"A", "ONE"}, {"A", "ONE"}},
+				{{}},
+			},
+		},
+		{
+			// B is only read on one path, but is live before the branch.
+			name: "branch",
+			text: `    INP
+    STA A
+    INP
+    STA B
+    LDA A
+    BRZ l_A
+    LDA B
+    OUT
+l_A HLT
+
+A DAT 0
+B DAT 0
+`,
+			in:  [][]string{{}, {"B"}, {}},
+			out: [][]string{{"B"}, {}, {}},
+			after: [][][]string{
+				{{}, {"A"}, {"A"}, {"A", "B"}, {"B"}, {"B"}},
+				{{}, {}},
+				{{}},
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			prog, err := ParseString(c.text)
+			if err != nil {
+				t.Fatalf("could not parse: %s", err)
+			}
+
+			list := prog.Memory.InstructionsList
+
+			cfg, err := NewCFG(list)
+			if err != nil {
+				t.Fatalf("could not build: %s", err)
+			}
+
+			liveness := NewLiveness(cfg, list)
+
+			var in, out [][]string
+			var after [][][]string
+
+			for _, b := range cfg.Blocks {
+				in = append(in, liveness.LiveIn(b).Identifiers())
+				out = append(out, liveness.LiveOut(b).Identifiers())
+
+				var sets [][]string
+				for _, s := range liveness.LiveAfter(b) {
+					sets = append(sets, s.Identifiers())
+				}
+
+				after = append(after, sets)
+			}
+
+			if !reflect.DeepEqual(in, c.in) {
+				t.Errorf("live in %v, want %v", in, c.in)
+			}
+
+			if !reflect.DeepEqual(out, c.out) {
+				t.Errorf("live out %v, want %v", out, c.out)
+			}
+
+			if !reflect.DeepEqual(after, c.after) {
+				t.Errorf("live after %v, want %v", after, c.after)
+			}
+		})
+	}
+}
+
+// TestLivenessUntracked checks that a mailbox not defined after the program
+// is always live.
+func TestLivenessUntracked(t *testing.T) {
+	prog, err := ParseString("    INP\n    STA A\n    HLT\n\nA DAT 0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := prog.Memory.InstructionsList
+	box := NewMailbox(-1, "X")
+	list.AddInstruction(NewStoreInstr(box))
+
+	cfg, err := NewCFG(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	liveness := NewLiveness(cfg, list)
+	end := liveness.LiveOut(cfg.Blocks[0])
+
+	if liveness.Tracked(box) || !liveness.Live(end, box) {
+		t.Errorf("`X' is tracked %t, live %t; want untracked and live", liveness.Tracked(box), liveness.Live(end, box))
+	}
+
+	if a := list.DefInstructions[0].Box; !liveness.Tracked(a) || liveness.Live(end, a) {
+		t.Errorf("`A' is tracked %t, live %t; want tracked and not live", liveness.Tracked(a), liveness.Live(end, a))
+	}
+}
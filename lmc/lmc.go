@@ -1,7 +1,8 @@
 // Package lmc allows interaction with Little Man Computer, programmatically.
-// It is restricted to static analyses and optimisations, and does not parse
-// text-form LMC. (Note: this package is simple enough that it doesn't check for
-// non-trivial things such as >100 mailboxes.)
+// It is restricted to static analyses and optimisations, and the parsing of
+// text-form LMC (see Parse). The 100 mailbox limit of classic LMC is only
+// checked once a program is laid out, against *Program#Capacity (see
+// *Program#CheckCapacity).
 //
 // # Advisory note
 //
@@ -32,9 +33,30 @@ var (
 	CannotRemoveInstructionIndexError = func(a int, b int) error {
 		return fmt.Errorf("cannot remove instruction index %d out of %d", a, b)
 	}
+	CannotMoveLabelError = func(identifier string) error {
+		return fmt.Errorf("cannot move label `%s' as no instruction follows it", identifier)
+	}
 	VariableDoesNotExistError = func(name string) error {
 		return fmt.Errorf("variable `%s` does not exist", name)
 	}
+	UnknownMnemonicError = func(mnemonic string) error {
+		return fmt.Errorf("unknown mnemonic `%s'", mnemonic)
+	}
+	UnknownMailboxError = func(identifier string) error {
+		return fmt.Errorf("unknown mailbox `%s'", identifier)
+	}
+	UnknownLabelError = func(identifier string) error {
+		return fmt.Errorf("unknown label `%s'", identifier)
+	}
+	ParseLineError = func(line int, child error) error {
+		return fmt.Errorf("could not parse line %d: %s", line, child)
+	}
+	AssemblyError = func(child error) error {
+		return fmt.Errorf("could not assemble program: %s", child)
+	}
+	DisassemblyError = func(child error) error {
+		return fmt.Errorf("could not disassemble memory: %s", child)
+	}
 )
 
 type LMCType interface {
@@ -70,10 +70,10 @@ func makeIdentifierGenerator() func(int) string {
 			s := buf.String()
 			buf.Reset()
 
-			buf.WriteRune(identifierSymbols[i%26])
+			buf.WriteRune(identifierSymbols[i%len(identifierSymbols)])
 			buf.WriteString(s)
 
-			if i /= 26; i == 0 {
+			if i /= len(identifierSymbols); i == 0 {
 				break
 			}
 		}
@@ -86,11 +86,12 @@ func makeIdentifierGenerator() func(int) string {
 
 // MemoryOpBoxPair is a pair of a mailbox and a flag for if it is a new box.
 // It also contains a value, for if this is an initialised box (i.e., non-zero
-// initial value).
+// initial value), or an instruction if it holds its word instead.
 type MemoryOpBoxPair struct {
 	Box   *Mailbox
 	New   bool
 	Value Value
+	Word  Instruction
 }
 
 // MemoryOpLabelPair is a pair of a label and a flag for if it is a new label.
@@ -191,11 +192,13 @@ func (m *MemoryOp) GetNewLabels() []*Label {
 }
 
 // Defs creates a new slice with data instructions for all new boxes, with their
-// values, if initialised.
+// values, if initialised, or words.
 func (m *MemoryOp) Defs() []*DataInstr {
 	var l []*DataInstr
 	for _, p := range m.Boxes {
-		if p.New {
+		if p.New && p.Word != nil {
+			l = append(l, NewWordInstr(p.Word, p.Box))
+		} else if p.New {
 			l = append(l, NewDataInstr(p.Value, p.Box))
 		}
 	}
@@ -219,6 +222,7 @@ type Memory struct {
 	InstructionsList *InstructionList
 	labels           []*Label
 	constants        map[Value]*Mailbox
+	words            map[string]*Mailbox
 	idGen            func(int) string
 }
 
@@ -228,6 +232,7 @@ func NewMemory(idGen func(int) string) *Memory {
 		InstructionsList: NewInstructionList(),
 		labels:           make([]*Label, 0),
 		constants:        make(map[Value]*Mailbox, 0),
+		words:            make(map[string]*Mailbox, 0),
 		idGen:            idGen,
 	}
 }
@@ -274,6 +279,18 @@ func (m *Memory) GetLabel(identifier string) *Label {
 	return nil
 }
 
+// IsConstant returns true if the given mailbox is one created by
+// *Memory#Constant (or registered as such).
+func (m *Memory) IsConstant(mailbox *Mailbox) bool {
+	for _, v := range m.constants {
+		if v == mailbox {
+			return true
+		}
+	}
+
+	return false
+}
+
 // AddMailbox will try to add a given mailbox to the memory; returning an error
 // if the address or identifier are already in use.
 func (m *Memory) AddMailbox(mailbox *Mailbox) error {
@@ -289,10 +306,23 @@ func (m *Memory) AddMailbox(mailbox *Mailbox) error {
 	return nil
 }
 
-// RemoveMailboxIdentifier will remove all mailboxes with the given identifier.
+// RemoveMailboxIdentifier will remove all mailboxes with the given identifier,
+// including constants and words.
 func (m *Memory) RemoveMailboxIdentifier(identifier string) bool {
 	var i, c int
 
+	for k, v := range m.constants {
+		if v.Identifier() == identifier {
+			delete(m.constants, k)
+		}
+	}
+
+	for k, v := range m.words {
+		if v.Identifier() == identifier {
+			delete(m.words, k)
+		}
+	}
+
 	for _, b := range m.Mailboxes {
 		if b.Identifier() != identifier {
 			m.Mailboxes[i] = b
@@ -330,6 +360,8 @@ func (m *Memory) RemoveMailboxAddress(address Address) bool {
 		for j := i; j < len(m.Mailboxes); j++ {
 			m.Mailboxes[j] = nil
 		}
+
+		m.Mailboxes = m.Mailboxes[:i]
 	}
 
 	return c > 0
@@ -360,12 +392,15 @@ func (m *Memory) NewMailbox(addr Address, identifier string) *MemoryOp {
 }
 
 // NewLabel creates a new label with a given identifier. If the identifier is
-// the empty string one is generated using the generator function.
+// the empty string one is generated using the generator function, skipping any
+// already taken; a given identifier is kept, so AddLabel reports a collision.
 //
 // This returns a memory operation. See advisory note in overview.
 func (m *Memory) NewLabel(identifier string) *MemoryOp {
 	if identifier == "" {
-		identifier = "l_" + m.idGen(len(m.labels))
+		for i := len(m.labels); identifier == "" || m.GetLabel(identifier) != nil; i++ {
+			identifier = "l_" + m.idGen(i)
+		}
 	}
 
 	label := NewLabel(identifier)
@@ -381,7 +416,12 @@ func (m *Memory) Constant(value Value) *MemoryOp {
 	if v, ok := m.constants[value]; ok {
 		return NewMemoryOpBox1(v, false)
 	} else {
-		identifier := "c_" + m.idGen(len(m.constants))
+		var identifier string
+
+		// parsed programs may already use generated-looking identifiers
+		for i := len(m.constants); identifier == "" || m.GetMailboxIdentifier(identifier) != nil; i++ {
+			identifier = "c_" + m.idGen(i)
+		}
 
 		op := m.NewMailbox(-1, identifier)
 		box := op.Boxes[0]
@@ -392,3 +432,28 @@ func (m *Memory) Constant(value Value) *MemoryOp {
 		return op
 	}
 }
+
+// Word returns a mailbox holding the word of an instruction, for self-modifying
+// code to copy or add to (e.g., a branch to store in a slot, see SlotInstr). If
+// one does not exist for an instruction of the same text form, it is created.
+// These have an auto generated identifier, prefixed with 'w_', and id -1.
+//
+// This returns a memory operation. See advisory note in overview.
+func (m *Memory) Word(instr Instruction) *MemoryOp {
+	key := instr.LMCString()
+
+	if v, ok := m.words[key]; ok {
+		return NewMemoryOpBox1(v, false)
+	}
+
+	var identifier string
+	for i := len(m.words); identifier == "" || m.GetMailboxIdentifier(identifier) != nil; i++ {
+		identifier = "w_" + m.idGen(i)
+	}
+
+	op := m.NewMailbox(-1, identifier)
+	op.Boxes[0].Word = instr
+	m.words[key] = op.Boxes[0].Box
+
+	return op
+}
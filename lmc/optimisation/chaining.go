@@ -0,0 +1,210 @@
+package optimisation
+
+import (
+	"fmt"
+	"github.com/clr1107/lmc-llvm-target/lmc"
+)
+
+// DefaultChainLength is the longest chain of `ADD`s OChaining creates by
+// default: a multiplication loop is 14 instructions, so no longer than that.
+const DefaultChainLength = 10
+
+var chainStageNames = [...]string{
+	"CHAIN_MUL",
+}
+
+func chainErr(stage int, child error) error {
+	return fmt.Errorf("chaining failed stage %d=%s: %s", stage, chainStageNames[stage], child)
+}
+
+// mulLoop is a multiplication by repeated addition, as the compiler creates
+// for `mul` by a constant; from index start to end, setting Dst to X times Y:
+//
+//	    LDA X
+//	    STA C
+//	    SUB C
+//	    STA Dst
+//	L   LDA Dst
+//	    ADD Y
+//	    STA Dst
+//	    LDA C
+//	    SUB ONE
+//	    STA C
+//	    BRP L
+//	    LDA Dst
+//	    SUB Y
+//	    STA Dst
+type mulLoop struct {
+	start   int
+	end     int
+	x       *lmc.Mailbox
+	y       *lmc.Mailbox
+	dst     *lmc.Mailbox
+	counter *lmc.Mailbox
+}
+
+const mulLoopLength = 14
+
+// matchMulLoop matches a multiplication loop starting at an index. Only the
+// first instruction and the loop may be labelled, and nothing else may branch
+// to the loop.
+func matchMulLoop(prog *lmc.Program, i int) (*mulLoop, bool) {
+	instrs := prog.Memory.InstructionsList.Instructions
+	if i+mulLoopLength > len(instrs) {
+		return nil, false
+	}
+
+	for k := i + 1; k < i+mulLoopLength; k++ {
+		if _, ok := instrs[k].(*lmc.Labelled); ok != (k == i+4) {
+			return nil, false
+		}
+	}
+
+	m := &mulLoop{start: i, end: i + mulLoopLength}
+	m.x = operand(instrs[i], opLoad)
+	m.counter = operand(instrs[i+1], opStore)
+	m.dst = operand(instrs[i+4], opLoad)
+	m.y = operand(instrs[i+5], opAdd)
+	one := operand(instrs[i+8], opSub)
+
+	if m.x == nil || m.counter == nil || m.dst == nil || m.y == nil || one == nil {
+		return nil, false
+	}
+
+	if v, ok := constantValue(prog, one); !ok || v != 1 {
+		return nil, false
+	}
+
+	same := func(k int, op unaryOp, box *lmc.Mailbox) bool {
+		x := operand(instrs[k], op)
+		return x != nil && x.Identifier() == box.Identifier()
+	}
+
+	if !same(i+2, opSub, m.counter) || !same(i+3, opStore, m.dst) || !same(i+6, opStore, m.dst) ||
+		!same(i+7, opLoad, m.counter) || !same(i+9, opStore, m.counter) || !same(i+11, opLoad, m.dst) ||
+		!same(i+12, opSub, m.y) || !same(i+13, opStore, m.dst) {
+		return nil, false
+	}
+
+	br, ok := instrs[i+10].(*lmc.BranchInstr)
+	if !ok || br.BranchType != lmc.BRPositive || br.Identifier() != instrs[i+4].(*lmc.Labelled).Identifier() {
+		return nil, false
+	}
+
+	if branchesTo(prog, br.Label()) != 1 {
+		return nil, false
+	}
+
+	// the counter must be distinct, and the result must not be an operand
+	identifiers := map[string]struct{}{}
+	for _, b := range []*lmc.Mailbox{m.counter, m.dst, m.x, m.y} {
+		identifiers[b.Identifier()] = struct{}{}
+	}
+
+	if len(identifiers) != 4 && !(len(identifiers) == 3 && m.x.Identifier() == m.y.Identifier()) {
+		return nil, false
+	}
+
+	return m, true
+}
+
+// chain_mul replaces multiplication loops where either operand is a constant,
+// no greater than the maximum length, by a chain of `ADD`s of the other:
+//
+//	LDA Y
+//	ADD Y    ; X - 1 times, if X is the constant
+//	STA Dst
+//
+// Or, if the constant is 0, by loading it and storing it in Dst.
+//
+// The counter must not be live after the loop, as the chain does not count;
+// nor the negative flag, which the last `SUB` of the loop may set. Returns
+// true if any loop was replaced.
+func chain_mul(prog *lmc.Program, maxLength int) (bool, error) {
+	changed := false
+
+	for i := 0; i < len(prog.Memory.InstructionsList.Instructions); i++ {
+		m, ok := matchMulLoop(prog, i)
+		if !ok {
+			continue
+		}
+
+		addend := m.y
+		n, ok := constantValue(prog, m.x)
+
+		if !ok || n < 0 || int(n) > maxLength {
+			addend = m.x
+			if n, ok = constantValue(prog, m.y); !ok || n < 0 || int(n) > maxLength {
+				continue
+			}
+		}
+
+		list := prog.Memory.InstructionsList
+
+		cfg, err := lmc.NewCFG(list)
+		if err != nil {
+			return changed, err
+		}
+
+		if liveness := lmc.NewLiveness(cfg, list); liveness.Live(liveness.LiveBefore(m.end), m.counter) {
+			continue
+		} else if accLiveBefore(cfg, accLiveness(cfg), m.end).flag {
+			continue
+		}
+
+		var instrs []lmc.Instruction
+		if n == 0 {
+			zero := m.x
+			if addend == m.x {
+				zero = m.y
+			}
+
+			instrs = append(instrs, lmc.NewLoadInstr(zero))
+		} else {
+			instrs = append(instrs, lmc.NewLoadInstr(addend))
+			for k := 1; k < int(n); k++ {
+				instrs = append(instrs, lmc.NewAddInstr(addend))
+			}
+		}
+
+		instrs = append(instrs, lmc.NewStoreInstr(m.dst))
+		replaceInstructions(prog, m.start, m.end, instrs)
+
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// ---------- OChaining ----------
+
+// OChaining replaces multiplication loops by a constant with chains of `ADD`s,
+// of no more than MaxLength. This is always faster, and no larger as long as
+// MaxLength is at most 10.
+type OChaining struct {
+	program   *lmc.Program
+	MaxLength int
+}
+
+func NewOChaining(program *lmc.Program, maxLength int) *OChaining {
+	return &OChaining{
+		program:   program,
+		MaxLength: maxLength,
+	}
+}
+
+func (o *OChaining) Strategy() OStrategy {
+	return Chaining
+}
+
+func (o *OChaining) Optimise() error {
+	if _, err := chain_mul(o.program, o.MaxLength); err != nil {
+		return chainErr(0, err)
+	}
+
+	return nil
+}
+
+func (o *OChaining) Program() *lmc.Program {
+	return o.program
+}
@@ -0,0 +1,79 @@
+package optimisation
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/clr1107/lmc-llvm-target/lmc"
+)
+
+// mulLoopProgram multiplies the input by c_C as the compiler does, then runs
+// the given tail.
+func mulLoopProgram(c lmc.Value, tail string) string {
+	return `    INP
+    STA X
+    LDA c_C
+    STA C
+    SUB C
+    STA D
+l_A LDA D
+    ADD X
+    STA D
+    LDA C
+    SUB c_O
+    STA C
+    BRP l_A
+    LDA D
+    SUB X
+    STA D
+` + tail + `
+X DAT 0
+C DAT 0
+D DAT 0
+c_C DAT ` + strconv.Itoa(int(c)) + `
+c_O DAT 1
+`
+}
+
+func TestChaining(t *testing.T) {
+	testPasses(t, []passTest{
+		{
+			name:   "constant",
+			before: mulLoopProgram(3, "    LDA D\n    OUT\n    HLT\n"),
+			after: `    INP
+    STA X
+    LDA X
+    ADD X
+    ADD X
+    STA D
+    LDA D
+    OUT
+    HLT
+
+X DAT 0
+C DAT 0
+D DAT 0
+c_C DAT 3
+c_O DAT 1
+`,
+			inputs: [][]lmc.Value{{0}, {7}, {-4}},
+		},
+		{
+			// The chain would be longer than the default threshold.
+			name:   "too long",
+			before: mulLoopProgram(11, "    LDA D\n    OUT\n    HLT\n"),
+			after:  mulLoopProgram(11, "    LDA D\n    OUT\n    HLT\n"),
+			inputs: [][]lmc.Value{{2}},
+		},
+		{
+			// The counter is read after the loop, which the chain does not
+			// count down.
+			name:   "counter read",
+			before: mulLoopProgram(3, "    LDA C\n    OUT\n    HLT\n"),
+			after:  mulLoopProgram(3, "    LDA C\n    OUT\n    HLT\n"),
+			inputs: [][]lmc.Value{{5}},
+		},
+	}, func(prog *lmc.Program) error {
+		return NewOChaining(prog, DefaultChainLength).Optimise()
+	})
+}
@@ -23,31 +23,46 @@ func clean_dead_box(prog *lmc.Program) error {
 		}
 	}
 
+	// words may be copied into slots, so what they use is used
+	for _, def := range prog.Memory.InstructionsList.DefInstructions {
+		if def.Word != nil {
+			for _, box := range def.Word.Boxes() {
+				used[box.Identifier()] = struct{}{}
+			}
+		}
+	}
+
 	var ok bool
+	var dead []string
 
+	// removing defs shifts the slice, so collect them first
 	for _, def := range prog.Memory.InstructionsList.DefInstructions {
 		if _, ok = used[def.Box.Identifier()]; !ok {
-			_ = prog.Memory.InstructionsList.RemoveDef(def.Box.Identifier()) // ignore error
-			prog.Memory.RemoveMailboxIdentifier(def.Box.Identifier())
+			dead = append(dead, def.Box.Identifier())
 		}
 	}
 
+	for _, identifier := range dead {
+		_ = prog.Memory.InstructionsList.RemoveDef(identifier) // ignore error
+		prog.Memory.RemoveMailboxIdentifier(identifier)
+	}
+
 	return nil
 }
 
 func clean_multi_dat(prog *lmc.Program) error {
-	seen := make(map[lmc.Address]struct{})
+	seen := make(map[string]struct{})
 	instrs := prog.Memory.InstructionsList.DefInstructions
 
 	var ok bool
 	var i int
 
 	for _, ii := range instrs {
-		if _, ok = seen[ii.Box.Address()]; !ok {
+		if _, ok = seen[ii.Box.Identifier()]; !ok {
 			instrs[i] = ii
 			i++
 
-			seen[ii.Box.Address()] = struct{}{}
+			seen[ii.Box.Identifier()] = struct{}{}
 		}
 	}
 
@@ -0,0 +1,191 @@
+package optimisation
+
+import (
+	"fmt"
+	"github.com/clr1107/lmc-llvm-target/lmc"
+	"sort"
+)
+
+var coalesceStageNames = [...]string{
+	"COALESCE_INTERFERENCE",
+	"COALESCE_MERGE",
+}
+
+func coalesceErr(stage int, child error) error {
+	return fmt.Errorf("coalescing failed stage %d=%s: %s", stage, coalesceStageNames[stage], child)
+}
+
+// ---------- Interference ----------
+
+// interference is an undirected graph of mailboxes, by identifier, that cannot
+// share a mailbox, as one is stored to whilst the other is live.
+type interference map[string]map[string]struct{}
+
+func (g interference) add(a string, b string) {
+	if a == b {
+		return
+	}
+
+	for _, x := range [...][2]string{{a, b}, {b, a}} {
+		if g[x[0]] == nil {
+			g[x[0]] = make(map[string]struct{})
+		}
+
+		g[x[0]][x[1]] = struct{}{}
+	}
+}
+
+func (g interference) interferes(a string, b string) bool {
+	_, ok := g[a][b]
+	return ok
+}
+
+// coalesce_interference finds the mailboxes that may be merged, and the graph
+// of those that interfere. A mailbox may be merged if it is analysed by
+// lmc.Liveness, is not a constant, and its initial value is never read, i.e.,
+// it is not live at the start of the program. The candidates are ordered by
+// their data instructions.
+func coalesce_interference(prog *lmc.Program) ([]*lmc.Mailbox, interference, error) {
+	list := prog.Memory.InstructionsList
+
+	cfg, err := lmc.NewCFG(list)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	liveness := lmc.NewLiveness(cfg, list)
+	var entry lmc.LiveSet
+
+	if b := cfg.Entry(); b != nil {
+		entry = liveness.LiveIn(b)
+	}
+
+	var candidates []*lmc.Mailbox
+
+	for _, def := range list.DefInstructions {
+		if liveness.Tracked(def.Box) && !prog.Memory.IsConstant(def.Box) && !entry.Has(def.Box) {
+			candidates = append(candidates, def.Box)
+		}
+	}
+
+	g := make(interference)
+
+	for _, b := range cfg.Blocks {
+		after := liveness.LiveAfter(b)
+
+		for k, instr := range b.Instructions {
+			if c, ok := lmc.Unwrap(instr).(*lmc.StoreInstr); ok {
+				for identifier := range after[k] {
+					g.add(c.Param.Identifier(), identifier)
+				}
+			}
+		}
+	}
+
+	return candidates, g, nil
+}
+
+// coalesce_merge greedily colours the candidates, most interfering first: each
+// is merged into the first mailbox chosen that it does not interfere with, nor
+// do any of those merged into it. Instructions are rewritten to use the chosen
+// mailboxes and the rest are removed. Returns true if any were merged.
+func coalesce_merge(prog *lmc.Program, candidates []*lmc.Mailbox, g interference) bool {
+	order := make([]*lmc.Mailbox, len(candidates))
+	copy(order, candidates)
+
+	sort.SliceStable(order, func(i int, j int) bool {
+		return len(g[order[i].Identifier()]) > len(g[order[j].Identifier()])
+	})
+
+	var chosen []*lmc.Mailbox
+	members := make(map[*lmc.Mailbox][]string)
+	merged := make(map[string]*lmc.Mailbox)
+
+next:
+	for _, box := range order {
+		for _, c := range chosen {
+			ok := true
+
+			for _, m := range members[c] {
+				if g.interferes(m, box.Identifier()) {
+					ok = false
+					break
+				}
+			}
+
+			if ok {
+				members[c] = append(members[c], box.Identifier())
+				merged[box.Identifier()] = c
+				continue next
+			}
+		}
+
+		chosen = append(chosen, box)
+		members[box] = []string{box.Identifier()}
+	}
+
+	if len(merged) == 0 {
+		return false
+	}
+
+	for _, instr := range prog.Memory.InstructionsList.Instructions {
+		switch c := lmc.Unwrap(instr).(type) {
+		case *lmc.AddInstr:
+			if x, ok := merged[c.Param.Identifier()]; ok {
+				c.Param = x
+			}
+		case *lmc.SubInstr:
+			if x, ok := merged[c.Param.Identifier()]; ok {
+				c.Param = x
+			}
+		case *lmc.StoreInstr:
+			if x, ok := merged[c.Param.Identifier()]; ok {
+				c.Param = x
+			}
+		case *lmc.LoadInstr:
+			if x, ok := merged[c.Param.Identifier()]; ok {
+				c.Param = x
+			}
+		}
+	}
+
+	// in data instruction order, so the output is stable
+	for _, box := range candidates {
+		if _, ok := merged[box.Identifier()]; ok {
+			_ = prog.Memory.InstructionsList.RemoveDef(box.Identifier()) // exists, as a candidate
+			prog.Memory.RemoveMailboxIdentifier(box.Identifier())
+		}
+	}
+
+	return true
+}
+
+// ---------- OCoalesce ----------
+
+type OCoalesce struct {
+	program *lmc.Program
+}
+
+func NewOCoalesce(program *lmc.Program) *OCoalesce {
+	return &OCoalesce{
+		program: program,
+	}
+}
+
+func (o *OCoalesce) Strategy() OStrategy {
+	return Coalesce
+}
+
+func (o *OCoalesce) Optimise() error {
+	candidates, g, err := coalesce_interference(o.program)
+	if err != nil {
+		return coalesceErr(0, err)
+	}
+
+	_ = coalesce_merge(o.program, candidates, g)
+	return nil
+}
+
+func (o *OCoalesce) Program() *lmc.Program {
+	return o.program
+}
@@ -0,0 +1,126 @@
+package optimisation
+
+import (
+	"testing"
+
+	"github.com/clr1107/lmc-llvm-target/lmc"
+)
+
+func TestCoalesce(t *testing.T) {
+	testPasses(t, []passTest{
+		{
+			// The example of OPTIMISATION.md.
+			name: "share",
+			before: `    INP
+    STA A
+    ADD A
+    STA B
+    OUT
+    INP
+    STA C
+    SUB B
+    OUT
+    HLT
+
+A DAT 0
+B DAT 0
+C DAT 0
+`,
+			after: `    INP
+    STA B
+    ADD B
+    STA B
+    OUT
+    INP
+    STA C
+    SUB B
+    OUT
+    HLT
+
+B DAT 0
+C DAT 0
+`,
+			inputs: [][]lmc.Value{{3, 10}},
+		},
+		{
+			// The initial value of S is read at LOOP, so it is left alone. T is
+			// only needed after the loop, when N no longer is, so shares N.
+			name: "loop",
+			before: `     INP
+     STA N
+LOOP LDA S
+     ADD N
+     STA S
+     LDA N
+     SUB ONE
+     STA N
+     BRP LOOP
+     LDA S
+     STA T
+     OUT
+     LDA T
+     OUT
+     HLT
+
+N    DAT 0
+S    DAT 0
+T    DAT 0
+ONE  DAT 1
+`,
+			after: `     INP
+     STA N
+LOOP LDA S
+     ADD N
+     STA S
+     LDA N
+     SUB ONE
+     STA N
+     BRP LOOP
+     LDA S
+     STA N
+     OUT
+     LDA N
+     OUT
+     HLT
+
+N    DAT 0
+S    DAT 0
+ONE  DAT 1
+`,
+			inputs: [][]lmc.Value{{0}, {4}},
+		},
+		{
+			// The initial value of S is read, and c_A is a constant, so
+			// neither may share; even with X, which is stored only once both
+			// have last been read.
+			name: "initial values",
+			before: `    LDA S
+    ADD c_A
+    STA X
+    OUT
+    LDA X
+    OUT
+    HLT
+
+S DAT 5
+X DAT 0
+c_A DAT 1
+`,
+			after: `    LDA S
+    ADD c_A
+    STA X
+    OUT
+    LDA X
+    OUT
+    HLT
+
+S DAT 5
+X DAT 0
+c_A DAT 1
+`,
+			inputs: [][]lmc.Value{{}},
+		},
+	}, func(prog *lmc.Program) error {
+		return NewOCoalesce(prog).Optimise()
+	})
+}
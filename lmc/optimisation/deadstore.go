@@ -0,0 +1,71 @@
+package optimisation
+
+import (
+	"fmt"
+	"github.com/clr1107/lmc-llvm-target/lmc"
+)
+
+var deadStoreStageNames = [...]string{
+	"DEAD_STORE",
+}
+
+func deadStoreErr(stage int, child error) error {
+	return fmt.Errorf("dead store elimination failed stage %d=%s: %s", stage, deadStoreStageNames[stage], child)
+}
+
+// dead_store removes stores to mailboxes that are not live after them, i.e.,
+// whose value is overwritten, or the program exits, on every path before it
+// is read. See lmc.Liveness.
+func dead_store(prog *lmc.Program) (bool, error) {
+	list := prog.Memory.InstructionsList
+
+	cfg, err := lmc.NewCFG(list)
+	if err != nil {
+		return false, err
+	}
+
+	liveness := lmc.NewLiveness(cfg, list)
+	var remove []int
+
+	for _, b := range cfg.Blocks {
+		after := liveness.LiveAfter(b)
+
+		for k, instr := range b.Instructions {
+			if c, ok := lmc.Unwrap(instr).(*lmc.StoreInstr); ok && !liveness.Live(after[k], c.Param) {
+				remove = append(remove, b.Start+k)
+			}
+		}
+	}
+
+	return removeInstructions(prog, remove)
+}
+
+// ---------- ODeadStore ----------
+
+type ODeadStore struct {
+	program *lmc.Program
+}
+
+func NewODeadStore(program *lmc.Program) *ODeadStore {
+	return &ODeadStore{
+		program: program,
+	}
+}
+
+func (o *ODeadStore) Strategy() OStrategy {
+	return DeadStore
+}
+
+// Optimise removes every dead store. No more become dead by doing so, so one
+// pass is enough; mailboxes no longer used are left for OClean.
+func (o *ODeadStore) Optimise() error {
+	if _, err := dead_store(o.program); err != nil {
+		return deadStoreErr(0, err)
+	}
+
+	return nil
+}
+
+func (o *ODeadStore) Program() *lmc.Program {
+	return o.program
+}
@@ -0,0 +1,118 @@
+package optimisation
+
+import (
+	"testing"
+
+	"github.com/clr1107/lmc-llvm-target/lmc"
+)
+
+func TestDeadStore(t *testing.T) {
+	testPasses(t, []passTest{
+		{
+			// The example of OPTIMISATION.md: `STA A` in the loop is read
+			// only on the way round it, so is kept.
+			name: "loop",
+			before: `     INP
+     STA A
+     INP
+     STA A
+     STA B
+LOOP LDA A
+     OUT
+     SUB ONE
+     STA A
+     BRP LOOP
+     HLT
+
+A    DAT 0
+B    DAT 0
+ONE  DAT 1
+`,
+			after: `     INP
+     INP
+     STA A
+LOOP LDA A
+     OUT
+     SUB ONE
+     STA A
+     BRP LOOP
+     HLT
+
+A    DAT 0
+B    DAT 0
+ONE  DAT 1
+`,
+			inputs: [][]lmc.Value{{1, 3}, {5, 0}},
+		},
+		{
+			// T is stored at the end of the loop and never read after it, but
+			// is read at the top of the loop on the way round.
+			name: "loop carried store",
+			before: `     INP
+     STA N
+LOOP LDA T
+     OUT
+     ADD ONE
+     STA T
+     LDA N
+     SUB ONE
+     STA N
+     BRP LOOP
+     HLT
+
+N    DAT 0
+T    DAT 0
+ONE  DAT 1
+`,
+			after: `     INP
+     STA N
+LOOP LDA T
+     OUT
+     ADD ONE
+     STA T
+     LDA N
+     SUB ONE
+     STA N
+     BRP LOOP
+     HLT
+
+N    DAT 0
+T    DAT 0
+ONE  DAT 1
+`,
+			inputs: [][]lmc.Value{{0}, {3}},
+		},
+		{
+			// B is read on one path only, and A on neither after it is
+			// stored again.
+			name: "branch",
+			before: `    INP
+    STA A
+    STA B
+    BRZ l_A
+    LDA B
+    OUT
+l_A INP
+    STA A
+    HLT
+
+A DAT 0
+B DAT 0
+`,
+			after: `    INP
+    STA B
+    BRZ l_A
+    LDA B
+    OUT
+l_A INP
+    HLT
+
+A DAT 0
+B DAT 0
+`,
+			inputs: [][]lmc.Value{{0, 1}, {4, 1}},
+		},
+	}, func(prog *lmc.Program) error {
+		return NewODeadStore(prog).Optimise()
+	})
+}
@@ -15,6 +15,8 @@ const (
 	Chaining
 	Unroll
 	Stacking
+	DeadStore
+	Coalesce
 )
 
 var OStrategyNames = map[OStrategy]string{
@@ -24,6 +26,8 @@ var OStrategyNames = map[OStrategy]string{
 	Chaining:  "ADD_CHAIN",
 	Unroll:    "UROLL",
 	Stacking:  "OSTACK",
+	DeadStore: "DEAD_STORE",
+	Coalesce:  "COALESCE",
 }
 
 type Optimiser interface {
@@ -32,15 +36,22 @@ type Optimiser interface {
 	Strategy() OStrategy
 }
 
+// StackingOptimiser runs each strategy in turn, cleaning after each. The
+// thresholds of Chaining and Unroll default to DefaultChainLength and
+// DefaultUnrollLength.
 type StackingOptimiser struct {
-	program    *lmc.Program
-	strategies []OStrategy
+	program      *lmc.Program
+	strategies   []OStrategy
+	ChainLength  int
+	UnrollLength int
 }
 
 func NewStackingOptimiser(program *lmc.Program, strategies []OStrategy) *StackingOptimiser {
 	return &StackingOptimiser{
-		program:    program,
-		strategies: strategies,
+		program:      program,
+		strategies:   strategies,
+		ChainLength:  DefaultChainLength,
+		UnrollLength: DefaultUnrollLength,
 	}
 }
 
@@ -52,6 +63,14 @@ func (o *StackingOptimiser) createStrategy(s OStrategy) Optimiser {
 		return NewOClean(o.program)
 	case BProp:
 		return NewOProp(o.program)
+	case Chaining:
+		return NewOChaining(o.program, o.ChainLength)
+	case Unroll:
+		return NewOUnroll(o.program, o.UnrollLength)
+	case DeadStore:
+		return NewODeadStore(o.program)
+	case Coalesce:
+		return NewOCoalesce(o.program)
 	default:
 		return nil
 	}
@@ -87,3 +106,128 @@ func (o *StackingOptimiser) Program() *lmc.Program {
 func (o *StackingOptimiser) Strategy() OStrategy {
 	return Stacking
 }
+
+// removeInstructions removes the instructions at the given indices, keeping
+// their labels. See *lmc.InstructionList#RemoveInstructions. A labelled
+// instruction with none kept after it is not removed, as its label would have
+// nowhere to go. Returns true if anything was removed.
+func removeInstructions(prog *lmc.Program, indices []int) (bool, error) {
+	list := prog.Memory.InstructionsList
+	remove := make(map[int]struct{}, len(indices))
+
+	for _, i := range indices {
+		remove[i] = struct{}{}
+	}
+
+	for i := len(list.Instructions) - 1; i >= 0; i-- {
+		if _, ok := remove[i]; !ok {
+			break
+		} else if _, ok = list.Instructions[i].(*lmc.Labelled); ok {
+			delete(remove, i)
+			break
+		}
+	}
+
+	if len(remove) == 0 {
+		return false, nil
+	}
+
+	l := make([]int, 0, len(remove))
+	for i := range remove {
+		l = append(l, i)
+	}
+
+	return true, list.RemoveInstructions(l)
+}
+
+// replaceInstructions replaces the instructions from index i up to j with the
+// given instructions. The label of the instruction at i, if any, is kept on the
+// first of them; there must be one to keep it. Any other labels in the range
+// are dropped, so must no longer be branched to.
+func replaceInstructions(prog *lmc.Program, i int, j int, instrs []lmc.Instruction) {
+	list := prog.Memory.InstructionsList
+
+	if c, ok := list.Instructions[i].(*lmc.Labelled); ok {
+		instrs[0] = lmc.NewLabelled(c.Label(), lmc.Unwrap(instrs[0]))
+	}
+
+	l := make([]lmc.Instruction, 0, len(list.Instructions)-(j-i)+len(instrs))
+	l = append(l, list.Instructions[:i]...)
+	l = append(l, instrs...)
+	l = append(l, list.Instructions[j:]...)
+
+	list.Instructions = l
+}
+
+// constantValue gives the value of a constant mailbox, from its data
+// instruction. False is returned if the mailbox is not a constant.
+func constantValue(prog *lmc.Program, box *lmc.Mailbox) (lmc.Value, bool) {
+	if !prog.Memory.IsConstant(box) {
+		return 0, false
+	}
+
+	for _, def := range prog.Memory.InstructionsList.DefInstructions {
+		if def.Box.Identifier() == box.Identifier() {
+			return def.Data, true
+		}
+	}
+
+	return 0, false
+}
+
+// branchesTo counts the branches to a label, including slots that may branch
+// to it.
+func branchesTo(prog *lmc.Program, label *lmc.Label) int {
+	var c int
+
+	for _, instr := range prog.Memory.InstructionsList.Instructions {
+		switch x := lmc.Unwrap(instr).(type) {
+		case *lmc.BranchInstr:
+			if x.Identifier() == label.Identifier() {
+				c++
+			}
+		case *lmc.SlotInstr:
+			for _, target := range x.Targets {
+				if target.Identifier() == label.Identifier() {
+					c++
+				}
+			}
+		}
+	}
+
+	return c
+}
+
+type unaryOp int
+
+const (
+	opLoad unaryOp = iota
+	opStore
+	opAdd
+	opSub
+)
+
+// operand gives the mailbox of an instruction, ignoring any label, if it is
+// the given unary instruction. Nil otherwise.
+func operand(instr lmc.Instruction, op unaryOp) *lmc.Mailbox {
+	switch c := lmc.Unwrap(instr).(type) {
+	case *lmc.LoadInstr:
+		if op == opLoad {
+			return c.Param
+		}
+	case *lmc.StoreInstr:
+		if op == opStore {
+			return c.Param
+		}
+	case *lmc.AddInstr:
+		if op == opAdd {
+			return c.Param
+		}
+	case *lmc.SubInstr:
+		if op == opSub {
+			return c.Param
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package optimisation
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/clr1107/lmc-llvm-target/lmc"
+	"github.com/clr1107/lmc-llvm-target/lmc/vm"
+)
+
+// passTest is a fixture for an optimisation: the program before and after it,
+// and the inputs to run both with.
+type passTest struct {
+	name   string
+	before string
+	after  string
+	inputs [][]lmc.Value
+}
+
+// run runs a program with each set of inputs, giving the outputs of each. The
+// program must halt every time.
+func run(t *testing.T, prog *lmc.Program, inputs [][]lmc.Value) [][]lmc.Value {
+	t.Helper()
+
+	outputs := make([][]lmc.Value, len(inputs))
+
+	for k, in := range inputs {
+		r, err := vm.Run(prog, vm.Config{Input: vm.Inputs(in...)})
+		if err != nil {
+			t.Fatalf("inputs %v: could not run:\n%s\n%s", in, prog, err)
+		} else if !r.Halted {
+			t.Fatalf("inputs %v: did not halt:\n%s", in, prog)
+		}
+
+		outputs[k] = r.SignedOutputs()
+	}
+
+	return outputs
+}
+
+// testPasses parses each fixture and optimises it, checking that it gives the
+// instructions expected and that it outputs the same as it did before.
+func testPasses(t *testing.T, tests []passTest, optimise func(*lmc.Program) error) {
+	t.Helper()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prog, err := lmc.ParseString(test.before)
+			if err != nil {
+				t.Fatalf("could not parse: %s", err)
+			}
+
+			want, err := lmc.ParseString(test.after)
+			if err != nil {
+				t.Fatalf("could not parse the program expected: %s", err)
+			}
+
+			before := run(t, prog, test.inputs)
+
+			if err = optimise(prog); err != nil {
+				t.Fatalf("could not optimise: %s", err)
+			}
+
+			if prog.String() != want.String() {
+				t.Errorf("optimised into\n%s\nwant\n%s", prog, want)
+			}
+
+			if after := run(t, prog, test.inputs); !reflect.DeepEqual(after, before) {
+				t.Errorf("outputs %v once optimised, %v before", after, before)
+			}
+		})
+	}
+}
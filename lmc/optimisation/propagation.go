@@ -6,7 +6,7 @@ import (
 )
 
 var propStageNames = [...]string{
-	"PROP_TREE",
+	"PROP_COPIES",
 	"PROP_LDA_STA",
 }
 
@@ -14,80 +14,190 @@ func propErr(stage int, child error) error {
 	return fmt.Errorf("box propogation failed stage %d=%s: %s", stage, propStageNames[stage], child)
 }
 
-// ---------- prop_tree ----------
+// ---------- prop_copies ----------
+
+// copyState is what must be true on every path to a point: which mailboxes,
+// by identifier, hold a copy of another, as `LDA X; STA Y` made Y a copy of X
+// and neither has been stored to since; and the mailbox the accumulator was
+// loaded from, if it still holds its value. Top is the state of a point not
+// yet reached.
+type copyState struct {
+	copies map[string]*lmc.Mailbox
+	acc    *lmc.Mailbox
+	top    bool
+}
+
+func (s *copyState) copy() *copyState {
+	c := &copyState{copies: make(map[string]*lmc.Mailbox, len(s.copies)), acc: s.acc, top: s.top}
+	for k, v := range s.copies {
+		c.copies[k] = v
+	}
 
-type node struct {
-	box      *lmc.Mailbox
-	children []*node
-	root     bool
+	return c
 }
 
-func (n *node) add(from *lmc.Mailbox, to *lmc.Mailbox) bool {
-	if (!n.root && n.box == nil && from == nil) || (n.box != nil && n.box.Address() == from.Address()) {
-		n.children = append(n.children, &node{box: to})
-		return true
+// meet keeps the copies made on both paths; the accumulator is not kept, as
+// blocks are only joined at labels.
+func (s *copyState) meet(o *copyState) *copyState {
+	if s.top {
+		c := o.copy()
+		c.acc = nil
+		return c
+	} else if o.top {
+		c := s.copy()
+		c.acc = nil
+		return c
 	}
 
-	for _, c := range n.children {
-		if c.add(from, to) {
-			return true
+	c := &copyState{copies: make(map[string]*lmc.Mailbox)}
+	for k, v := range s.copies {
+		if x, ok := o.copies[k]; ok && x.Identifier() == v.Identifier() {
+			c.copies[k] = v
 		}
 	}
 
-	if n.root {
-		n.children = append(n.children, &node{box: from, children: []*node{{box: to}}})
-		return true
+	return c
+}
+
+func (s *copyState) equals(o *copyState) bool {
+	if s.top != o.top || len(s.copies) != len(o.copies) {
+		return false
 	}
 
-	return false
+	for k, v := range s.copies {
+		if x, ok := o.copies[k]; !ok || x.Identifier() != v.Identifier() {
+			return false
+		}
+	}
+
+	return true
 }
 
-func (n *node) propagate(x *lmc.Mailbox, p *lmc.Program) {
-	instrs := p.Memory.InstructionsList.Instructions
+// source gives the mailbox a mailbox is a copy of, or itself.
+func (s *copyState) source(box *lmc.Mailbox) *lmc.Mailbox {
+	if x, ok := s.copies[box.Identifier()]; ok {
+		return x
+	}
 
-	if !n.root && n.box != nil && x != nil {
-		for _, i := range instrs {
-			if len(i.Boxes()) != 0 {
-				if i.Boxes()[0].Address() == n.box.Address() {
-					*i.Boxes()[0] = *x
-				}
+	return box
+}
+
+// transfer updates the state to after an instruction. Only mailboxes analysed
+// by the liveness are copied, as any other may be changed other than by a
+// store.
+func (s *copyState) transfer(instr lmc.Instruction, liveness *lmc.Liveness) {
+	switch c := lmc.Unwrap(instr).(type) {
+	case *lmc.LoadInstr:
+		s.acc = s.source(c.Param)
+	case *lmc.StoreInstr:
+		for k, v := range s.copies {
+			if k == c.Param.Identifier() || v.Identifier() == c.Param.Identifier() {
+				delete(s.copies, k)
 			}
 		}
+
+		if s.acc != nil && s.acc.Identifier() != c.Param.Identifier() &&
+			liveness.Tracked(s.acc) && liveness.Tracked(c.Param) {
+			s.copies[c.Param.Identifier()] = s.acc
+		}
+	case *lmc.OutputInstr, *lmc.BranchInstr, *lmc.HaltInstr:
+	default:
+		s.acc = nil
+	}
+}
+
+// copyStates gives the state at the start of every block. The program starts
+// with no copies.
+func copyStates(cfg *lmc.CFG, liveness *lmc.Liveness) []*copyState {
+	in := make([]*copyState, len(cfg.Blocks))
+	for k := range in {
+		in[k] = &copyState{top: true}
 	}
 
-	for _, c := range n.children {
-		c.propagate(n.box, p)
+	if len(in) == 0 {
+		return in
 	}
+
+	entry := &copyState{copies: make(map[string]*lmc.Mailbox)}
+
+	for changed := true; changed; {
+		changed = false
+
+		for _, b := range cfg.ReversePostorder() {
+			s := &copyState{top: true}
+			if b == cfg.Entry() {
+				s = entry
+			}
+
+			for _, p := range b.Predecessors {
+				if !cfg.Reachable(p) {
+					continue
+				}
+
+				out := in[p.Index].copy()
+				if !out.top {
+					for _, instr := range p.Instructions {
+						out.transfer(instr, liveness)
+					}
+				}
+
+				s = s.meet(out)
+			}
+
+			if !s.equals(in[b.Index]) {
+				in[b.Index] = s
+				changed = true
+			}
+		}
+	}
+
+	return in
 }
 
-func prop_tree(prog *lmc.Program) error {
-	root := node{root: true}
+// prop_copies replaces the operand of every `LDA`, `ADD` and `SUB` with the
+// mailbox it is a copy of, on every path to it. The copies' stores may then be
+// dead. Returns true if any operand was replaced.
+func prop_copies(prog *lmc.Program) (bool, error) {
+	list := prog.Memory.InstructionsList
 
-	instrs := prog.Memory.InstructionsList.Instructions
-	for k, instr := range instrs {
-		var ok bool
+	cfg, err := lmc.NewCFG(list)
+	if err != nil {
+		return false, err
+	}
+
+	liveness := lmc.NewLiveness(cfg, list)
+	states := copyStates(cfg, liveness)
+	changed := false
 
-		if _, ok = instr.(*lmc.StoreInstr); !ok {
+	for _, b := range cfg.Blocks {
+		s := states[b.Index]
+		if s.top {
 			continue
 		}
 
-		for kk := k - 1; kk >= 0; kk-- {
-			i := instrs[kk]
-
-			if _, ok := i.(*lmc.LoadInstr); ok {
-				root.add(i.Boxes()[0], instr.Boxes()[0])
-				break
-			} else if _, ok = i.(*lmc.InputInstr); ok {
-				root.add(nil, instr.Boxes()[0])
-				break
-			} else if i.ACC() {
-				break
+		s = s.copy()
+
+		for _, instr := range b.Instructions {
+			switch c := lmc.Unwrap(instr).(type) {
+			case *lmc.LoadInstr:
+				if x := s.source(c.Param); x.Identifier() != c.Param.Identifier() {
+					c.Param, changed = x, true
+				}
+			case *lmc.AddInstr:
+				if x := s.source(c.Param); x.Identifier() != c.Param.Identifier() {
+					c.Param, changed = x, true
+				}
+			case *lmc.SubInstr:
+				if x := s.source(c.Param); x.Identifier() != c.Param.Identifier() {
+					c.Param, changed = x, true
+				}
 			}
+
+			s.transfer(instr, liveness)
 		}
 	}
 
-	root.propagate(nil, prog)
-	return nil
+	return changed, nil
 }
 
 // ---------- prop_lda_sta ----------
@@ -114,7 +224,7 @@ func prop_lda_sta(prog *lmc.Program) error {
 		}
 
 		if ok {
-			if instrs[i].Boxes()[0].Address() != instrs[previous].Boxes()[0].Address() {
+			if instrs[i].Boxes()[0].Identifier() != instrs[previous].Boxes()[0].Identifier() {
 				previous = -1
 				continue
 			}
@@ -158,11 +268,11 @@ func (o *OProp) Strategy() OStrategy {
 }
 
 func (o *OProp) Optimise() error {
-	var err error
-
-	_ = prop_tree(o.program) // currently returns no err
+	if _, err := prop_copies(o.program); err != nil {
+		return propErr(0, err)
+	}
 
-	if err = prop_lda_sta(o.program); err != nil {
+	if err := prop_lda_sta(o.program); err != nil {
 		return propErr(1, err)
 	}
 
@@ -0,0 +1,92 @@
+package optimisation
+
+import (
+	"testing"
+
+	"github.com/clr1107/lmc-llvm-target/lmc"
+)
+
+func TestBProp(t *testing.T) {
+	testPasses(t, []passTest{
+		{
+			// The example of OPTIMISATION.md.
+			name: "copies",
+			before: `    INP
+    STA A
+    INP
+    STA B
+    LDA A
+    STA D
+    LDA B
+    STA E
+    LDA D
+    SUB E
+    STA C
+    OUT
+    HLT
+
+A DAT 0
+B DAT 0
+C DAT 0
+D DAT 0
+E DAT 0
+`,
+			after: `    INP
+    STA A
+    INP
+    STA B
+    LDA A
+    STA D
+    LDA B
+    STA E
+    LDA A
+    SUB B
+    STA C
+    OUT
+    HLT
+
+A DAT 0
+B DAT 0
+C DAT 0
+D DAT 0
+E DAT 0
+`,
+			inputs: [][]lmc.Value{{7, 2}},
+		},
+		{
+			// D is a copy of A on one path to l_A only, so is kept there.
+			name: "copy on one path",
+			before: `    INP
+    STA A
+    INP
+    STA D
+    BRZ l_A
+    LDA A
+    STA D
+l_A LDA D
+    OUT
+    HLT
+
+A DAT 0
+D DAT 0
+`,
+			after: `    INP
+    STA A
+    INP
+    STA D
+    BRZ l_A
+    LDA A
+    STA D
+l_A LDA D
+    OUT
+    HLT
+
+A DAT 0
+D DAT 0
+`,
+			inputs: [][]lmc.Value{{3, 0}, {3, 4}},
+		},
+	}, func(prog *lmc.Program) error {
+		return NewOProp(prog).Optimise()
+	})
+}
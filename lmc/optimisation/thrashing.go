@@ -14,102 +14,291 @@ func thrashErr(stage int, child error) error {
 	return fmt.Errorf("thrashing failed stage %d=%s: %s", stage, thrashStageNames[stage], child)
 }
 
-func thrash_mul_load(prog *lmc.Program) error {
-	// Ugly... this entire function is being redone soon. But, it works.
-	instrs := prog.Memory.InstructionsList.Instructions
-	previous := -1
+// ---------- Accumulator liveness ----------
 
-	for i, removed := 0, 0; i < len(instrs); i++ {
+// accLive is whether the value of the accumulator, and of the negative flag,
+// may be read before being overwritten.
+type accLive struct {
+	acc  bool
+	flag bool
+}
 
-		if _, ok := instrs[i-removed].(*lmc.LoadInstr); !ok {
-			continue
+// before gives the liveness before an instruction, given the liveness after.
+func (l accLive) before(instr lmc.Instruction) accLive {
+	switch c := lmc.Unwrap(instr).(type) {
+	case *lmc.LoadInstr, *lmc.InputInstr, *lmc.HaltInstr:
+		return accLive{}
+	case *lmc.AddInstr, *lmc.SubInstr:
+		return accLive{acc: true}
+	case *lmc.StoreInstr, *lmc.OutputInstr:
+		return accLive{acc: true, flag: l.flag}
+	case *lmc.BranchInstr:
+		switch c.BranchType {
+		case lmc.BRZero:
+			return accLive{acc: true, flag: l.flag}
+		case lmc.BRPositive:
+			return accLive{acc: l.acc, flag: true}
+		default:
+			return l
 		}
+	default:
+		return accLive{acc: true, flag: true}
+	}
+}
 
-		if previous == -1 {
-			previous = i
-			continue
-		}
+// accLiveness gives the liveness at the end of every block.
+func accLiveness(cfg *lmc.CFG) []accLive {
+	in := make([]accLive, len(cfg.Blocks))
+	out := make([]accLive, len(cfg.Blocks))
+	order := cfg.ReversePostorder()
+
+	for changed := true; changed; {
+		changed = false
 
-		var acc bool
+		for k := len(order) - 1; k >= 0; k-- {
+			b := order[k]
+			var l accLive
 
-		for j := previous + 1; j < i; j++ {
-			if instrs[j-removed].ACC() {
-				acc = true
-				break
+			for _, s := range b.Successors {
+				l.acc = l.acc || in[s.Index].acc
+				l.flag = l.flag || in[s.Index].flag
 			}
-		}
 
-		if !acc {
-			if err := prog.Memory.InstructionsList.RemoveInstruction(previous - removed); err != nil {
-				return err
-			} else {
-				removed++
-				previous = i
+			out[b.Index] = l
+
+			for i := len(b.Instructions) - 1; i >= 0; i-- {
+				l = l.before(b.Instructions[i])
+			}
+
+			if l != in[b.Index] {
+				in[b.Index] = l
+				changed = true
 			}
 		}
+	}
 
+	return out
+}
+
+// accLiveBefore gives the liveness directly before the instruction at an index,
+// given the liveness at the end of every block. Past the last instruction,
+// nothing is live.
+func accLiveBefore(cfg *lmc.CFG, live []accLive, i int) accLive {
+	b := cfg.BlockOf(i)
+	if b == nil {
+		return accLive{}
 	}
 
-	return nil
+	l := live[b.Index]
+	for k := len(b.Instructions) - 1; k >= i-b.Start; k-- {
+		l = l.before(b.Instructions[k])
+	}
+
+	return l
 }
 
-func thrash_pairs(prog *lmc.Program) error {
-	previous := -1
+// ---------- Accumulator state ----------
 
-	instrs := make([]lmc.Instruction, len(prog.Memory.InstructionsList.Instructions))
-	copy(instrs, prog.Memory.InstructionsList.Instructions)
+// accState is what must be true of the accumulator at a point on every path
+// to it: which mailboxes, by identifier, hold the same value, and whether the
+// negative flag is clear. Top is the state of a point not yet reached.
+type accState struct {
+	boxes map[string]struct{}
+	clear bool
+	top   bool
+}
 
-	for i, removed := 0, 0; i < len(instrs); i++ {
-		var ok bool
+func (s *accState) copy() *accState {
+	c := &accState{boxes: make(map[string]struct{}, len(s.boxes)), clear: s.clear, top: s.top}
+	for k := range s.boxes {
+		c.boxes[k] = struct{}{}
+	}
 
-		_, ok = instrs[i].(*lmc.StoreInstr)
-		if !ok {
-			_, ok = instrs[i].(*lmc.LoadInstr)
+	return c
+}
+
+func (s *accState) has(box *lmc.Mailbox) bool {
+	_, ok := s.boxes[box.Identifier()]
+	return ok
+}
+
+func (s *accState) meet(o *accState) *accState {
+	if s.top {
+		return o.copy()
+	} else if o.top {
+		return s.copy()
+	}
+
+	c := &accState{boxes: make(map[string]struct{}), clear: s.clear && o.clear}
+	for k := range s.boxes {
+		if _, ok := o.boxes[k]; ok {
+			c.boxes[k] = struct{}{}
+		}
+	}
+
+	return c
+}
+
+func (s *accState) equals(o *accState) bool {
+	if s.top != o.top || s.clear != o.clear || len(s.boxes) != len(o.boxes) {
+		return false
+	}
+
+	for k := range s.boxes {
+		if _, ok := o.boxes[k]; !ok {
+			return false
 		}
+	}
+
+	return true
+}
+
+// transfer updates the state to after an instruction.
+func (s *accState) transfer(instr lmc.Instruction) {
+	switch c := lmc.Unwrap(instr).(type) {
+	case *lmc.LoadInstr:
+		if !s.has(c.Param) {
+			s.boxes = map[string]struct{}{c.Param.Identifier(): {}}
+		}
+
+		s.clear = true
+	case *lmc.StoreInstr:
+		s.boxes[c.Param.Identifier()] = struct{}{}
+	case *lmc.InputInstr, *lmc.AddInstr:
+		s.boxes = make(map[string]struct{})
+		s.clear = true
+	case *lmc.OutputInstr, *lmc.BranchInstr, *lmc.HaltInstr:
+	default:
+		s.boxes = make(map[string]struct{})
+		s.clear = false
+	}
+}
+
+// accStates gives the state at the start of every block. The program starts
+// with nothing known but a clear flag.
+func accStates(cfg *lmc.CFG) []*accState {
+	in := make([]*accState, len(cfg.Blocks))
+	for k := range in {
+		in[k] = &accState{top: true}
+	}
+
+	if len(in) == 0 {
+		return in
+	}
+
+	entry := &accState{boxes: make(map[string]struct{}), clear: true}
+
+	for changed := true; changed; {
+		changed = false
 
-		if ok {
-			if previous != -1 && (instrs[i].Boxes()[0].Address() != instrs[previous].Boxes()[0].Address()) {
-				previous = -1
+		for _, b := range cfg.ReversePostorder() {
+			s := &accState{top: true}
+			if b == cfg.Entry() {
+				s = entry
 			}
 
-			if i == len(instrs)-1 {
-				if err := prog.Memory.InstructionsList.RemoveInstruction(i - removed); err != nil {
-					return err
-				} else {
-					removed++
+			for _, p := range b.Predecessors {
+				if !cfg.Reachable(p) {
+					continue
+				}
+
+				out := in[p.Index].copy()
+				if !out.top {
+					for _, instr := range p.Instructions {
+						out.transfer(instr)
+					}
+
+					// a taken `BRP` means the flag is clear; unless it
+					// branches to the very next block, as does not taking it
+					if br, ok := p.Last().(*lmc.BranchInstr); ok && br.BranchType == lmc.BRPositive &&
+						b.Label() != nil && b.Label().Identifier() == br.Identifier() && p.End() != b.Start {
+						out.clear = true
+					}
 				}
 
-				break
+				s = s.meet(out)
 			}
 
-			if previous == -1 {
-				previous = i
-			} else {
-				remove := true
+			if !s.equals(in[b.Index]) {
+				in[b.Index] = s
+				changed = true
+			}
+		}
+	}
 
-				for j := previous + 1; j < i; j++ {
-					if instrs[j].ACC() {
-						remove = false
-						break
-					}
+	return in
+}
+
+// ---------- Stages ----------
+
+// thrash_mul_load removes loads, and additions and subtractions, whose result
+// (including the negative flag) is never used on any path after them.
+func thrash_mul_load(prog *lmc.Program) (bool, error) {
+	cfg, err := lmc.NewCFG(prog.Memory.InstructionsList)
+	if err != nil {
+		return false, err
+	}
+
+	live := accLiveness(cfg)
+	var remove []int
+
+	for _, b := range cfg.ReversePostorder() {
+		l := live[b.Index]
+
+		for k := len(b.Instructions) - 1; k >= 0; k-- {
+			switch lmc.Unwrap(b.Instructions[k]).(type) {
+			case *lmc.LoadInstr, *lmc.AddInstr, *lmc.SubInstr:
+				if !l.acc && !l.flag {
+					remove = append(remove, b.Start+k)
+					continue
 				}
+			}
 
-				if remove {
-					if err := prog.Memory.InstructionsList.RemoveInstruction(i - removed); err != nil {
-						return err
-					} else {
-						removed++
-					}
-				} else {
-					previous = i
+			l = l.before(b.Instructions[k])
+		}
+	}
+
+	return removeInstructions(prog, remove)
+}
+
+// thrash_pairs removes loads of a mailbox that the accumulator already holds
+// the value of, with a clear flag, and stores to a mailbox that already holds
+// the value of the accumulator; on every path to them.
+func thrash_pairs(prog *lmc.Program) (bool, error) {
+	cfg, err := lmc.NewCFG(prog.Memory.InstructionsList)
+	if err != nil {
+		return false, err
+	}
+
+	in := accStates(cfg)
+	var remove []int
+
+	for _, b := range cfg.ReversePostorder() {
+		s := in[b.Index].copy()
+
+		for k, instr := range b.Instructions {
+			switch c := lmc.Unwrap(instr).(type) {
+			case *lmc.LoadInstr:
+				if s.has(c.Param) && s.clear {
+					remove = append(remove, b.Start+k)
+					continue
+				}
+			case *lmc.StoreInstr:
+				if s.has(c.Param) {
+					remove = append(remove, b.Start+k)
+					continue
 				}
 			}
+
+			s.transfer(instr)
 		}
 	}
 
-	return nil
+	return removeInstructions(prog, remove)
 }
 
+// ---------- OThrashing ----------
+
 type OThrashing struct {
 	program *lmc.Program
 }
@@ -124,13 +313,20 @@ func (o *OThrashing) Strategy() OStrategy {
 	return Thrashing
 }
 
+// Optimise runs both stages, in order, until neither removes anything.
 func (o *OThrashing) Optimise() error {
-	var err error
+	for changed := true; changed; {
+		c1, err := thrash_mul_load(o.program)
+		if err != nil {
+			return thrashErr(0, err)
+		}
+
+		c2, err := thrash_pairs(o.program)
+		if err != nil {
+			return thrashErr(1, err)
+		}
 
-	if err = thrash_mul_load(o.program); err != nil {
-		return thrashErr(0, err)
-	} else if err = thrash_pairs(o.program); err != nil {
-		return thrashErr(1, err)
+		changed = c1 || c2
 	}
 
 	return nil
@@ -0,0 +1,169 @@
+package optimisation
+
+import (
+	"testing"
+
+	"github.com/clr1107/lmc-llvm-target/lmc"
+)
+
+func TestThrashing(t *testing.T) {
+	testPasses(t, []passTest{
+		{
+			name: "multiple loading",
+			before: `    INP
+    STA A
+    INP
+    STA B
+    LDA A
+    LDA B
+    OUT
+    HLT
+
+A DAT 0
+B DAT 0
+`,
+			// `LDA B` goes too, as the accumulator still holds B.
+			after: `    INP
+    STA A
+    INP
+    STA B
+    OUT
+    HLT
+
+A DAT 0
+B DAT 0
+`,
+			inputs: [][]lmc.Value{{3, 4}},
+		},
+		{
+			name: "pairs",
+			before: `    INP
+    STA A
+    OUT
+    LDA A
+    STA A
+    OUT
+    HLT
+
+A DAT 0
+`,
+			after: `    INP
+    STA A
+    OUT
+    OUT
+    HLT
+
+A DAT 0
+`,
+			inputs: [][]lmc.Value{{5}},
+		},
+		{
+			name: "pair on every path",
+			before: `    INP
+    STA A
+    BRZ l_A
+    OUT
+l_A LDA A
+    OUT
+    HLT
+
+A DAT 0
+`,
+			after: `    INP
+    STA A
+    BRZ l_A
+    OUT
+l_A OUT
+    HLT
+
+A DAT 0
+`,
+			inputs: [][]lmc.Value{{0}, {6}},
+		},
+		{
+			// The loop branches back with the accumulator holding N, so
+			// `LOOP LDA A` is kept, although it holds A on entering.
+			name: "loop carried load",
+			before: `     INP
+     STA N
+     INP
+     STA A
+LOOP LDA A
+     OUT
+     LDA N
+     SUB ONE
+     STA N
+     BRP LOOP
+     HLT
+
+N    DAT 0
+A    DAT 0
+ONE  DAT 1
+`,
+			after: `     INP
+     STA N
+     INP
+     STA A
+LOOP LDA A
+     OUT
+     LDA N
+     SUB ONE
+     STA N
+     BRP LOOP
+     HLT
+
+N    DAT 0
+A    DAT 0
+ONE  DAT 1
+`,
+			inputs: [][]lmc.Value{{2, 7}, {0, 3}},
+		},
+		{
+			// `LDA C` clears the flag `SUB B` may have set, so is kept.
+			name: "flag",
+			before: `    INP
+    STA A
+    INP
+    STA B
+    LDA A
+    SUB B
+    STA C
+    LDA C
+    BRP l_A
+    LDA A
+    OUT
+    HLT
+l_A LDA B
+    OUT
+    HLT
+
+A DAT 0
+B DAT 0
+C DAT 0
+`,
+			after: `    INP
+    STA A
+    INP
+    STA B
+    LDA A
+    SUB B
+    STA C
+    LDA C
+    BRP l_A
+    LDA A
+    OUT
+    HLT
+l_A LDA B
+    OUT
+    HLT
+
+A DAT 0
+B DAT 0
+C DAT 0
+`,
+			inputs: [][]lmc.Value{{1, 2}, {2, 1}},
+		},
+	}, func(prog *lmc.Program) error {
+		return NewOThrashing(prog).Optimise()
+	})
+}
@@ -0,0 +1,665 @@
+package optimisation
+
+import (
+	"fmt"
+	"github.com/clr1107/lmc-llvm-target/lmc"
+)
+
+// DefaultUnrollLength is the most instructions OUnroll replaces a loop with by
+// default.
+const DefaultUnrollLength = 30
+
+var unrollStageNames = [...]string{
+	"UNROLL_COUNTED",
+	"UNROLL_TRACED",
+}
+
+func unrollErr(stage int, child error) error {
+	return fmt.Errorf("unrolling failed stage %d=%s: %s", stage, unrollStageNames[stage], child)
+}
+
+// countedLoop is a loop run a constant number of times, counting down to 0,
+// from index start (the header) to end (after the latch):
+//
+//	    LDA K    ; a constant, K >= 0
+//	    STA C
+//	L   ...      ; the body, without branches nor labels, or stores to C
+//	    LDA C
+//	    SUB ONE
+//	    STA C
+//	    BRP L
+//
+// The body and the decrement are run K + 1 times.
+type countedLoop struct {
+	start   int
+	end     int
+	trips   int
+	counter *lmc.Mailbox
+}
+
+// matchCountedLoop matches a counted loop with its header at an index. Nothing
+// may branch to the header but the latch, and the `STA C` before it must not be
+// labelled, so the counter is always set before the loop.
+func matchCountedLoop(prog *lmc.Program, i int) (*countedLoop, bool) {
+	instrs := prog.Memory.InstructionsList.Instructions
+	if i < 2 {
+		return nil, false
+	}
+
+	header, ok := instrs[i].(*lmc.Labelled)
+	if !ok {
+		return nil, false
+	} else if _, ok = instrs[i-1].(*lmc.Labelled); ok {
+		return nil, false
+	}
+
+	k := operand(instrs[i-2], opLoad)
+	counter := operand(instrs[i-1], opStore)
+
+	if k == nil || counter == nil {
+		return nil, false
+	}
+
+	trips, ok := constantValue(prog, k)
+	if !ok || trips < 0 {
+		return nil, false
+	}
+
+	// find the latch; the body must be straight line code
+	end := -1
+
+	for j := i; j < len(instrs); j++ {
+		if _, ok := instrs[j].(*lmc.Labelled); ok && j != i {
+			return nil, false
+		}
+
+		switch c := lmc.Unwrap(instrs[j]).(type) {
+		case *lmc.BranchInstr:
+			if c.BranchType != lmc.BRPositive || c.Identifier() != header.Identifier() {
+				return nil, false
+			}
+
+			end = j + 1
+		case *lmc.DataInstr, *lmc.SlotInstr:
+			return nil, false
+		}
+
+		if end != -1 {
+			break
+		}
+	}
+
+	if end == -1 || end-4 < i || branchesTo(prog, header.Label()) != 1 {
+		return nil, false
+	}
+
+	one := operand(instrs[end-3], opSub)
+	if v, ok := constantValue(prog, one); !ok || v != 1 {
+		return nil, false
+	}
+
+	is := func(j int, op unaryOp) bool {
+		x := operand(instrs[j], op)
+		return x != nil && x.Identifier() == counter.Identifier()
+	}
+
+	if !is(end-4, opLoad) || !is(end-2, opStore) {
+		return nil, false
+	}
+
+	for j := i; j < end-4; j++ {
+		if is(j, opStore) {
+			return nil, false
+		}
+	}
+
+	return &countedLoop{start: i, end: end, trips: int(trips) + 1, counter: counter}, true
+}
+
+// cloneInstr gives a new instruction, unlabelled, the same as the one given,
+// so that optimisations changing one do not change the other. Nil is returned
+// for instructions that cannot be cloned.
+func cloneInstr(instr lmc.Instruction) lmc.Instruction {
+	switch c := lmc.Unwrap(instr).(type) {
+	case *lmc.LoadInstr:
+		return lmc.NewLoadInstr(c.Param)
+	case *lmc.StoreInstr:
+		return lmc.NewStoreInstr(c.Param)
+	case *lmc.AddInstr:
+		return lmc.NewAddInstr(c.Param)
+	case *lmc.SubInstr:
+		return lmc.NewSubInstr(c.Param)
+	case *lmc.InputInstr:
+		return lmc.NewInputInstr()
+	case *lmc.OutputInstr:
+		return lmc.NewOutputInstr()
+	case *lmc.HaltInstr:
+		return lmc.NewHaltInstr()
+	default:
+		return nil
+	}
+}
+
+// unroll_counted replaces counted loops by their body, repeated once per trip,
+// if that is no more than the maximum length. The decrement is dropped as well
+// if the body does not use the counter, and neither the counter nor the
+// accumulator are read after the loop or at the start of the body; otherwise
+// it is repeated too. Returns true if any loop was unrolled.
+func unroll_counted(prog *lmc.Program, maxLength int) (bool, error) {
+	changed := false
+
+	for i := 0; i < len(prog.Memory.InstructionsList.Instructions); i++ {
+		c, ok := matchCountedLoop(prog, i)
+		if !ok {
+			continue
+		}
+
+		list := prog.Memory.InstructionsList
+		body := list.Instructions[c.start : c.end-1]
+
+		cfg, err := lmc.NewCFG(list)
+		if err != nil {
+			return changed, err
+		}
+
+		liveness := lmc.NewLiveness(cfg, list)
+		acc := accLiveness(cfg)
+		before, after := accLiveBefore(cfg, acc, c.start), accLiveBefore(cfg, acc, c.end)
+
+		count := !liveness.Tracked(c.counter) || liveness.LiveBefore(c.end).Has(c.counter) ||
+			before != (accLive{}) || after != (accLive{})
+
+		for _, instr := range body[:len(body)-3] {
+			if x := instr.Boxes(); len(x) > 0 && x[0].Identifier() == c.counter.Identifier() {
+				count = true
+			}
+		}
+
+		if !count {
+			body = body[:len(body)-3]
+		}
+
+		if len(body)*c.trips > maxLength {
+			continue
+		}
+
+		var instrs []lmc.Instruction
+
+		for t := 0; t < c.trips; t++ {
+			for _, instr := range body {
+				x := cloneInstr(instr)
+				if x == nil {
+					return changed, fmt.Errorf("cannot clone `%s'", instr.LMCString())
+				}
+
+				instrs = append(instrs, x)
+			}
+		}
+
+		// the header is only branched to by the latch, so its label can go
+		if len(instrs) == 0 {
+			list.Instructions = append(list.Instructions[:c.start], list.Instructions[c.end:]...)
+		} else {
+			list.Instructions[c.start] = lmc.Unwrap(list.Instructions[c.start])
+			replaceInstructions(prog, c.start, c.end, instrs)
+		}
+
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// traceLimit bounds the values known whilst tracing a loop, taken as signed:
+// within it, a word and the negative flag are the same on any LMC, as long as
+// its modulus is at least 1000. Results outside it are taken as unknown.
+const traceLimit = 500
+
+// traceValue is a word whilst tracing a loop: its value, taken as signed, if
+// known; otherwise a symbol, if any, that only its copies share.
+type traceValue struct {
+	v     lmc.Value
+	known bool
+	sym   int
+}
+
+func knownValue(v lmc.Value) traceValue {
+	return traceValue{v: v, known: v >= -traceLimit && v < traceLimit}
+}
+
+// same returns true if both are certainly the same word.
+func (t traceValue) same(o traceValue) bool {
+	if t.known || o.known {
+		return t.known && o.known && t.v == o.v
+	}
+
+	return t.sym != 0 && t.sym == o.sym
+}
+
+// word gives a known value as an unsigned word, ordered as on any LMC.
+func (t traceValue) word() lmc.Value {
+	if t.v < 0 {
+		return t.v + 2*traceLimit
+	}
+
+	return t.v
+}
+
+// tracer runs code on what is known of the mailboxes, giving the instructions
+// that would be run, in order, less those it need not: those giving the
+// accumulator a known value, which is only loaded, from a constant, once
+// needed; loads of what it already holds; and stores of known values, which
+// are left for flush.
+type tracer struct {
+	prog  *lmc.Program
+	boxes map[string]traceValue
+	acc   traceValue
+	flag  traceValue // set if 1
+	syms  int
+
+	// what the instructions given leave in the accumulator, whether they leave
+	// the flag clear, and whether they leave it as it should be
+	real      traceValue
+	realClear bool
+	flagSync  bool
+
+	stores  []*lmc.Mailbox // left, in order
+	pending map[string]bool
+	instrs  []lmc.Instruction
+	consts  map[int]lmc.Value // instructions to use a constant; nil to load it
+}
+
+func newTracer(prog *lmc.Program) *tracer {
+	t := &tracer{
+		prog:  prog,
+		boxes: make(map[string]traceValue),
+	}
+
+	t.restart()
+	return t
+}
+
+// restart gives no more instructions, as though those given so far have run.
+func (t *tracer) restart() {
+	t.real, t.realClear, t.flagSync = t.acc, t.flag.known && t.flag.v == 0, true
+	t.stores, t.pending, t.instrs = nil, make(map[string]bool), nil
+	t.consts = make(map[int]lmc.Value)
+}
+
+func (t *tracer) box(box *lmc.Mailbox) traceValue {
+	if v, ok := t.boxes[box.Identifier()]; ok {
+		return v
+	} else if v, ok := constantValue(t.prog, box); ok {
+		return knownValue(v)
+	}
+
+	return traceValue{}
+}
+
+func (t *tracer) symbol() traceValue {
+	t.syms++
+	return traceValue{sym: t.syms}
+}
+
+// fold runs an instruction that is not given.
+func (t *tracer) fold(acc traceValue, flag traceValue) {
+	t.acc, t.flag = acc, flag
+	t.flagSync = t.realClear && flag.v == 0
+}
+
+// emit gives an instruction leaving the accumulator unknown, and the flag clear
+// or unknown.
+func (t *tracer) emit(instr lmc.Instruction, clear bool) {
+	t.instrs = append(t.instrs, instr)
+
+	t.acc = t.symbol()
+	t.flag = traceValue{known: clear}
+	t.real, t.realClear, t.flagSync = t.acc, clear, true
+}
+
+// load gives a load of a known value, unless the accumulator already holds it.
+func (t *tracer) load(v traceValue) {
+	if v.same(t.real) {
+		return
+	}
+
+	t.consts[len(t.instrs)] = v.v
+	t.instrs = append(t.instrs, nil)
+
+	t.real, t.realClear = v, true
+	t.flagSync = t.flag.known && t.flag.v == 0
+}
+
+// sync gives a load of the accumulator, if it is known and not what the
+// instructions given leave in it; if unknown, it always is.
+func (t *tracer) sync() {
+	if t.acc.known {
+		t.load(t.acc)
+	}
+}
+
+// flush gives a store left to a mailbox, if any.
+func (t *tracer) flush(box *lmc.Mailbox) {
+	if t.pending[box.Identifier()] {
+		t.load(t.box(box))
+		t.instrs = append(t.instrs, lmc.NewStoreInstr(box))
+
+		delete(t.pending, box.Identifier())
+	}
+}
+
+// run traces an instruction other than a branch. False is returned if it
+// cannot be.
+func (t *tracer) run(instr lmc.Instruction) bool {
+	switch c := instr.(type) {
+	case *lmc.LoadInstr:
+		x := t.box(c.Param)
+
+		if x.known || x.same(t.real) {
+			t.fold(x, traceValue{known: true})
+		} else {
+			t.emit(lmc.NewLoadInstr(c.Param), true)
+
+			// from now on, the mailbox is known to hold the same
+			t.boxes[c.Param.Identifier()] = t.acc
+		}
+	case *lmc.AddInstr, *lmc.SubInstr:
+		x := t.box(c.Boxes()[0])
+		_, sub := c.(*lmc.SubInstr)
+
+		if t.acc.known && x.known {
+			r, flag := knownValue(t.acc.v+x.v), traceValue{known: true}
+
+			if sub {
+				r = knownValue(t.acc.v - x.v)
+				if t.acc.word() < x.word() {
+					flag.v = 1
+				}
+			}
+
+			// out of bounds, the result depends on the modulus
+			if r.known {
+				t.fold(r, flag)
+				return true
+			}
+		}
+
+		t.sync()
+
+		// a store to the mailbox may be left, so use the constant
+		if x.known {
+			t.consts[len(t.instrs)] = x.v
+		}
+
+		t.emit(cloneInstr(c), !sub)
+	case *lmc.StoreInstr:
+		id := c.Param.Identifier()
+		if t.box(c.Param).same(t.acc) {
+			break
+		}
+
+		t.boxes[id] = t.acc
+
+		if t.acc.known {
+			if !t.pending[id] {
+				t.pending[id] = true
+				t.stores = append(t.stores, c.Param)
+			}
+		} else {
+			delete(t.pending, id)
+			t.instrs = append(t.instrs, lmc.NewStoreInstr(c.Param))
+		}
+	case *lmc.InputInstr:
+		t.emit(lmc.NewInputInstr(), true)
+	case *lmc.OutputInstr, *lmc.HaltInstr:
+		t.sync()
+		t.instrs = append(t.instrs, cloneInstr(c))
+	default:
+		return false
+	}
+
+	return true
+}
+
+// traceLoop traces the loop with its header at an index, giving the
+// instructions it runs, then a branch to wherever it leaves, and the index
+// after the loop. The loop is from the header to the last branch back to it,
+// and must only be entered by falling into the header; what is known on entry
+// is what the block before stores, and the constants. Every branch must depend
+// only on what is known, so the loop runs the same way every time, and no more
+// than the maximum length of instructions may be given. Nil is given otherwise.
+func traceLoop(prog *lmc.Program, i int, maxLength int) ([]lmc.Instruction, int, error) {
+	instrs := prog.Memory.InstructionsList.Instructions
+
+	header, ok := instrs[i].(*lmc.Labelled)
+	if !ok {
+		return nil, -1, nil
+	}
+
+	end := -1
+	for j := i; j < len(instrs); j++ {
+		if c, ok := lmc.Unwrap(instrs[j]).(*lmc.BranchInstr); ok && c.Identifier() == header.Identifier() {
+			end = j + 1
+		}
+	}
+
+	if end == -1 {
+		return nil, -1, nil
+	}
+
+	// the header must be fallen into, and the loop only branched into by itself
+	if i > 0 {
+		switch c := lmc.Unwrap(instrs[i-1]).(type) {
+		case *lmc.HaltInstr, *lmc.DataInstr, *lmc.SlotInstr:
+			return nil, -1, nil
+		case *lmc.BranchInstr:
+			if c.BranchType == lmc.BRAlways {
+				return nil, -1, nil
+			}
+		}
+	}
+
+	targets := make(map[string]int)
+
+	for j := i; j < end; j++ {
+		switch c := lmc.Unwrap(instrs[j]).(type) {
+		case *lmc.BranchInstr:
+			targets[c.Identifier()]++
+		case *lmc.DataInstr, *lmc.SlotInstr:
+			return nil, -1, nil
+		}
+	}
+
+	labels := make(map[string]int)
+
+	for j, instr := range instrs {
+		if c, ok := instr.(*lmc.Labelled); ok {
+			labels[c.Identifier()] = j
+
+			if j >= i && j < end && targets[c.Identifier()] != branchesTo(prog, c.Label()) {
+				return nil, -1, nil
+			}
+		}
+	}
+
+	cfg, err := lmc.NewCFG(prog.Memory.InstructionsList)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	// run the block before, so what it stores is known
+	t := newTracer(prog)
+
+	if b := cfg.BlockOf(i - 1); b != nil {
+		for _, instr := range b.Instructions {
+			if _, ok := lmc.Unwrap(instr).(*lmc.BranchInstr); ok {
+				continue
+			} else if !t.run(lmc.Unwrap(instr)) {
+				t = newTracer(prog)
+				break
+			}
+		}
+	}
+
+	t.restart()
+
+	// branches decided by known values run the same way every time, so a loop
+	// that runs on long enough does not stop
+	pc := i
+	halted := false
+
+	for steps := 0; pc >= i && pc < end && !halted; steps++ {
+		if steps > (end-i)*traceLimit || len(t.instrs) > maxLength {
+			return nil, -1, nil
+		}
+
+		switch c := lmc.Unwrap(instrs[pc]).(type) {
+		case *lmc.BranchInstr:
+			taken, known := true, true
+
+			switch c.BranchType {
+			case lmc.BRZero:
+				taken, known = t.acc.v == 0, t.acc.known
+			case lmc.BRPositive:
+				taken, known = t.flag.v == 0, t.flag.known
+			}
+
+			if !known {
+				return nil, -1, nil
+			}
+
+			pc++
+			if taken {
+				if pc, ok = labels[c.Identifier()]; !ok {
+					return nil, -1, nil
+				}
+			}
+		default:
+			if _, ok := c.(*lmc.HaltInstr); ok {
+				halted = true
+			}
+
+			if !t.run(c) {
+				return nil, -1, nil
+			}
+
+			pc++
+		}
+	}
+
+	// leave the accumulator and the flag as the loop would, if they are read
+	if !halted {
+		liveness := lmc.NewLiveness(cfg, prog.Memory.InstructionsList)
+		boxes := liveness.LiveBefore(pc)
+
+		for _, box := range t.stores {
+			if liveness.Live(boxes, box) {
+				t.flush(box)
+			}
+		}
+
+		live := accLiveBefore(cfg, accLiveness(cfg), pc)
+		if live.acc || live.flag {
+			t.sync()
+		}
+
+		if live.flag && !t.flagSync {
+			return nil, -1, nil
+		}
+
+		if pc != end {
+			t.instrs = append(t.instrs, lmc.NewBranchInstr(lmc.BRAlways, instrs[pc].(*lmc.Labelled).Label()))
+		}
+	}
+
+	if len(t.instrs) > maxLength {
+		return nil, -1, nil
+	}
+
+	for j, v := range t.consts {
+		box, err := prog.Constant(v)
+		if err != nil {
+			return nil, -1, err
+		}
+
+		switch c := t.instrs[j].(type) {
+		case nil:
+			t.instrs[j] = lmc.NewLoadInstr(box)
+		case *lmc.AddInstr:
+			c.Param = box
+		case *lmc.SubInstr:
+			c.Param = box
+		}
+	}
+
+	return t.instrs, end, nil
+}
+
+// unroll_traced replaces loops that run the same way every time, such as those
+// counting from a constant that the compiler creates, by the instructions they
+// run, if no more than the maximum length. See traceLoop. Returns true if any
+// loop was unrolled.
+func unroll_traced(prog *lmc.Program, maxLength int) (bool, error) {
+	changed := false
+
+	for i := 0; i < len(prog.Memory.InstructionsList.Instructions); i++ {
+		instrs, end, err := traceLoop(prog, i, maxLength)
+		if err != nil {
+			return changed, err
+		} else if end == -1 {
+			continue
+		}
+
+		// nothing else branched into the loop, so its labels can go
+		list := prog.Memory.InstructionsList
+
+		if len(instrs) == 0 {
+			list.Instructions = append(list.Instructions[:i], list.Instructions[end:]...)
+		} else {
+			list.Instructions[i] = lmc.Unwrap(list.Instructions[i])
+			replaceInstructions(prog, i, end, instrs)
+		}
+
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// ---------- OUnroll ----------
+
+// OUnroll fully unrolls loops run a constant number of times, if no more than
+// MaxLength instructions are needed: hand written counted loops, then any that
+// can be traced, such as those the compiler creates. This is always faster, but
+// often larger.
+type OUnroll struct {
+	program   *lmc.Program
+	MaxLength int
+}
+
+func NewOUnroll(program *lmc.Program, maxLength int) *OUnroll {
+	return &OUnroll{
+		program:   program,
+		MaxLength: maxLength,
+	}
+}
+
+func (o *OUnroll) Strategy() OStrategy {
+	return Unroll
+}
+
+func (o *OUnroll) Optimise() error {
+	if _, err := unroll_counted(o.program, o.MaxLength); err != nil {
+		return unrollErr(0, err)
+	}
+
+	if _, err := unroll_traced(o.program, o.MaxLength); err != nil {
+		return unrollErr(1, err)
+	}
+
+	return nil
+}
+
+func (o *OUnroll) Program() *lmc.Program {
+	return o.program
+}
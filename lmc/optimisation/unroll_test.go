@@ -0,0 +1,122 @@
+package optimisation
+
+import (
+	"testing"
+
+	"github.com/clr1107/lmc-llvm-target/lmc"
+)
+
+func TestUnroll(t *testing.T) {
+	testPasses(t, []passTest{
+		{
+			// The first example of OPTIMISATION.md.
+			name: "counted",
+			before: `    INP
+    STA X
+    LDA c_T
+    STA C
+l_A LDA X
+    OUT
+    LDA C
+    SUB c_O
+    STA C
+    BRP l_A
+    HLT
+
+X DAT 0
+C DAT 0
+c_T DAT 2
+c_O DAT 1
+`,
+			after: `    INP
+    STA X
+    LDA c_T
+    STA C
+    LDA X
+    OUT
+    LDA X
+    OUT
+    LDA X
+    OUT
+    HLT
+
+X DAT 0
+C DAT 0
+c_T DAT 2
+c_O DAT 1
+`,
+			inputs: [][]lmc.Value{{6}},
+		},
+		{
+			// The second example of OPTIMISATION.md, counting up as the
+			// compiler does.
+			name: "traced",
+			before: `    LDA c_Z
+    STA I
+l_A LDA I
+    OUT
+    ADD c_O
+    STA I
+    LDA c_T
+    SUB I
+    BRP l_A
+    HLT
+
+I DAT 0
+c_Z DAT 0
+c_O DAT 1
+c_T DAT 3
+`,
+			// The accumulator already holds 0, and a constant is made for 2.
+			after: `    LDA c_Z
+    STA I
+    OUT
+    LDA c_O
+    OUT
+    LDA c_D
+    OUT
+    LDA c_T
+    OUT
+    HLT
+
+I DAT 0
+c_Z DAT 0
+c_O DAT 1
+c_T DAT 3
+c_D DAT 2
+`,
+			inputs: [][]lmc.Value{{}},
+		},
+		{
+			// The number of trips is only known as the program runs.
+			name: "input",
+			before: `    INP
+    STA C
+l_A LDA C
+    OUT
+    SUB c_O
+    STA C
+    BRP l_A
+    HLT
+
+C DAT 0
+c_O DAT 1
+`,
+			after: `    INP
+    STA C
+l_A LDA C
+    OUT
+    SUB c_O
+    STA C
+    BRP l_A
+    HLT
+
+C DAT 0
+c_O DAT 1
+`,
+			inputs: [][]lmc.Value{{0}, {3}},
+		},
+	}, func(prog *lmc.Program) error {
+		return NewOUnroll(prog, DefaultUnrollLength).Optimise()
+	})
+}
@@ -0,0 +1,308 @@
+package lmc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ---------- Parsing ----------
+
+// parsedLine is one non-empty line of text-form LMC, split into its columns.
+type parsedLine struct {
+	number   int
+	label    string
+	mnemonic string
+	operand  string
+}
+
+var (
+	nullaryMnemonics = map[string]func() Instruction{
+		"INP": func() Instruction { return NewInputInstr() },
+		"OUT": func() Instruction { return NewOutputInstr() },
+		"HLT": func() Instruction { return NewHaltInstr() },
+	}
+	unaryMnemonics = map[string]func(*Mailbox) Instruction{
+		"ADD": func(box *Mailbox) Instruction { return NewAddInstr(box) },
+		"SUB": func(box *Mailbox) Instruction { return NewSubInstr(box) },
+		"STA": func(box *Mailbox) Instruction { return NewStoreInstr(box) },
+		"LDA": func(box *Mailbox) Instruction { return NewLoadInstr(box) },
+	}
+	branchMnemonics = map[string]BranchType{
+		"BRA": BRAlways,
+		"BRP": BRPositive,
+		"BRZ": BRZero,
+	}
+)
+
+// IsMnemonic returns true if a word, in any case, is a mnemonic; so it cannot
+// be used as an identifier.
+func IsMnemonic(s string) bool {
+	s = strings.ToUpper(s)
+
+	if _, ok := nullaryMnemonics[s]; ok {
+		return true
+	} else if _, ok = unaryMnemonics[s]; ok {
+		return true
+	} else if _, ok = branchMnemonics[s]; ok {
+		return true
+	}
+
+	return s == "DAT"
+}
+
+// stripComment removes anything after a `;` or `//` comment marker.
+func stripComment(line string) string {
+	if i := strings.Index(line, ";"); i != -1 {
+		line = line[:i]
+	}
+
+	if i := strings.Index(line, "//"); i != -1 {
+		line = line[:i]
+	}
+
+	return line
+}
+
+// splitLine splits a line into its label, mnemonic, and operand columns. A
+// first column that is not a mnemonic is a label; if both of the first two
+// columns are mnemonics the first is taken to be a label.
+func splitLine(number int, line string) (*parsedLine, error) {
+	fields := strings.Fields(stripComment(line))
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	p := &parsedLine{number: number}
+
+	if !IsMnemonic(fields[0]) || (len(fields) > 1 && IsMnemonic(fields[1])) {
+		p.label = fields[0]
+		fields = fields[1:]
+	}
+
+	switch len(fields) {
+	case 0:
+		return nil, ParseLineError(number, fmt.Errorf("label `%s' has no instruction", p.label))
+	case 1:
+		p.mnemonic = strings.ToUpper(fields[0])
+	case 2:
+		p.mnemonic = strings.ToUpper(fields[0])
+		p.operand = fields[1]
+	default:
+		return nil, ParseLineError(number, fmt.Errorf("too many columns"))
+	}
+
+	if !IsMnemonic(p.mnemonic) {
+		return nil, ParseLineError(number, UnknownMnemonicError(p.mnemonic))
+	}
+
+	return p, nil
+}
+
+// Parse reads text-form LMC, in the format given by *InstructionList#LMCString,
+// and creates a new program from it. Comments, starting with `;` or `//`, and
+// blank lines are ignored. Mnemonics are case-insensitive.
+//
+// The program ends with its data: the last lines that are each either a `DAT`
+// whose identifier is never branched to, or a labelled instruction whose label
+// is only used as a mailbox, which holds the word of the instruction (see
+// *Memory#Word). Every `X DAT n` line there defines a mailbox, and is added as
+// a data instruction; any unlabelled `DAT` lines after it make it an array,
+// see NewArrayInstr. Mailboxes named like constants (prefixed with 'c_') that
+// are never stored to are registered as constants, with address -1, so that
+// *Memory#Constant will reuse them. All other mailboxes are given addresses in
+// the order they are defined, starting at 0.
+//
+// A `DAT` before the data is a slot, see SlotInstr. Where it may branch to is
+// not known, so it is presumed not to. The label of an instruction may be used
+// as a mailbox, see *Program#LabelMailbox.
+func Parse(r io.Reader) (*Program, error) {
+	var lines []*parsedLine
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		if p, err := splitLine(n, scanner.Text()); err != nil {
+			return nil, err
+		} else if p != nil {
+			lines = append(lines, p)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read program: %s", err)
+	}
+
+	prog := NewProgram(NewBasicMemory())
+	stored := make(map[string]struct{})
+	branched := make(map[string]struct{})
+	operands := make(map[string]struct{})
+
+	for _, p := range lines {
+		if p.mnemonic == "STA" {
+			stored[p.operand] = struct{}{}
+		}
+
+		if _, ok := branchMnemonics[p.mnemonic]; ok {
+			branched[p.operand] = struct{}{}
+		} else if _, ok := unaryMnemonics[p.mnemonic]; ok {
+			operands[p.operand] = struct{}{}
+		}
+	}
+
+	// the data is every line from here on
+	data := len(lines)
+
+	for ; data > 0; data-- {
+		p := lines[data-1]
+		_, isBranched := branched[p.label]
+		_, isOperand := operands[p.label]
+
+		if isBranched || (p.mnemonic != "DAT" && (p.label == "" || !isOperand)) {
+			break
+		}
+	}
+
+	// First pass: mailboxes and labels, so they may be used before they are
+	// defined.
+
+	var addr Address
+	var array *DataInstr
+
+	for k, p := range lines {
+		if k < data || p.mnemonic != "DAT" {
+			if p.label != "" {
+				if err := prog.Memory.AddLabel(NewLabel(p.label)); err != nil {
+					return nil, ParseLineError(p.number, err)
+				}
+			}
+
+			array = nil
+			continue
+		}
+
+		value, err := parseValue(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.label == "" {
+			if array == nil {
+				return nil, ParseLineError(p.number, fmt.Errorf("DAT has no identifier"))
+			}
+
+			array.Rest = append(array.Rest, value)
+			continue
+		}
+
+		var box *Mailbox
+		_, isStored := stored[p.label]
+		_, isConstant := prog.Memory.constants[value]
+
+		if strings.HasPrefix(p.label, "c_") && !isStored && !isConstant {
+			box = NewMailbox(-1, p.label)
+			prog.Memory.constants[value] = box
+		} else {
+			box = NewMailbox(addr, p.label)
+			addr++
+		}
+
+		if err := prog.Memory.AddMailbox(box); err != nil {
+			return nil, ParseLineError(p.number, err)
+		}
+
+		array = NewDataInstr(value, box)
+		prog.Memory.InstructionsList.AddDef(array)
+	}
+
+	// Second pass: instructions, and words.
+
+	for k, p := range lines {
+		var instr Instruction
+
+		if p.mnemonic == "DAT" {
+			if k >= data {
+				continue
+			} else if p.label == "" {
+				return nil, ParseLineError(p.number, fmt.Errorf("DAT has no identifier"))
+			}
+
+			value, err := parseValue(p)
+			if err != nil {
+				return nil, err
+			}
+
+			instr = NewSlotInstr(value, nil)
+		} else if f, ok := nullaryMnemonics[p.mnemonic]; ok {
+			if p.operand != "" {
+				return nil, ParseLineError(p.number, fmt.Errorf("%s takes no operand", p.mnemonic))
+			}
+
+			instr = f()
+		} else if p.operand == "" {
+			return nil, ParseLineError(p.number, fmt.Errorf("%s takes an operand", p.mnemonic))
+		} else if f, ok := unaryMnemonics[p.mnemonic]; ok {
+			box := prog.Memory.GetMailboxIdentifier(p.operand)
+
+			if label := prog.Memory.GetLabel(p.operand); box == nil && label != nil {
+				var err error
+				if box, err = prog.LabelMailbox(label); err != nil {
+					return nil, ParseLineError(p.number, err)
+				}
+			}
+
+			if box == nil {
+				return nil, ParseLineError(p.number, UnknownMailboxError(p.operand))
+			}
+
+			instr = f(box)
+		} else {
+			label := prog.Memory.GetLabel(p.operand)
+			if label == nil {
+				return nil, ParseLineError(p.number, UnknownLabelError(p.operand))
+			}
+
+			instr = NewBranchInstr(branchMnemonics[p.mnemonic], label)
+		}
+
+		if k >= data {
+			box, err := prog.LabelMailbox(prog.Memory.GetLabel(p.label))
+			if err != nil {
+				return nil, ParseLineError(p.number, err)
+			}
+
+			prog.Memory.words[instr.LMCString()] = box
+			prog.Memory.InstructionsList.AddDef(NewWordInstr(instr, box))
+
+			continue
+		}
+
+		if p.label != "" {
+			instr = NewLabelled(prog.Memory.GetLabel(p.label), instr)
+		}
+
+		prog.Memory.InstructionsList.AddInstruction(instr)
+	}
+
+	return prog, nil
+}
+
+// parseValue gives the value of a `DAT` line, 0 if it has none.
+func parseValue(p *parsedLine) (Value, error) {
+	if p.operand == "" {
+		return 0, nil
+	}
+
+	v, err := strconv.Atoi(p.operand)
+	if err != nil {
+		return 0, ParseLineError(p.number, fmt.Errorf("invalid DAT value `%s'", p.operand))
+	}
+
+	return Value(v), nil
+}
+
+// ParseString parses a program from a string. See Parse.
+func ParseString(s string) (*Program, error) {
+	return Parse(strings.NewReader(s))
+}
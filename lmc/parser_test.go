@@ -0,0 +1,85 @@
+package lmc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestParseRoundTrip parses every fixture, each in the form LMCString gives,
+// and checks that printing it gives the fixture back, and that parsing that
+// does too.
+func TestParseRoundTrip(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.lmc"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(paths) == 0 {
+		t.Fatal("no fixtures")
+	}
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			text, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			prog, err := ParseString(string(text))
+			if err != nil {
+				t.Fatalf("could not parse: %s", err)
+			}
+
+			if got := prog.String(); got != string(text) {
+				t.Fatalf("printed differently:\n%s\nwant:\n%s", got, text)
+			}
+
+			again, err := ParseString(prog.String())
+			if err != nil {
+				t.Fatalf("could not parse again: %s", err)
+			} else if again.String() != prog.String() {
+				t.Fatalf("printed differently the second time:\n%s", again.String())
+			}
+		})
+	}
+}
+
+// TestParseNormalises checks that comments, blank lines and the case of
+// mnemonics do not change the program parsed.
+func TestParseNormalises(t *testing.T) {
+	prog, err := ParseString(`
+; count down from the input
+l_A inp // read
+    sta X
+
+l_B Lda X
+    OUT
+    sub c_A
+    STA X
+    BRP l_B
+    hlt
+
+X DAT 0
+c_A DAT 1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "l_A INP\n    STA X\nl_B LDA X\n    OUT\n    SUB c_A\n    STA X\n    BRP l_B\n    HLT\n\nX DAT 0\nc_A DAT 1\n"
+	if got := prog.String(); got != want {
+		t.Fatalf("printed differently:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestParseErrors checks that malformed programs are rejected.
+func TestParseErrors(t *testing.T) {
+	for _, text := range []string{
+		"    FOO X\n    HLT\n",            // unknown mnemonic
+		"l_A LDA X\nl_A HLT\n\nX DAT 0\n", // label defined twice
+		"    LDA X\n    HLT\n\nX DAT y\n", // value not a number
+	} {
+		if _, err := ParseString(text); err == nil {
+			t.Errorf("parsed without an error:\n%s", text)
+		}
+	}
+}
@@ -1,16 +1,29 @@
 package lmc
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultCapacity = 100 // Mailboxes in classic LMC
+	Unlimited       = 0   // No limit on mailboxes
+)
 
 // Program represents an LMC program. It holds the mailboxes and provides
-// utility functions to operate on the memory.
+// utility functions to operate on the memory. Capacity is the number of
+// mailboxes available on the target; by default that of classic LMC. See
+// *Program#CheckCapacity.
 type Program struct {
-	Memory *Memory
+	Memory   *Memory
+	Capacity int
 }
 
 func NewProgram(memory *Memory) *Program {
 	return &Program{
-		Memory: memory,
+		Memory:   memory,
+		Capacity: DefaultCapacity,
 	}
 }
 
@@ -74,6 +87,28 @@ func (p *Program) NewLabel(identifier string) (*Label, error) {
 	return op.Labels[0].Label, nil
 }
 
+// NewArray creates a new array of mailboxes, with the given address and
+// identifier, holding the values given; see NewArrayInstr. This function will
+// also attempt to add the mailbox and def instruction to memory; returning an
+// error if this fails.
+//
+// [Memory utility function]
+func (p *Program) NewArray(addr Address, identifier string, values []Value) (*Mailbox, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("could not create a new array: it has no mailboxes")
+	}
+
+	op := p.Memory.NewMailbox(addr, identifier)
+	box := op.Boxes[0].Box
+
+	if err := p.Memory.AddMailbox(box); err != nil {
+		return nil, fmt.Errorf("could not create a new array: %s", err)
+	}
+
+	p.AddInstructions(nil, []*DataInstr{NewArrayInstr(values, box)})
+	return box, nil
+}
+
 // Constant returns a mailbox storing a constant value. If one does not already
 // exist it will be created, and the box and instructions will be added to
 // memory, returning an error if this fails. See *Memory#Constant.
@@ -95,6 +130,167 @@ func (p *Program) Constant(value Value) (*Mailbox, error) {
 	return box.Box, nil
 }
 
+// Word returns a mailbox holding the word of an instruction. If one does not
+// already exist it will be created, and the box and instructions will be added
+// to memory, returning an error if this fails. See *Memory#Word.
+//
+// [Memory utility function]
+func (p *Program) Word(instr Instruction) (*Mailbox, error) {
+	op := p.Memory.Word(instr)
+	box := op.Boxes[0]
+
+	defs := op.Defs()
+	if len(defs) > 0 {
+		if err := p.Memory.AddMailbox(box.Box); err != nil {
+			return nil, fmt.Errorf("could not get a word: %s", err)
+		}
+
+		p.AddInstructions(nil, defs)
+	}
+
+	return box.Box, nil
+}
+
+// LabelMailbox returns a mailbox for the instruction a label is attached to, so
+// that it may be loaded or stored to, as self-modifying code does (see
+// SlotInstr). It has the identifier of the label, and id -1, and no data
+// instruction. If one does not already exist it will be created and added to
+// memory, returning an error if this fails.
+//
+// [Memory utility function]
+func (p *Program) LabelMailbox(label *Label) (*Mailbox, error) {
+	if box := p.Memory.GetMailboxIdentifier(label.Identifier()); box != nil {
+		return box, nil
+	}
+
+	box := NewMailbox(-1, label.Identifier())
+	if err := p.Memory.AddMailbox(box); err != nil {
+		return nil, fmt.Errorf("could not create a label mailbox: %s", err)
+	}
+
+	return box, nil
+}
+
+// ---------- Capacity ----------
+
+// MailboxUsage is a data mailbox and how many instructions use it.
+type MailboxUsage struct {
+	Box      *Mailbox
+	Uses     int
+	Constant bool
+}
+
+// CapacityError is given when a program needs more mailboxes than its target
+// has. Usage lists every data mailbox, most used first.
+type CapacityError struct {
+	Instructions int
+	Data         int
+	Available    int
+	Usage        []*MailboxUsage
+}
+
+func (e *CapacityError) Needed() int {
+	return e.Instructions + e.Data
+}
+
+// Constants gives how many of the data mailboxes are constants.
+func (e *CapacityError) Constants() int {
+	var c int
+	for _, u := range e.Usage {
+		if u.Constant {
+			c++
+		}
+	}
+
+	return c
+}
+
+// Variables gives how many of the data mailboxes are not constants, counting
+// every mailbox of an array.
+func (e *CapacityError) Variables() int {
+	return e.Data - e.Constants()
+}
+
+func (e *CapacityError) Error() string {
+	var buf strings.Builder
+
+	_, _ = fmt.Fprintf(
+		&buf,
+		"program needs %d mailboxes but only %d are available: %d instruction, %d data (%d constants, %d variables)",
+		e.Needed(), e.Available, e.Instructions, e.Data, e.Constants(), e.Variables(),
+	)
+
+	for k, u := range e.Usage {
+		if k == 5 {
+			buf.WriteString(", ...")
+			break
+		} else if k == 0 {
+			buf.WriteString("; most used ")
+		} else {
+			buf.WriteString(", ")
+		}
+
+		_, _ = fmt.Fprintf(&buf, "`%s' (%d)", u.Box.Identifier(), u.Uses)
+	}
+
+	return buf.String()
+}
+
+// Digits gives how many digits addresses have once the program is assembled,
+// which also decides the range of a word (see WordModulus): enough for its
+// capacity, or UnlimitedDigits if it has none. It does not depend on the size
+// of the program.
+func (p *Program) Digits() int {
+	if p.Capacity == Unlimited {
+		return UnlimitedDigits
+	}
+
+	return AddressDigits(p.Capacity)
+}
+
+// CheckCapacity returns a *CapacityError if, once laid out, the program needs
+// more mailboxes than its capacity. An Unlimited capacity never fails, though
+// such a program is only assembled if it fits in 1000 mailboxes, see
+// UnlimitedDigits.
+func (p *Program) CheckCapacity() error {
+	list := p.Memory.InstructionsList
+
+	if p.Capacity == Unlimited || len(list.Instructions)+list.DataSize() <= p.Capacity {
+		return nil
+	}
+
+	uses := make(map[string]int)
+	for _, instr := range list.Instructions {
+		if _, ok := instr.(*DataInstr); ok {
+			continue
+		}
+
+		for _, box := range instr.Boxes() {
+			uses[box.Identifier()]++
+		}
+	}
+
+	usage := make([]*MailboxUsage, len(list.DefInstructions))
+	for k, def := range list.DefInstructions {
+		usage[k] = &MailboxUsage{
+			Box:      def.Box,
+			Uses:     uses[def.Box.Identifier()],
+			Constant: p.Memory.IsConstant(def.Box),
+		}
+	}
+
+	sort.SliceStable(usage, func(i int, j int) bool {
+		return usage[i].Uses > usage[j].Uses
+	})
+
+	return &CapacityError{
+		Instructions: len(list.Instructions),
+		Data:         list.DataSize(),
+		Available:    p.Capacity,
+		Usage:        usage,
+	}
+}
+
 func (p *Program) String() string {
 	return p.Memory.InstructionsList.LMCString()
 }
@@ -0,0 +1,270 @@
+// Package vm executes assembled LMC programs. It models the accumulator, the
+// program counter, and the negative flag of the Little Man Computer.
+//
+// # Semantics
+//
+// Every mailbox and the accumulator hold a word in [0, modulus), where the
+// modulus is given by the image (1000 for classic LMC). `ADD` wraps around the
+// modulus; `SUB` sets the negative flag if the result would be below zero, and
+// leaves the accumulator holding the result in ten's complement (e.g., 0 - 1
+// is 999 with the flag set). `LDA`, `ADD`, and `INP` clear the flag. `BRZ`
+// branches if the accumulator is zero, and `BRP` if the flag is clear.
+package vm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/clr1107/lmc-llvm-target/lmc"
+	"io"
+	"strconv"
+)
+
+// DefaultMaxSteps is the step limit used when a Config does not give one.
+const DefaultMaxSteps = 100000
+
+var (
+	NoInputError            = errors.New("no input available")
+	InvalidInstructionError = func(addr lmc.Address, word lmc.Value) error {
+		return fmt.Errorf("invalid instruction %d at mailbox %d", word, addr)
+	}
+	CounterOutOfRangeError = func(addr lmc.Address) error {
+		return fmt.Errorf("program counter out of range at %d", addr)
+	}
+	InputError = func(child error) error {
+		return fmt.Errorf("could not read input: %s", child)
+	}
+	OutputError = func(child error) error {
+		return fmt.Errorf("could not write output: %s", child)
+	}
+)
+
+// ---------- I/O ----------
+
+// Input gives the value for an `INP` instruction.
+type Input func() (lmc.Value, error)
+
+// Output receives the value from an `OUT` instruction.
+type Output func(lmc.Value) error
+
+// Inputs gives the values passed, in order, then NoInputError.
+func Inputs(values ...lmc.Value) Input {
+	return func() (lmc.Value, error) {
+		if len(values) == 0 {
+			return 0, NoInputError
+		}
+
+		v := values[0]
+		values = values[1:]
+
+		return v, nil
+	}
+}
+
+// ReaderInput reads whitespace separated integers from a reader.
+func ReaderInput(r io.Reader) Input {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanWords)
+
+	return func() (lmc.Value, error) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return 0, err
+			}
+
+			return 0, NoInputError
+		}
+
+		v, err := strconv.Atoi(scanner.Text())
+		return lmc.Value(v), err
+	}
+}
+
+// WriterOutput writes each value to a writer, one per line.
+func WriterOutput(w io.Writer) Output {
+	return func(v lmc.Value) error {
+		_, err := fmt.Fprintln(w, v)
+		return err
+	}
+}
+
+// ---------- VM ----------
+
+// Config holds the I/O and limits of a run. Outputs are always collected in
+// the result, so Output may be nil.
+type Config struct {
+	Input    Input
+	Output   Output
+	MaxSteps int
+}
+
+// Result is the state of a VM once it has stopped.
+type Result struct {
+	Outputs     []lmc.Value
+	Halted      bool
+	TimedOut    bool
+	Cycles      int
+	Accumulator lmc.Value
+	Counter     lmc.Address
+	Negative    bool
+	Modulus     lmc.Value
+}
+
+// Signed gives a word interpreted as a ten's complement signed value, e.g.,
+// 999 as -1.
+func (r *Result) Signed(v lmc.Value) lmc.Value {
+	if v >= r.Modulus/2 {
+		return v - r.Modulus
+	}
+
+	return v
+}
+
+// SignedOutputs gives all outputs as signed values. See *Result#Signed.
+func (r *Result) SignedOutputs() []lmc.Value {
+	l := make([]lmc.Value, len(r.Outputs))
+	for k, v := range r.Outputs {
+		l[k] = r.Signed(v)
+	}
+
+	return l
+}
+
+// VM is one Little Man Computer. Its memory holds every addressable mailbox,
+// so is at least 100 words.
+type VM struct {
+	Memory      []lmc.Value
+	Accumulator lmc.Value
+	Counter     lmc.Address
+	Negative    bool
+	digits      int
+	modulus     lmc.Value
+	config      Config
+	result      Result
+}
+
+// New creates a VM with the image loaded into memory, ready to run from
+// mailbox 0.
+func New(image *lmc.Image, config Config) *VM {
+	if config.MaxSteps <= 0 {
+		config.MaxSteps = DefaultMaxSteps
+	}
+
+	v := &VM{
+		Memory:  make([]lmc.Value, lmc.WordModulus(image.Digits)/10),
+		digits:  image.Digits,
+		modulus: image.Modulus(),
+		config:  config,
+	}
+
+	for k, w := range image.Words {
+		v.Memory[k] = image.Normalise(w)
+	}
+
+	v.result.Modulus = v.modulus
+	return v
+}
+
+// Step executes one instruction, returning true if it was `HLT`.
+func (v *VM) Step() (bool, error) {
+	if v.Counter < 0 || int(v.Counter) >= len(v.Memory) {
+		return false, CounterOutOfRangeError(v.Counter)
+	}
+
+	word := v.Memory[v.Counter]
+	op, addr := lmc.Decode(word, v.digits)
+	pc := v.Counter
+
+	v.Counter++
+	v.result.Cycles++
+
+	switch op {
+	case lmc.OpHalt:
+		v.Counter = pc
+		return true, nil
+	case lmc.OpAdd:
+		v.Accumulator = (v.Accumulator + v.Memory[addr]) % v.modulus
+		v.Negative = false
+	case lmc.OpSub:
+		r := v.Accumulator - v.Memory[addr]
+		v.Negative = r < 0
+		v.Accumulator = (r + v.modulus) % v.modulus
+	case lmc.OpStore:
+		v.Memory[addr] = v.Accumulator
+	case lmc.OpLoad:
+		v.Accumulator = v.Memory[addr]
+		v.Negative = false
+	case lmc.OpBranchAlways:
+		v.Counter = addr
+	case lmc.OpBranchZero:
+		if v.Accumulator == 0 {
+			v.Counter = addr
+		}
+	case lmc.OpBranchPositive:
+		if !v.Negative {
+			v.Counter = addr
+		}
+	case lmc.OpIO:
+		switch addr {
+		case lmc.IOInput:
+			if v.config.Input == nil {
+				return false, InputError(NoInputError)
+			}
+
+			x, err := v.config.Input()
+			if err != nil {
+				return false, InputError(err)
+			}
+
+			v.Accumulator = ((x % v.modulus) + v.modulus) % v.modulus
+			v.Negative = false
+		case lmc.IOOutput:
+			v.result.Outputs = append(v.result.Outputs, v.Accumulator)
+
+			if v.config.Output != nil {
+				if err := v.config.Output(v.Accumulator); err != nil {
+					return false, OutputError(err)
+				}
+			}
+		default:
+			return false, InvalidInstructionError(pc, word)
+		}
+	default:
+		return false, InvalidInstructionError(pc, word)
+	}
+
+	return false, nil
+}
+
+// Run steps until the VM halts, faults, or reaches the step limit; in which
+// case the result is marked as timed out. The result is always given, even
+// with an error, so the state at a fault can be inspected.
+func (v *VM) Run() (*Result, error) {
+	var err error
+	var halted bool
+
+	for v.result.Cycles < v.config.MaxSteps {
+		if halted, err = v.Step(); err != nil || halted {
+			break
+		}
+	}
+
+	v.result.Halted = halted
+	v.result.TimedOut = !halted && err == nil
+	v.result.Accumulator = v.Accumulator
+	v.result.Counter = v.Counter
+	v.result.Negative = v.Negative
+
+	r := v.result
+	return &r, err
+}
+
+// Run assembles a program and runs it. See *VM#Run.
+func Run(prog *lmc.Program, config Config) (*Result, error) {
+	image, err := prog.Assemble()
+	if err != nil {
+		return nil, err
+	}
+
+	return New(image, config).Run()
+}
@@ -0,0 +1,111 @@
+package vm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/clr1107/lmc-llvm-target/lmc"
+)
+
+// run parses a program and runs it.
+func run(t *testing.T, text string, config Config) (*Result, error) {
+	t.Helper()
+
+	prog, err := lmc.ParseString(text)
+	if err != nil {
+		t.Fatalf("could not parse: %s", err)
+	}
+
+	return Run(prog, config)
+}
+
+// TestFlag checks that `SUB` sets the negative flag only on borrowing, leaving
+// the result in ten's complement, and that `LDA` and `ADD` clear it.
+func TestFlag(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		acc      lmc.Value
+		negative bool
+	}{
+		{"SUB borrows", "    LDA A\n    SUB B\n    HLT\n\nA DAT 0\nB DAT 1\n", 999, true},
+		{"SUB does not borrow", "    LDA B\n    SUB B\n    HLT\n\nA DAT 0\nB DAT 1\n", 0, false},
+		{"LDA clears", "    LDA A\n    SUB B\n    LDA A\n    HLT\n\nA DAT 0\nB DAT 1\n", 0, false},
+		{"ADD clears", "    LDA A\n    SUB B\n    ADD B\n    HLT\n\nA DAT 0\nB DAT 1\n", 0, false},
+		{"only the last SUB counts", "    LDA A\n    SUB B\n    SUB A\n    HLT\n\nA DAT 0\nB DAT 1\n", 999, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := run(t, test.text, Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !r.Halted || r.Accumulator != test.acc || r.Negative != test.negative {
+				t.Fatalf("halted %t, accumulator %d, negative %t; want accumulator %d, negative %t", r.Halted, r.Accumulator, r.Negative, test.acc, test.negative)
+			}
+		})
+	}
+}
+
+// TestBranchPositive checks that `BRP` branches only if the flag is clear.
+func TestBranchPositive(t *testing.T) {
+	text := "    INP\n    SUB B\n    BRP l_A\n    LDA A\n    OUT\n    HLT\nl_A LDA B\n    OUT\n    HLT\n\nA DAT 0\nB DAT 1\n"
+
+	for input, want := range map[lmc.Value]lmc.Value{0: 0, 1: 1, 5: 1} {
+		r, err := run(t, text, Config{Input: Inputs(input)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(r.Outputs, []lmc.Value{want}) {
+			t.Errorf("input %d: outputs %v, want [%d]", input, r.Outputs, want)
+		}
+	}
+}
+
+// TestMaxSteps checks that a program that never halts is stopped at the step
+// limit, without an error.
+func TestMaxSteps(t *testing.T) {
+	r, err := run(t, "l_A BRA l_A\n", Config{MaxSteps: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Halted || !r.TimedOut || r.Cycles != 50 {
+		t.Fatalf("halted %t, timed out %t after %d cycles; want timed out after 50", r.Halted, r.TimedOut, r.Cycles)
+	}
+}
+
+// TestIO checks that input is taken modulo the word size, that every output is
+// both recorded and passed to the Output, and that running out of input is an
+// error.
+func TestIO(t *testing.T) {
+	text := "    INP\n    OUT\n    INP\n    OUT\n    HLT\n"
+
+	var passed []lmc.Value
+	r, err := run(t, text, Config{
+		Input: Inputs(5, -3),
+		Output: func(v lmc.Value) error {
+			passed = append(passed, v)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []lmc.Value{5, 997}; !reflect.DeepEqual(r.Outputs, want) || !reflect.DeepEqual(passed, want) {
+		t.Fatalf("outputs %v, passed %v; want %v", r.Outputs, passed, want)
+	} else if signed := r.SignedOutputs(); !reflect.DeepEqual(signed, []lmc.Value{5, -3}) {
+		t.Fatalf("signed outputs %v, want [5 -3]", signed)
+	}
+
+	r, err = run(t, text, Config{Input: Inputs(5)})
+	if err == nil || err.Error() != InputError(NoInputError).Error() {
+		t.Fatalf("error %v, want %s", err, InputError(NoInputError))
+	} else if r.Halted || !reflect.DeepEqual(r.Outputs, []lmc.Value{5}) {
+		t.Fatalf("halted %t with outputs %v; want stopped with [5]", r.Halted, r.Outputs)
+	}
+}